@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/spf13/cobra"
+	"github.com/weaklayer/gateway/common/configsig"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Utilities for working with Weaklayer Gateway config files",
+}
+
+var configSignOutputPath = ""
+var configSignKeyID = ""
+
+var configSignCmd = &cobra.Command{
+	Use:   "sign <config-file> <private-key-file>",
+	Short: "Produce a detached JWS signature for a config file",
+	Args:  cobra.ExactArgs(2),
+	RunE:  configSignCmdRun,
+}
+
+func configSignCmdRun(cmd *cobra.Command, args []string) error {
+	configFilePath := args[0]
+	privateKeyPath := args[1]
+
+	configBytes, err := ioutil.ReadFile(configFilePath)
+	if err != nil {
+		return fmt.Errorf("Failed to read config file: %w", err)
+	}
+
+	privateKey, alg, err := configsig.LoadSigningKey(privateKeyPath)
+	if err != nil {
+		return err
+	}
+
+	signatureBytes, err := configsig.SignConfig(configBytes, privateKey, alg, configSignKeyID)
+	if err != nil {
+		return err
+	}
+
+	outputPath := configSignOutputPath
+	if outputPath == "" {
+		outputPath = configFilePath + ".sig"
+	}
+
+	err = ioutil.WriteFile(outputPath, signatureBytes, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to write config signature file: %w", err)
+	}
+
+	printedBytes, err := fmt.Printf("Wrote detached config signature to %s\n", outputPath)
+	if err != nil {
+		return fmt.Errorf("Failed to print confirmation message: %w", err)
+	}
+
+	if printedBytes == 0 {
+		return fmt.Errorf("Failed to print confirmation message")
+	}
+
+	return nil
+}
+
+func init() {
+	configSignCmd.Flags().StringVar(&configSignOutputPath, "output", "", "Path to write the detached signature to. Defaults to <config-file>.sig")
+	configSignCmd.Flags().StringVar(&configSignKeyID, "key-id", "", "Key ID to embed in the signature's 'kid' header, for JWKS-based verification")
+
+	configCmd.AddCommand(configSignCmd)
+	rootCmd.AddCommand(configCmd)
+}
@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/weaklayer/gateway/common/envelope"
+	"github.com/weaklayer/gateway/server/output/filesystem"
+)
+
+var decryptType string
+var decryptPrivateKeyPath string
+var decryptCertificatePath string
+var decryptProviderName string
+var decryptProviderEndpoint string
+
+var decryptCmd = &cobra.Command{
+	Use:   "decrypt <encrypted-file>",
+	Short: "Decrypt an encrypted filesystem output file and stream its events to stdout",
+	Args:  cobra.ExactArgs(1),
+	RunE:  decryptCmdRun,
+}
+
+func decryptCmdRun(cmd *cobra.Command, args []string) error {
+	if decryptType == "" {
+		return fmt.Errorf("Must specify the recipient type the file was encrypted for (--type)")
+	}
+
+	unwrapKey := envelope.UnwrapKey{
+		Type:             decryptType,
+		PrivateKeyPath:   decryptPrivateKeyPath,
+		CertificatePath:  decryptCertificatePath,
+		ProviderName:     decryptProviderName,
+		ProviderEndpoint: decryptProviderEndpoint,
+	}
+
+	return filesystem.DecryptFile(args[0], unwrapKey, os.Stdout)
+}
+
+func init() {
+	decryptCmd.Flags().StringVar(&decryptType, "type", "", "Key wrapping scheme the file was encrypted with: jwe, pkcs7, or keyprovider")
+	decryptCmd.Flags().StringVar(&decryptPrivateKeyPath, "private-key", "", "Path to a PEM-encoded private key, for --type jwe, or paired with --certificate for --type pkcs7")
+	decryptCmd.Flags().StringVar(&decryptCertificatePath, "certificate", "", "Path to a PEM-encoded X.509 certificate, for --type pkcs7")
+	decryptCmd.Flags().StringVar(&decryptProviderName, "provider-name", "", "Name of the external key-wrapping service, for --type keyprovider")
+	decryptCmd.Flags().StringVar(&decryptProviderEndpoint, "provider-endpoint", "", "Endpoint of the external key-wrapping service, for --type keyprovider")
+
+	rootCmd.AddCommand(decryptCmd)
+}
@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"github.com/weaklayer/gateway/server/token"
+)
+
+var revokeStorePath string
+var revokeSensorArg string
+var revokeGroupArg string
+
+var revokeCmd = &cobra.Command{
+	Use:   "revoke",
+	Short: "Append a sensor or group revocation to a sensor token revocation store",
+	RunE:  revokeCmdRun,
+}
+
+func revokeCmdRun(cmd *cobra.Command, args []string) error {
+	if revokeStorePath == "" {
+		return fmt.Errorf("Must specify the revocation store to append to (--store)")
+	}
+
+	if revokeSensorArg == "" && revokeGroupArg == "" {
+		return fmt.Errorf("Must specify a sensor (--sensor) or a group (--group) to revoke")
+	}
+	if revokeSensorArg != "" && revokeGroupArg != "" {
+		return fmt.Errorf("Must specify only one of --sensor or --group")
+	}
+
+	revokedAt := time.Now().Unix()
+
+	if revokeSensorArg != "" {
+		sensor, err := uuid.Parse(revokeSensorArg)
+		if err != nil {
+			return fmt.Errorf(`Could not parse "%s" as a UUID: %w`, revokeSensorArg, err)
+		}
+		return token.RevokeSensor(revokeStorePath, sensor, revokedAt)
+	}
+
+	group, err := uuid.Parse(revokeGroupArg)
+	if err != nil {
+		return fmt.Errorf(`Could not parse "%s" as a UUID: %w`, revokeGroupArg, err)
+	}
+	return token.RevokeGroup(revokeStorePath, group, revokedAt)
+}
+
+func init() {
+	revokeCmd.Flags().StringVar(&revokeStorePath, "store", "", "Path to the revocation store, matching sensor.token.revocation.path in the server config")
+	revokeCmd.Flags().StringVar(&revokeSensorArg, "sensor", "", "UUID of the sensor to revoke")
+	revokeCmd.Flags().StringVar(&revokeGroupArg, "group", "", "UUID of the group to revoke")
+
+	rootCmd.AddCommand(revokeCmd)
+}
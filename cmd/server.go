@@ -21,6 +21,8 @@ package cmd
 
 import (
 	"fmt"
+	"io/ioutil"
+	"strconv"
 	"strings"
 
 	"encoding/json"
@@ -28,6 +30,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/weaklayer/gateway/common/auth"
+	"github.com/weaklayer/gateway/common/configsig"
 	"github.com/weaklayer/gateway/server"
 	"github.com/xeipuuv/gojsonschema"
 )
@@ -41,7 +44,31 @@ type stringsConfig struct {
 				Certificate string `json:"certificate,omitempty"`
 				Key         string `json:"key,omitempty"`
 				Password    string `json:"password,omitempty"`
+				ACME        struct {
+					Enabled              bool     `json:"enabled,omitempty"`
+					Hosts                []string `json:"hosts,omitempty"`
+					CacheDir             string   `json:"cacheDir,omitempty"`
+					Email                string   `json:"email,omitempty"`
+					DirectoryURL         string   `json:"directoryURL,omitempty"`
+					TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed,omitempty"`
+					HTTPChallengePort    int32    `json:"httpChallengePort,omitempty"`
+				} `json:"acme,omitempty"`
+				ClientAuth struct {
+					Enabled  bool   `json:"enabled,omitempty"`
+					CABundle string `json:"caBundle,omitempty"`
+					OCSP     struct {
+						Enabled           bool   `json:"enabled,omitempty"`
+						ResponderOverride string `json:"responderOverride,omitempty"`
+						Timeout           int64  `json:"timeout,omitempty"`
+						SoftFail          bool   `json:"softFail,omitempty"`
+					} `json:"ocsp,omitempty"`
+				} `json:"clientAuth,omitempty"`
 			} `json:"https,omitempty"`
+			MaxRequestBodySize int64 `json:"maxRequestBodySize,omitempty"`
+			Events             struct {
+				ChunkSize     int   `json:"chunkSize,omitempty"`
+				ChunkInterval int64 `json:"chunkInterval,omitempty"`
+			} `json:"events,omitempty"`
 		} `json:"api,omitempty"`
 		Token struct {
 			Duration int64 `json:"duration,omitempty"`
@@ -49,6 +76,30 @@ type stringsConfig struct {
 				Current string   `json:"current,omitempty"`
 				Past    []string `json:"past,omitempty"`
 			} `json:"secrets,omitempty"`
+			PKCS11 struct {
+				Enabled           bool     `json:"enabled,omitempty"`
+				ModulePath        string   `json:"modulePath,omitempty"`
+				SlotID            *uint    `json:"slotID,omitempty"`
+				SlotLabel         string   `json:"slotLabel,omitempty"`
+				PIN               string   `json:"pin,omitempty"`
+				PINEnv            string   `json:"pinEnv,omitempty"`
+				PINFile           string   `json:"pinFile,omitempty"`
+				ActiveKeyLabel    string   `json:"activeKeyLabel,omitempty"`
+				PreviousKeyLabels []string `json:"previousKeyLabels,omitempty"`
+			} `json:"pkcs11,omitempty"`
+			Asymmetric struct {
+				Enabled bool `json:"enabled,omitempty"`
+				Current struct {
+					PrivateKeyPath string `json:"privateKeyPath,omitempty"`
+				} `json:"current,omitempty"`
+				Past []struct {
+					PublicKeyPath string `json:"publicKeyPath,omitempty"`
+				} `json:"past,omitempty"`
+			} `json:"asymmetric,omitempty"`
+			Revocation struct {
+				Enabled bool   `json:"enabled,omitempty"`
+				Path    string `json:"path,omitempty"`
+			} `json:"revocation,omitempty"`
 		} `json:"token,omitempty"`
 		Install struct {
 			Verifiers []struct {
@@ -57,14 +108,118 @@ type stringsConfig struct {
 				Hash     string `json:"hash,omitempty"`
 				Checksum string `json:"checksum,omitempty"`
 			} `json:"verifiers,omitempty"`
+			VerifierStore struct {
+				Type string `json:"type,omitempty"`
+				File struct {
+					Path string `json:"path,omitempty"`
+				} `json:"file,omitempty"`
+				HTTP struct {
+					URL     string `json:"url,omitempty"`
+					Timeout int64  `json:"timeout,omitempty"`
+				} `json:"http,omitempty"`
+			} `json:"verifierStore,omitempty"`
+			OIDC struct {
+				Issuers []struct {
+					IssuerURL      string `json:"issuerURL,omitempty"`
+					Audience       string `json:"audience,omitempty"`
+					GroupClaim     string `json:"groupClaim,omitempty"`
+					RequiredClaims []struct {
+						Claim  string `json:"claim,omitempty"`
+						Equals string `json:"equals,omitempty"`
+						Prefix string `json:"prefix,omitempty"`
+					} `json:"requiredClaims,omitempty"`
+				} `json:"issuers,omitempty"`
+			} `json:"oidc,omitempty"`
 		} `json:"install,omitempty"`
+		Enroll struct {
+			VerificationURI string `json:"verificationURI,omitempty"`
+			Interval        int64  `json:"interval,omitempty"`
+			TTL             int64  `json:"ttl,omitempty"`
+		} `json:"enroll,omitempty"`
 	} `json:"sensor,omitempty"`
+	Signing struct {
+		Enabled bool `json:"enabled,omitempty"`
+		Current struct {
+			PrivateKeyPath string `json:"privateKeyPath,omitempty"`
+		} `json:"current,omitempty"`
+		Past []struct {
+			PublicKeyPath string `json:"publicKeyPath,omitempty"`
+		} `json:"past,omitempty"`
+	} `json:"signing,omitempty"`
 	Outputs []struct {
-		Type      string `json:"type,omitempty"`
-		Directory string `json:"directory,omitempty"`
-		Age       int64  `json:"age,omitempty"`
-		Size      int    `json:"size,omitempty"`
+		Type          string `json:"type,omitempty"`
+		Directory     string `json:"directory,omitempty"`
+		Age           int64  `json:"age,omitempty"`
+		Size          int    `json:"size,omitempty"`
+		HighWaterMark int    `json:"highWaterMark,omitempty"`
+		Format        string `json:"format,omitempty"`
+		Encryption    struct {
+			Enabled    bool `json:"enabled,omitempty"`
+			Recipients []struct {
+				Type             string `json:"type,omitempty"`
+				PublicKeyPath    string `json:"publicKeyPath,omitempty"`
+				CertificatePath  string `json:"certificatePath,omitempty"`
+				ProviderName     string `json:"providerName,omitempty"`
+				ProviderEndpoint string `json:"providerEndpoint,omitempty"`
+			} `json:"recipients,omitempty"`
+		} `json:"encryption,omitempty"`
+		Sync     bool `json:"sync,omitempty"`
+		Rotation struct {
+			Compression string `json:"compression,omitempty"`
+			Retention   struct {
+				MaxBytes int64 `json:"maxBytes,omitempty"`
+				MaxAge   int64 `json:"maxAge,omitempty"`
+			} `json:"retention,omitempty"`
+			ContentAddressedNaming bool `json:"contentAddressedNaming,omitempty"`
+			Archive                struct {
+				Type      string `json:"type,omitempty"`
+				Directory string `json:"directory,omitempty"`
+			} `json:"archive,omitempty"`
+		} `json:"rotation,omitempty"`
+		DirMode string `json:"dirMode,omitempty"`
+		Kafka struct {
+			Enabled       bool     `json:"enabled,omitempty"`
+			Brokers       []string `json:"brokers,omitempty"`
+			Topic         string   `json:"topic,omitempty"`
+			TopicTemplate string   `json:"topicTemplate,omitempty"`
+			Compression   string   `json:"compression,omitempty"`
+			TLS           struct {
+				Enabled bool `json:"enabled,omitempty"`
+			} `json:"tls,omitempty"`
+			SASL struct {
+				Mechanism string `json:"mechanism,omitempty"`
+				Username  string `json:"username,omitempty"`
+				Password  string `json:"password,omitempty"`
+			} `json:"sasl,omitempty"`
+			HighWaterMark int `json:"highWaterMark,omitempty"`
+		} `json:"kafka,omitempty"`
+		Elasticsearch struct {
+			Enabled       bool   `json:"enabled,omitempty"`
+			URL           string `json:"url,omitempty"`
+			IndexPrefix   string `json:"indexPrefix,omitempty"`
+			Username      string `json:"username,omitempty"`
+			Password      string `json:"password,omitempty"`
+			HighWaterMark int    `json:"highWaterMark,omitempty"`
+		} `json:"elasticsearch,omitempty"`
+		S3 struct {
+			Enabled       bool   `json:"enabled,omitempty"`
+			Bucket        string `json:"bucket,omitempty"`
+			Prefix        string `json:"prefix,omitempty"`
+			Region        string `json:"region,omitempty"`
+			HighWaterMark int    `json:"highWaterMark,omitempty"`
+		} `json:"s3,omitempty"`
 	} `json:"outputs,omitempty"`
+	Operator struct {
+		Tail struct {
+			Tokens []string `json:"tokens,omitempty"`
+		} `json:"tail,omitempty"`
+	} `json:"operator,omitempty"`
+	Telemetry struct {
+		Enabled        bool   `json:"enabled,omitempty"`
+		MetricsAddress string `json:"metricsAddress,omitempty"`
+		OTLPEndpoint   string `json:"otlpEndpoint,omitempty"`
+		OTLPInsecure   bool   `json:"otlpInsecure,omitempty"`
+	} `json:"telemetry,omitempty"`
 }
 
 var configJSONSchema = fmt.Sprintf(`
@@ -81,7 +236,7 @@ var configJSONSchema = fmt.Sprintf(`
 
 				"token": {
 					"type": "object",
-					"required": ["secrets", "duration"],
+					"required": ["duration"],
 					"properties": {
 						"duration": {
 							"type": "integer",
@@ -90,7 +245,7 @@ var configJSONSchema = fmt.Sprintf(`
 						},
 						"secrets": {
 							"type": "object",
-							"required": ["current"],
+							"description": "Raw HMAC secrets used to sign and verify sensor auth tokens. Ignored if pkcs11 is enabled.",
 							"properties": {
 								"current": {
 									"type": "string",
@@ -108,6 +263,103 @@ var configJSONSchema = fmt.Sprintf(`
 									}
 								}
 							}
+						},
+						"pkcs11": {
+							"type": "object",
+							"description": "Config for signing and verifying sensor auth tokens with a persistent key held in a PKCS#11-backed HSM, instead of the raw secrets under secrets.",
+							"required": ["enabled"],
+							"properties": {
+								"enabled": {
+									"type": "boolean",
+									"description": "Whether to sign and verify sensor auth tokens with the PKCS#11 token described here instead of secrets."
+								},
+								"modulePath": {
+									"type": "string",
+									"example": "/usr/lib/softhsm/libsofthsm2.so",
+									"description": "Filesystem path to the PKCS#11 module (.so) provided by the HSM vendor."
+								},
+								"slotID": {
+									"type": "integer",
+									"minimum": 0,
+									"description": "Selects the token slot by id. Takes precedence over slotLabel if both are set."
+								},
+								"slotLabel": {
+									"type": "string",
+									"description": "Selects the token slot by its label, if slotID isn't set."
+								},
+								"pin": {
+									"type": "string",
+									"description": "The login PIN, used as-is if set."
+								},
+								"pinEnv": {
+									"type": "string",
+									"description": "Names an environment variable holding the login PIN, used if pin isn't set."
+								},
+								"pinFile": {
+									"type": "string",
+									"description": "Path to a file holding the login PIN, used if neither pin nor pinEnv are set."
+								},
+								"activeKeyLabel": {
+									"type": "string",
+									"description": "The CKA_LABEL of the persistent key used to sign new tokens."
+								},
+								"previousKeyLabels": {
+									"type": "array",
+									"items": {
+										"type": "string"
+									},
+									"description": "CKA_LABELs of persistent keys still accepted when verifying tokens during key rotation."
+								}
+							}
+						},
+						"asymmetric": {
+							"type": "object",
+							"description": "Config for signing and verifying sensor auth tokens with a local RSA, ECDSA, or Ed25519 key pair, instead of the raw secrets under secrets. The algorithm (RS256, ES256, or EdDSA) is inferred from current.privateKeyPath's key type. Enables publishing public keys at /v1/jwks for offline verification.",
+							"required": ["enabled"],
+							"properties": {
+								"enabled": {
+									"type": "boolean",
+									"description": "Whether to sign and verify sensor auth tokens with the asymmetric key pair described here instead of secrets."
+								},
+								"current": {
+									"type": "object",
+									"properties": {
+										"privateKeyPath": {
+											"type": "string",
+											"example": "/home/weaklayer/token-signing-key.pem",
+											"description": "Path to a PEM-encoded RSA, ECDSA, or Ed25519 private key used to sign new tokens."
+										}
+									}
+								},
+								"past": {
+									"type": "array",
+									"items": {
+										"type": "object",
+										"properties": {
+											"publicKeyPath": {
+												"type": "string",
+												"description": "Path to a PEM-encoded public key still accepted when verifying tokens during key rotation."
+											}
+										}
+									}
+								}
+							}
+						},
+						"revocation": {
+							"type": "object",
+							"description": "Config for rejecting sensor auth tokens whose sensor or group has been revoked via 'weaklayer-gateway revoke'.",
+							"required": ["enabled"],
+							"properties": {
+								"enabled": {
+									"type": "boolean",
+									"description": "Whether to reject /events tokens for revoked sensors/groups."
+								},
+								"path": {
+									"type": "string",
+									"example": "/home/weaklayer/revocations.jsonl",
+									"description": "Path to the revocation store. Created if it does not yet exist, and reloaded on SIGHUP."
+								}
+							}
 						}
 					}
 				},
@@ -118,6 +370,124 @@ var configJSONSchema = fmt.Sprintf(`
 						"verifiers": {
 							"type": "array",
 							"items": %s
+						},
+						"verifierStore": {
+							"type": "object",
+							"description": "Selects where install Verifiers are looked up from. Defaults to the in-memory store seeded from verifiers.",
+							"properties": {
+								"type": {
+									"type": "string",
+									"enum": ["memory", "file", "http"],
+									"default": "memory",
+									"description": "\"memory\" looks verifiers up from the verifiers list above, and is the only option device-code enrollment can register new verifiers into. \"file\" reloads a JSON file of verifiers on SIGHUP without a gateway restart. \"http\" queries a remote lookup service per group, for multi-tenant deployments where loading every group's verifiers at startup is infeasible."
+								},
+								"file": {
+									"type": "object",
+									"properties": {
+										"path": {
+											"type": "string",
+											"example": "/home/weaklayer/verifiers.json",
+											"description": "Path to a JSON file holding an array of verifiers, the same shape as sensor.install.verifiers."
+										}
+									}
+								},
+								"http": {
+									"type": "object",
+									"properties": {
+										"url": {
+											"type": "string",
+											"format": "uri",
+											"example": "https://verifiers.internal/groups",
+											"description": "Base URL of the lookup service. The requested group's UUID is appended as the final path segment."
+										},
+										"timeout": {
+											"type": "integer",
+											"minimum": 1,
+											"example": 5000000,
+											"description": "Number of microseconds a single lookup request is allowed to take before failing."
+										}
+									}
+								}
+							}
+						},
+						"oidc": {
+							"type": "object",
+							"description": "Config for enrolling sensors with OIDC ID tokens instead of install keys.",
+							"properties": {
+								"issuers": {
+									"type": "array",
+									"items": {
+										"type": "object",
+										"required": ["issuerURL", "audience", "groupClaim"],
+										"properties": {
+											"issuerURL": {
+												"type": "string",
+												"format": "uri",
+												"example": "https://accounts.google.com",
+												"description": "The OIDC issuer's base URL. Its discovery document is fetched from {issuerURL}/.well-known/openid-configuration."
+											},
+											"audience": {
+												"type": "string",
+												"description": "The expected 'aud' claim value, typically the OIDC client ID registered with the issuer."
+											},
+											"groupClaim": {
+												"type": "string",
+												"example": "weaklayer_group",
+												"description": "The ID token claim holding the sensor group UUID a verified sensor should install into."
+											},
+											"requiredClaims": {
+												"type": "array",
+												"description": "Extra constraints an ID token's claims must satisfy, e.g. to scope an issuer to one tenant or workload identity subject prefix.",
+												"items": {
+													"type": "object",
+													"required": ["claim"],
+													"properties": {
+														"claim": {
+															"type": "string",
+															"example": "tenant",
+															"description": "The ID token claim to constrain."
+														},
+														"equals": {
+															"type": "string",
+															"description": "The exact value the claim must have."
+														},
+														"prefix": {
+															"type": "string",
+															"example": "repo:my-org/",
+															"description": "A prefix the claim's value must start with."
+														}
+													}
+												}
+											}
+										}
+									}
+								}
+							}
+						}
+					}
+				},
+
+				"enroll": {
+					"type": "object",
+					"description": "Config for the device-code sensor enrollment flow at /v1/enroll.",
+					"properties": {
+						"verificationURI": {
+							"type": "string",
+							"format": "uri",
+							"example": "https://gateway.example.com/enroll",
+							"description": "The operator-facing URL returned to sensors enrolling via the device-code flow."
+						},
+						"interval": {
+							"type": "integer",
+							"minimum": 1,
+							"example": 5,
+							"description": "The number of seconds a sensor should wait between polls of /v1/enroll/token."
+						},
+						"ttl": {
+							"type": "integer",
+							"minimum": 1,
+							"example": 600000000,
+							"description": "The number of microseconds a device-code enrollment remains valid for before it expires."
 						}
 					}
 				},
@@ -157,6 +527,117 @@ var configJSONSchema = fmt.Sprintf(`
 									"type": "string",
 									"example": "examplekeypassword",
 									"description": "Password for decrypting the private key (if applicable)."
+								},
+								"acme": {
+									"type": "object",
+									"description": "Config values for automatically provisioning a certificate via an ACME directory such as Let's Encrypt, instead of loading a static certificate and key.",
+									"required": ["enabled"],
+									"properties": {
+										"enabled": {
+											"type": "boolean",
+											"description": "Whether to provision the TLS certificate automatically via ACME rather than from the certificate/key files."
+										},
+										"hosts": {
+											"type": "array",
+											"items": {
+												"type": "string",
+												"format": "hostname"
+											},
+											"example": ["gateway.example.com"],
+											"description": "The hostnames that the ACME HostPolicy will issue certificates for."
+										},
+										"cacheDir": {
+											"type": "string",
+											"example": "/home/weaklayer/acme-cache",
+											"description": "Directory where the ACME account key and issued certificates are cached between restarts."
+										},
+										"email": {
+											"type": "string",
+											"format": "email",
+											"description": "Contact email address registered with the ACME account."
+										},
+										"directoryURL": {
+											"type": "string",
+											"format": "uri",
+											"description": "The ACME directory URL to use. Defaults to the Let's Encrypt production directory."
+										},
+										"termsOfServiceAgreed": {
+											"type": "boolean",
+											"description": "Must be set to true to confirm the operator agrees to the ACME CA's terms of service. Certificate issuance is refused otherwise."
+										},
+										"httpChallengePort": {
+											"type": "integer",
+											"minimum": 0,
+											"maximum": 65535,
+											"example": 80,
+											"description": "The port that the ACME HTTP-01 challenge solver listens on. Defaults to 80."
+										}
+									},
+									"clientAuth": {
+										"type": "object",
+										"description": "Config values for requiring and verifying sensor mutual TLS client certificates.",
+										"required": ["enabled"],
+										"properties": {
+											"enabled": {
+												"type": "boolean",
+												"description": "Whether to require sensors to present a client certificate signed by caBundle."
+											},
+											"caBundle": {
+												"type": "string",
+												"example": "/home/weaklayer/client-ca-bundle.pem",
+												"description": "Path to a PEM bundle of CA certificates that sensor client certificates are verified against."
+											},
+											"ocsp": {
+												"type": "object",
+												"description": "Config for checking presented sensor client certificates against an OCSP responder.",
+												"properties": {
+													"enabled": {
+														"type": "boolean",
+														"description": "Whether to check sensor client certificates for revocation via OCSP."
+													},
+													"responderOverride": {
+														"type": "string",
+														"format": "uri",
+														"description": "OCSP responder URL to use instead of the one in the client certificate's Authority Information Access extension."
+													},
+													"timeout": {
+														"type": "integer",
+														"minimum": 1,
+														"example": 5000000,
+														"description": "The number of microseconds to wait for an OCSP responder to answer before timing out."
+													},
+													"softFail": {
+														"type": "boolean",
+														"description": "Whether to allow the handshake to proceed when the OCSP responder cannot be reached."
+													}
+												}
+											}
+										}
+									}
+								}
+							}
+						},
+						"maxRequestBodySize": {
+							"type": "integer",
+							"minimum": 1,
+							"example": 10000000,
+							"description": "The maximum number of bytes accepted in a sensor API request body."
+						},
+						"events": {
+							"type": "object",
+							"description": "Config for batching NDJSON /events uploads before handing them off for processing.",
+							"properties": {
+								"chunkSize": {
+									"type": "integer",
+									"minimum": 1,
+									"example": 1000,
+									"description": "The maximum number of NDJSON events buffered before being handed off for processing."
+								},
+								"chunkInterval": {
+									"type": "integer",
+									"minimum": 1,
+									"example": 1000000,
+									"description": "The maximum number of microseconds NDJSON events are buffered before being handed off for processing."
 								}
 							}
 						}
@@ -164,6 +645,39 @@ var configJSONSchema = fmt.Sprintf(`
 				}
 			}
 		},
+		"signing": {
+			"type": "object",
+			"description": "Config for signing install responses and archived events with the gateway's own RSA, ECDSA, or Ed25519 key pair, giving downstream consumers a way to detect tampering independent of the channel they received them over. The algorithm (RS256, ES256, or EdDSA) is inferred from current.privateKeyPath's key type. Enables publishing public keys at /v1/jwks for offline verification.",
+			"required": ["enabled"],
+			"properties": {
+				"enabled": {
+					"type": "boolean",
+					"description": "Whether to sign install responses and archived events with the gateway signing key pair described here."
+				},
+				"current": {
+					"type": "object",
+					"properties": {
+						"privateKeyPath": {
+							"type": "string",
+							"example": "/home/weaklayer/gateway-signing-key-private.pem",
+							"description": "Path to a PEM-encoded RSA, ECDSA, or Ed25519 private key used to sign install responses and archived events."
+						}
+					}
+				},
+				"past": {
+					"type": "array",
+					"items": {
+						"type": "object",
+						"properties": {
+							"publicKeyPath": {
+								"type": "string",
+								"description": "Path to a PEM-encoded public key retired from signing, still published at /v1/jwks so previously signed data can be verified."
+							}
+						}
+					}
+				}
+			}
+		},
 		"outputs": {
 			"type": "array",
 			"items": {
@@ -172,7 +686,7 @@ var configJSONSchema = fmt.Sprintf(`
 				"properties": {
 					"type": {
 						"type": "string",
-						"enum": ["stdout", "filesystem"],
+						"enum": ["stdout", "filesystem", "kafka", "elasticsearch", "s3"],
 						"example": "stdout",
 						"description": "The type of output to configure"
 					},
@@ -192,15 +706,316 @@ var configJSONSchema = fmt.Sprintf(`
 						"minimum": 1,
 						"example": 250000000,
 						"description": "The file size, in bytes, that the filesystem output will close files at"
+					},
+					"highWaterMark": {
+						"type": "integer",
+						"minimum": 1,
+						"example": 8000,
+						"description": "The queue depth, for the stdout and filesystem outputs, at which events start being rejected with a 503 instead of queued. Defaults to 80% of the queue's capacity."
+					},
+					"format": {
+						"type": "string",
+						"enum": ["json", "parquet"],
+						"example": "json",
+						"description": "The file format for filesystem output. 'json' (the default) writes a JSON array of events. 'parquet' writes Snappy-compressed Parquet rows for analytics-friendly archives, and cannot be combined with encryption."
+					},
+					"encryption": {
+						"type": "object",
+						"description": "Envelope-encrypt filesystem output files at rest. Modeled on OCI image envelope encryption: a fresh content-encryption key protects each file, and the key is wrapped for every configured recipient.",
+						"properties": {
+							"enabled": {
+								"type": "boolean",
+								"example": true,
+								"description": "Whether to encrypt filesystem output files"
+							},
+							"recipients": {
+								"type": "array",
+								"items": {
+									"type": "object",
+									"required": ["type"],
+									"properties": {
+										"type": {
+											"type": "string",
+											"enum": ["jwe", "pkcs7", "keyprovider"],
+											"example": "jwe",
+											"description": "The content-encryption key wrapping scheme for this recipient"
+										},
+										"publicKeyPath": {
+											"type": "string",
+											"example": "./recipient-public.pem",
+											"description": "Path to a PEM-encoded RSA or EC public key, for recipients of type 'jwe'"
+										},
+										"certificatePath": {
+											"type": "string",
+											"example": "./recipient-cert.pem",
+											"description": "Path to a PEM-encoded X.509 certificate, for recipients of type 'pkcs7'"
+										},
+										"providerName": {
+											"type": "string",
+											"example": "my-kms",
+											"description": "Name of the external key-wrapping service, for recipients of type 'keyprovider'"
+										},
+										"providerEndpoint": {
+											"type": "string",
+											"example": "http://localhost:9000/wrap",
+											"description": "Endpoint of the external key-wrapping service, for recipients of type 'keyprovider'"
+										}
+									}
+								}
+							}
+						}
+					},
+					"sync": {
+						"type": "boolean",
+						"description": "Whether the filesystem output fsyncs after every write. Trades throughput for durability."
+					},
+					"rotation": {
+						"type": "object",
+						"description": "What happens to a filesystem output file once it is rotated out of.",
+						"properties": {
+							"compression": {
+								"type": "string",
+								"enum": ["gzip", "zstd"],
+								"example": "zstd",
+								"description": "Codec rotated files are compressed with. Omit for no compression."
+							},
+							"retention": {
+								"type": "object",
+								"description": "Bounds how much of a group's rotated output is kept on disk. Oldest files are deleted first.",
+								"properties": {
+									"maxBytes": {
+										"type": "integer",
+										"minimum": 1,
+										"example": 10000000000,
+										"description": "Maximum total size, in bytes, of rotated files kept in a group directory."
+									},
+									"maxAge": {
+										"type": "integer",
+										"minimum": 1,
+										"example": 2592000000000,
+										"description": "Maximum age, in microseconds, a rotated file is kept on disk before being deleted."
+									}
+								}
+							},
+							"contentAddressedNaming": {
+								"type": "boolean",
+								"example": true,
+								"description": "Rename a finalized rotated file to events-<sha256[:16]><extension>, derived from its own content, once it is done being written (and compressed, if configured). Gives downstream consumers a stable, idempotent name to dedup uploads against."
+							},
+							"archive": {
+								"type": "object",
+								"description": "Uploads each finalized rotated file to a block store, in addition to leaving it on disk.",
+								"properties": {
+									"type": {
+										"type": "string",
+										"enum": ["local", "memory"],
+										"example": "local",
+										"description": "Selects the block store backend. Omit to disable archiving."
+									},
+									"directory": {
+										"type": "string",
+										"example": "/var/weaklayer/archive",
+										"description": "Root directory blocks are stored under, for type 'local'."
+									}
+								}
+							}
+						}
+					},
+					"dirMode": {
+						"type": "string",
+						"pattern": "^[0-7]{3,4}$",
+						"example": "0750",
+						"description": "Octal permission mode that the filesystem output's group and shard subdirectories are created with. Defaults to \"0755\"."
+					},
+					"kafka": {
+						"type": "object",
+						"description": "Config for producing events to a Kafka topic, for output type 'kafka'",
+						"properties": {
+							"enabled": {
+								"type": "boolean",
+								"description": "Whether to produce events to Kafka"
+							},
+							"brokers": {
+								"type": "array",
+								"items": {
+									"type": "string"
+								},
+								"example": ["kafka-1.example.com:9092"],
+								"description": "Addresses of the Kafka brokers to bootstrap against"
+							},
+							"topic": {
+								"type": "string",
+								"example": "weaklayer-events",
+								"description": "The Kafka topic to produce events to"
+							},
+							"topicTemplate": {
+								"type": "string",
+								"example": "weaklayer-events-{group}",
+								"description": "Overrides topic with a per-group topic name. Every occurrence of the literal '{group}' is replaced with the event's group UUID, so events route to group-specific topics."
+							},
+							"compression": {
+								"type": "string",
+								"enum": ["", "gzip", "snappy", "lz4", "zstd"],
+								"example": "snappy",
+								"description": "Compression codec to produce messages with. Defaults to no compression."
+							},
+							"tls": {
+								"type": "object",
+								"description": "TLS configuration for connecting to the Kafka brokers",
+								"properties": {
+									"enabled": {
+										"type": "boolean",
+										"example": true,
+										"description": "Whether to connect to the Kafka brokers over TLS"
+									}
+								}
+							},
+							"sasl": {
+								"type": "object",
+								"description": "SASL authentication for connecting to the Kafka brokers",
+								"properties": {
+									"mechanism": {
+										"type": "string",
+										"enum": ["", "plain", "scram-sha-256", "scram-sha-512"],
+										"example": "scram-sha-512",
+										"description": "The SASL mechanism to authenticate with. Leave empty to disable SASL."
+									},
+									"username": {
+										"type": "string",
+										"description": "SASL username"
+									},
+									"password": {
+										"type": "string",
+										"description": "SASL password"
+									}
+								}
+							},
+							"highWaterMark": {
+								"type": "integer",
+								"minimum": 1,
+								"example": 8000,
+								"description": "The event batch queue depth at which events start being rejected with a 503 instead of queued. Defaults to 80% of the queue's capacity."
+							}
+						}
+					},
+					"elasticsearch": {
+						"type": "object",
+						"description": "Config for indexing events into Elasticsearch, for output type 'elasticsearch'",
+						"properties": {
+							"enabled": {
+								"type": "boolean",
+								"description": "Whether to index events into Elasticsearch"
+							},
+							"url": {
+								"type": "string",
+								"format": "uri",
+								"example": "https://elasticsearch.example.com:9200",
+								"description": "The base URL of the Elasticsearch cluster"
+							},
+							"indexPrefix": {
+								"type": "string",
+								"example": "weaklayer-events",
+								"description": "Prefix for the daily index events are written to. Defaults to 'weaklayer-events'."
+							},
+							"username": {
+								"type": "string",
+								"description": "Username for HTTP basic auth against Elasticsearch, if required"
+							},
+							"password": {
+								"type": "string",
+								"description": "Password for HTTP basic auth against Elasticsearch, if required"
+							},
+							"highWaterMark": {
+								"type": "integer",
+								"minimum": 1,
+								"example": 8000,
+								"description": "The event batch queue depth at which events start being rejected with a 503 instead of queued. Defaults to 80% of the queue's capacity."
+							}
+						}
+					},
+					"s3": {
+						"type": "object",
+						"description": "Config for writing rolled newline-delimited JSON objects to S3, for output type 's3'",
+						"properties": {
+							"enabled": {
+								"type": "boolean",
+								"description": "Whether to write events to S3"
+							},
+							"bucket": {
+								"type": "string",
+								"example": "weaklayer-events",
+								"description": "The S3 bucket to write objects to"
+							},
+							"prefix": {
+								"type": "string",
+								"example": "events",
+								"description": "Key prefix for uploaded objects"
+							},
+							"region": {
+								"type": "string",
+								"example": "us-east-1",
+								"description": "The AWS region of the bucket"
+							},
+							"highWaterMark": {
+								"type": "integer",
+								"minimum": 1,
+								"example": 8000,
+								"description": "The per-group queue depth at which events start being rejected with a 503 instead of queued. Defaults to 80% of the queue's capacity."
+							}
+						}
+					}
+				}
+			}
+		}
+	},
+	"operator": {
+		"type": "object",
+		"description": "Config for the operator-facing /v1/tail live event stream.",
+		"properties": {
+			"tail": {
+				"type": "object",
+				"properties": {
+					"tokens": {
+						"type": "array",
+						"items": {
+							"type": "string"
+						},
+						"description": "Bearer tokens operators use to authenticate to the /v1/tail WebSocket endpoint."
 					}
 				}
 			}
+		},
+		"telemetry": {
+			"type": "object",
+			"description": "Config for OpenTelemetry metrics and tracing. Metrics are served over a separate admin HTTP listener, never on the sensor API listener.",
+			"properties": {
+				"enabled": {
+					"type": "boolean",
+					"description": "Turns on the /metrics admin endpoint and OTLP span export."
+				},
+				"metricsAddress": {
+					"type": "string",
+					"example": "localhost:9090",
+					"description": "The host:port the /metrics admin HTTP listener binds to."
+				},
+				"otlpEndpoint": {
+					"type": "string",
+					"example": "localhost:4318",
+					"description": "The host:port of the OTLP/HTTP trace collector."
+				},
+				"otlpInsecure": {
+					"type": "boolean",
+					"description": "Disables TLS when dialing otlpEndpoint."
+				}
+			}
 		}
 	}
 }
 `, auth.VerifierJSONSchema)
 
 var configFilePath = ""
+var configSignaturePath = ""
+var configVerifyKey = ""
 
 var serverCmd = &cobra.Command{
 	Use:   "server",
@@ -214,6 +1029,12 @@ func serverCmdRun(cmd *cobra.Command, args []string) error {
 	viper.SetDefault("sensor.api.host", "localhost")
 	viper.SetDefault("sensor.api.port", 8080)
 	viper.SetDefault("sensor.token.duration", 2419200000000)
+	viper.SetDefault("sensor.enroll.interval", 5)
+	viper.SetDefault("sensor.enroll.ttl", 600000000)
+	viper.SetDefault("sensor.api.https.acme.httpChallengePort", 80)
+	viper.SetDefault("sensor.api.maxRequestBodySize", 10000000)
+	viper.SetDefault("sensor.api.events.chunkSize", 1000)
+	viper.SetDefault("sensor.api.events.chunkInterval", 1000000)
 
 	if configFilePath != "" {
 		viper.SetConfigFile(configFilePath)
@@ -230,6 +1051,13 @@ func serverCmdRun(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("Failed to read config: %w", err)
 	}
 
+	if configSignaturePath != "" || configVerifyKey != "" {
+		err = verifyConfigSignature(configFilePath, configSignaturePath, configVerifyKey)
+		if err != nil {
+			return fmt.Errorf("Config signature verification failed: %w", err)
+		}
+	}
+
 	var mergedConfig stringsConfig
 
 	err = viper.Unmarshal(&mergedConfig)
@@ -265,6 +1093,41 @@ func serverCmdRun(cmd *cobra.Command, args []string) error {
 	return server.Run(finalConfig)
 }
 
+// verifyConfigSignature checks that the config file at configFilePath is accompanied by a valid
+// detached JWS signature at signaturePath, verifiable under the public key or JWKS endpoint named
+// by verifyKeyArg. A verifyKeyArg starting with "http://" or "https://" is treated as a JWKS URL;
+// otherwise it is a path to a PEM-encoded public key.
+func verifyConfigSignature(configFilePath string, signaturePath string, verifyKeyArg string) error {
+	if signaturePath == "" {
+		return fmt.Errorf("Must specify --config-signature along with --config-verify-key")
+	}
+	if verifyKeyArg == "" {
+		return fmt.Errorf("Must specify --config-verify-key along with --config-signature")
+	}
+
+	configBytes, err := ioutil.ReadFile(configFilePath)
+	if err != nil {
+		return fmt.Errorf("Failed to read config file: %w", err)
+	}
+
+	signatureBytes, err := ioutil.ReadFile(signaturePath)
+	if err != nil {
+		return fmt.Errorf("Failed to read config signature file: %w", err)
+	}
+
+	var verificationKey interface{}
+	if strings.HasPrefix(verifyKeyArg, "http://") || strings.HasPrefix(verifyKeyArg, "https://") {
+		verificationKey, err = configsig.LoadVerificationKey("", verifyKeyArg)
+	} else {
+		verificationKey, err = configsig.LoadVerificationKey(verifyKeyArg, "")
+	}
+	if err != nil {
+		return err
+	}
+
+	return configsig.VerifyDetachedSignature(configBytes, signatureBytes, verificationKey)
+}
+
 func validateConfigJSON(jsonBytes []byte) error {
 
 	schemaLoader := gojsonschema.NewStringLoader(configJSONSchema)
@@ -297,12 +1160,101 @@ func validateConfigStruct(config server.Config) error {
 		}
 	}
 
+	// Device-code enrollment (sensor.enroll.verificationURI) populates the verifier registry at
+	// runtime, so install verifiers/oidc issuers may legitimately both be empty in that case.
+	if config.Sensor.Enroll.VerificationURI == "" {
+		if len(config.Sensor.Install.Verifiers) == 0 && len(config.Sensor.Install.OIDC.Issuers) == 0 && config.Sensor.Install.VerifierStore.Type == "" {
+			return fmt.Errorf("Must configure at least one sensor.install.verifiers entry or sensor.install.oidc.issuers entry, or enable sensor.enroll")
+		}
+	}
+
+	switch config.Sensor.Install.VerifierStore.Type {
+	case "", "memory":
+	case "file":
+		if config.Sensor.Install.VerifierStore.File.Path == "" {
+			return fmt.Errorf("Must specify sensor.install.verifierStore.file.path when sensor.install.verifierStore.type is \"file\"")
+		}
+	case "http":
+		if config.Sensor.Install.VerifierStore.HTTP.URL == "" {
+			return fmt.Errorf("Must specify sensor.install.verifierStore.http.url when sensor.install.verifierStore.type is \"http\"")
+		}
+	default:
+		return fmt.Errorf(`Unsupported sensor.install.verifierStore.type "%s". Must be one of "memory", "file", or "http"`, config.Sensor.Install.VerifierStore.Type)
+	}
+
+	if config.Sensor.Enroll.VerificationURI != "" && config.Sensor.Install.VerifierStore.Type != "" && config.Sensor.Install.VerifierStore.Type != "memory" {
+		return fmt.Errorf("sensor.enroll requires sensor.install.verifierStore.type to be \"memory\", since approved enrollments are registered into it at runtime")
+	}
+
+	if config.Sensor.Token.PKCS11.Enabled && config.Sensor.Token.Asymmetric.Enabled {
+		return fmt.Errorf("Cannot enable both sensor.token.pkcs11 and sensor.token.asymmetric")
+	}
+
+	if config.Sensor.Token.PKCS11.Enabled {
+		if len(config.Sensor.Token.Secrets.Current) > 0 || len(config.Sensor.Token.Secrets.Past) > 0 {
+			return fmt.Errorf("Cannot specify both sensor.token.secrets and sensor.token.pkcs11")
+		}
+		if config.Sensor.Token.PKCS11.ModulePath == "" {
+			return fmt.Errorf("Must specify a PKCS#11 module path (sensor.token.pkcs11.modulePath)")
+		}
+		if config.Sensor.Token.PKCS11.ActiveKeyLabel == "" {
+			return fmt.Errorf("Must specify an active key label (sensor.token.pkcs11.activeKeyLabel)")
+		}
+	} else if config.Sensor.Token.Asymmetric.Enabled {
+		if len(config.Sensor.Token.Secrets.Current) > 0 || len(config.Sensor.Token.Secrets.Past) > 0 {
+			return fmt.Errorf("Cannot specify both sensor.token.secrets and sensor.token.asymmetric")
+		}
+		if config.Sensor.Token.Asymmetric.Current.PrivateKeyPath == "" {
+			return fmt.Errorf("Must specify an active signing key (sensor.token.asymmetric.current.privateKeyPath)")
+		}
+	} else if len(config.Sensor.Token.Secrets.Current) == 0 {
+		return fmt.Errorf("Must specify either sensor.token.secrets.current, sensor.token.pkcs11, or sensor.token.asymmetric")
+	}
+
+	if config.Sensor.Token.Revocation.Enabled && config.Sensor.Token.Revocation.Path == "" {
+		return fmt.Errorf("Must specify a path (sensor.token.revocation.path) to enable revocation checking")
+	}
+
+	if config.Signing.Enabled && config.Signing.Current.PrivateKeyPath == "" {
+		return fmt.Errorf("Must specify an active signing key (signing.current.privateKeyPath)")
+	}
+
+	if config.Telemetry.Enabled {
+		if config.Telemetry.MetricsAddress == "" {
+			return fmt.Errorf("Must specify a metrics listener address (telemetry.metricsAddress) to enable telemetry")
+		}
+		if config.Telemetry.OTLPEndpoint == "" {
+			return fmt.Errorf("Must specify an OTLP trace collector endpoint (telemetry.otlpEndpoint) to enable telemetry")
+		}
+	}
+
 	// check that either both or neither of certificate and private key are specified for https
 	if (config.Sensor.API.HTTPS.Certificate != "" && config.Sensor.API.HTTPS.Key == "") ||
 		(config.Sensor.API.HTTPS.Certificate == "" && config.Sensor.API.HTTPS.Key != "") {
 		return fmt.Errorf("Both a certificate and key must be specified to enable https")
 	}
 
+	if config.Sensor.API.HTTPS.ACME.Enabled {
+		if config.Sensor.API.HTTPS.Certificate != "" || config.Sensor.API.HTTPS.Key != "" {
+			return fmt.Errorf("Cannot specify both a static certificate/key and ACME for https")
+		}
+		if len(config.Sensor.API.HTTPS.ACME.Hosts) == 0 {
+			return fmt.Errorf("Must specify at least one host for ACME https")
+		}
+		if !config.Sensor.API.HTTPS.ACME.TermsOfServiceAgreed {
+			return fmt.Errorf("Must agree to the ACME CA's terms of service (sensor.api.https.acme.termsOfServiceAgreed) to enable ACME https")
+		}
+	}
+
+	if config.Sensor.API.HTTPS.ClientAuth.Enabled {
+		if config.Sensor.API.HTTPS.ClientAuth.CABundle == "" {
+			return fmt.Errorf("Must specify a CA bundle (sensor.api.https.clientAuth.caBundle) to enable sensor mutual TLS")
+		}
+		if config.Sensor.API.HTTPS.ClientAuth.OCSP.Enabled && config.Sensor.API.HTTPS.ClientAuth.OCSP.Timeout < 0 {
+			return fmt.Errorf("OCSP timeout (sensor.api.https.clientAuth.ocsp.timeout) must not be negative")
+		}
+	}
+
 	if len(config.Outputs) == 0 {
 		return fmt.Errorf("Must configure at least one output")
 	}
@@ -322,6 +1274,74 @@ func validateConfigStruct(config server.Config) error {
 			if size <= 0 {
 				return fmt.Errorf("Must specify a strictly positive size for filesystem output at location %d in outputs array", i)
 			}
+			if configOutput.Format != "" && configOutput.Format != "json" && configOutput.Format != "parquet" {
+				return fmt.Errorf("Unknown format %s for filesystem output at location %d in outputs array", configOutput.Format, i)
+			}
+			if configOutput.DirMode != "" {
+				if _, err := strconv.ParseUint(configOutput.DirMode, 8, 32); err != nil {
+					return fmt.Errorf("Invalid octal dirMode %s for filesystem output at location %d in outputs array: %w", configOutput.DirMode, i, err)
+				}
+			}
+			archiveType := configOutput.Rotation.Archive.Type
+			if archiveType != "" && archiveType != "local" && archiveType != "memory" {
+				return fmt.Errorf("Unknown archive type %s for filesystem output at location %d in outputs array", archiveType, i)
+			}
+			if archiveType == "local" && configOutput.Rotation.Archive.Directory == "" {
+				return fmt.Errorf("Must specify a directory for archive type local for filesystem output at location %d in outputs array", i)
+			}
+			if configOutput.Encryption.Enabled {
+				if configOutput.Format == "parquet" {
+					return fmt.Errorf("The parquet format does not support encryption for filesystem output at location %d in outputs array", i)
+				}
+				if len(configOutput.Encryption.Recipients) == 0 {
+					return fmt.Errorf("Must specify at least one recipient to enable encryption for filesystem output at location %d in outputs array", i)
+				}
+				for j, recipient := range configOutput.Encryption.Recipients {
+					if recipient.Type == "jwe" {
+						if recipient.PublicKeyPath == "" {
+							return fmt.Errorf("Must specify a public key path for jwe recipient %d of filesystem output at location %d in outputs array", j, i)
+						}
+					} else if recipient.Type == "pkcs7" {
+						if recipient.CertificatePath == "" {
+							return fmt.Errorf("Must specify a certificate path for pkcs7 recipient %d of filesystem output at location %d in outputs array", j, i)
+						}
+					} else if recipient.Type == "keyprovider" {
+						if recipient.ProviderName == "" || recipient.ProviderEndpoint == "" {
+							return fmt.Errorf("Must specify a provider name and endpoint for keyprovider recipient %d of filesystem output at location %d in outputs array", j, i)
+						}
+					} else {
+						return fmt.Errorf("Unknown recipient type %s for recipient %d of filesystem output at location %d in outputs array", recipient.Type, j, i)
+					}
+				}
+			}
+		} else if configOutput.Type == "kafka" {
+			if len(configOutput.Kafka.Brokers) == 0 {
+				return fmt.Errorf("Must specify at least one broker for Kafka output at location %d in outputs array", i)
+			}
+			if configOutput.Kafka.Topic == "" && configOutput.Kafka.TopicTemplate == "" {
+				return fmt.Errorf("Must specify a topic or topic template for Kafka output at location %d in outputs array", i)
+			}
+			switch configOutput.Kafka.SASL.Mechanism {
+			case "", "plain", "scram-sha-256", "scram-sha-512":
+			default:
+				return fmt.Errorf("Unknown SASL mechanism %s for Kafka output at location %d in outputs array", configOutput.Kafka.SASL.Mechanism, i)
+			}
+		} else if configOutput.Type == "elasticsearch" {
+			if configOutput.Elasticsearch.URL == "" {
+				return fmt.Errorf("Must specify a URL for Elasticsearch output at location %d in outputs array", i)
+			}
+		} else if configOutput.Type == "s3" {
+			if configOutput.S3.Bucket == "" {
+				return fmt.Errorf("Must specify a bucket for S3 output at location %d in outputs array", i)
+			}
+			age := configOutput.Age
+			if age <= 0 {
+				return fmt.Errorf("Must specify a strictly positive age for S3 output at location %d in outputs array", i)
+			}
+			size := configOutput.Size
+			if size <= 0 {
+				return fmt.Errorf("Must specify a strictly positive size for S3 output at location %d in outputs array", i)
+			}
 		} else {
 			return fmt.Errorf("Unknown output type %s at at location %d in outputs array", configOutput.Type, i)
 		}
@@ -334,6 +1354,10 @@ func init() {
 
 	serverCmd.Flags().StringVar(&configFilePath, "config", "", `Path to the desired config file
 Permitted formats are YAML, JSON, TOML, HCL, envfile and Java properties config files`)
+	serverCmd.Flags().StringVar(&configSignaturePath, "config-signature", "", `Path to a detached JWS signature file for --config, e.g. config.yaml.sig
+If set, the config file is only accepted once this signature is verified`)
+	serverCmd.Flags().StringVar(&configVerifyKey, "config-verify-key", "", `Path to a PEM-encoded public key, or a JWKS URL, used to verify --config-signature
+Accepts RS256, ES256, and EdDSA keys`)
 
 	rootCmd.AddCommand(serverCmd)
 }
@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/spf13/cobra"
+)
+
+var signingKeyType string
+var signingKeyOutputPath string
+
+var signingKeyCmd = &cobra.Command{
+	Use:   "signing-key",
+	Short: "Generate a gateway signing keypair",
+	RunE:  signingKeyCmdRun,
+}
+
+func signingKeyCmdRun(cmd *cobra.Command, args []string) error {
+	privateKey, publicKey, err := generateSigningKeyPair(signingKeyType)
+	if err != nil {
+		return err
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal private key: %w", err)
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal public key: %w", err)
+	}
+
+	privatePath := signingKeyOutputPath + "-private.pem"
+	publicPath := signingKeyOutputPath + "-public.pem"
+
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	if err := ioutil.WriteFile(privatePath, privatePEM, 0600); err != nil {
+		return fmt.Errorf("Failed to write private key file: %w", err)
+	}
+	if err := ioutil.WriteFile(publicPath, publicPEM, 0644); err != nil {
+		return fmt.Errorf("Failed to write public key file: %w", err)
+	}
+
+	printedBytes, err := fmt.Printf("Wrote gateway signing key to %s and %s\n", privatePath, publicPath)
+	if err != nil {
+		return fmt.Errorf("Failed to print confirmation message: %w", err)
+	}
+
+	if printedBytes == 0 {
+		return fmt.Errorf("Failed to print confirmation message")
+	}
+
+	return nil
+}
+
+func generateSigningKeyPair(keyType string) (interface{}, interface{}, error) {
+	switch keyType {
+	case "ed25519":
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to generate Ed25519 key: %w", err)
+		}
+		return privateKey, publicKey, nil
+	case "ec":
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to generate ECDSA key: %w", err)
+		}
+		return privateKey, &privateKey.PublicKey, nil
+	case "rsa":
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to generate RSA key: %w", err)
+		}
+		return privateKey, &privateKey.PublicKey, nil
+	default:
+		return nil, nil, fmt.Errorf(`Unsupported signing key type "%s". Must be one of "ed25519", "ec", or "rsa"`, keyType)
+	}
+}
+
+func init() {
+	signingKeyCmd.Flags().StringVar(&signingKeyType, "type", "ed25519", `Key type to generate: "ed25519", "ec", or "rsa"`)
+	signingKeyCmd.Flags().StringVar(&signingKeyOutputPath, "output", "gateway-signing-key", "Path prefix to write the private and public key PEM files to")
+
+	rootCmd.AddCommand(signingKeyCmd)
+}
@@ -0,0 +1,294 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/dgrijalva/jwt-go"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// AsymmetricKeyConfig names a single PEM-encoded key on disk: PrivateKeyPath for the active
+// signing key, or PublicKeyPath for a past key kept only to verify tokens signed before a rotation.
+type AsymmetricKeyConfig struct {
+	PrivateKeyPath string
+	PublicKeyPath  string
+}
+
+// AsymmetricConfig configures a TokenSigner backed by local RSA, ECDSA, or Ed25519 key pairs
+// instead of raw HMAC secrets or a PKCS#11-backed HSM key.
+type AsymmetricConfig struct {
+	// Enabled selects an asymmetric-key TokenSigner instead of the raw HMAC secrets configured
+	// under sensor.token.secrets or a PKCS#11-backed signer.
+	Enabled bool
+	// Current is the active signing key. Its algorithm (RS256, ES256, or EdDSA) is inferred from
+	// the key's own type.
+	Current AsymmetricKeyConfig
+	// Past are zero or more previously active keys still accepted by Verify during rotation.
+	// Only PublicKeyPath is read for these; the private key is never needed again once a key is
+	// no longer active.
+	Past []AsymmetricKeyConfig
+}
+
+// asymmetricKeyMaterial is one key this signer knows about. private is nil for past,
+// verification-only keys.
+type asymmetricKeyMaterial struct {
+	public  crypto.PublicKey
+	private crypto.PrivateKey
+}
+
+// asymmetricSigner is a TokenSigner backed by local RSA, ECDSA, or Ed25519 key pairs, so tokens
+// can be verified offline by anyone holding the public key instead of requiring a shared secret.
+type asymmetricSigner struct {
+	scheme         jose.SignatureAlgorithm
+	activeKeyID    string
+	previousKeyIDs []string
+	keysByKeyID    map[string]asymmetricKeyMaterial
+}
+
+// NewAsymmetricSigner creates a TokenSigner from a currently active private key and zero or more
+// past public keys still accepted for verification during rotation. The signing algorithm
+// (RS256, ES256, or EdDSA) is inferred from the active key's type. Key IDs are derived from each
+// key's public key fingerprint, so a key keeps the same ID whether it's the active signing key or
+// a past, verification-only key.
+func NewAsymmetricSigner(config AsymmetricConfig) (TokenSigner, error) {
+	activePrivate, err := loadPrivateKeyPEM(config.Current.PrivateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load active asymmetric signing key: %w", err)
+	}
+
+	scheme, err := schemeForPrivateKey(activePrivate)
+	if err != nil {
+		return nil, err
+	}
+
+	activePublic, err := publicKeyForPrivateKey(activePrivate)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive public key for active asymmetric signing key: %w", err)
+	}
+
+	activeKeyID, err := asymmetricKeyID(activePublic)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive key ID for active asymmetric signing key: %w", err)
+	}
+
+	keysByKeyID := map[string]asymmetricKeyMaterial{
+		activeKeyID: {public: activePublic, private: activePrivate},
+	}
+	previousKeyIDs := make([]string, 0, len(config.Past))
+
+	for _, pastKey := range config.Past {
+		public, err := loadPublicKeyPEM(pastKey.PublicKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load past asymmetric verification key %s: %w", pastKey.PublicKeyPath, err)
+		}
+
+		keyID, err := asymmetricKeyID(public)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to derive key ID for past asymmetric verification key %s: %w", pastKey.PublicKeyPath, err)
+		}
+
+		keysByKeyID[keyID] = asymmetricKeyMaterial{public: public}
+		previousKeyIDs = append(previousKeyIDs, keyID)
+	}
+
+	return &asymmetricSigner{
+		scheme:         scheme,
+		activeKeyID:    activeKeyID,
+		previousKeyIDs: previousKeyIDs,
+		keysByKeyID:    keysByKeyID,
+	}, nil
+}
+
+// loadPrivateKeyPEM reads and parses a PEM-encoded private key, trying PKCS8 first and falling
+// back to PKCS1 and SEC1 (EC) for keys produced by older tooling.
+func loadPrivateKeyPEM(pemPath string) (crypto.PrivateKey, error) {
+	pemBytes, err := ioutil.ReadFile(pemPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read private key file: %w", err)
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("Failed to decode PEM block from private key file")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("Failed to parse private key: unsupported or invalid format")
+}
+
+// loadPublicKeyPEM reads and parses a PEM-encoded public key.
+func loadPublicKeyPEM(pemPath string) (crypto.PublicKey, error) {
+	pemBytes, err := ioutil.ReadFile(pemPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read public key file: %w", err)
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("Failed to decode PEM block from public key file")
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse public key: %w", err)
+	}
+
+	return publicKey, nil
+}
+
+func schemeForPrivateKey(key crypto.PrivateKey) (jose.SignatureAlgorithm, error) {
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		return jose.RS256, nil
+	case *ecdsa.PrivateKey:
+		return jose.ES256, nil
+	case ed25519.PrivateKey:
+		return jose.EdDSA, nil
+	default:
+		return "", fmt.Errorf("Unsupported asymmetric private key type %T", key)
+	}
+}
+
+func publicKeyForPrivateKey(key crypto.PrivateKey) (crypto.PublicKey, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return &key.PublicKey, nil
+	case *ecdsa.PrivateKey:
+		return &key.PublicKey, nil
+	case ed25519.PrivateKey:
+		return key.Public(), nil
+	default:
+		return nil, fmt.Errorf("Unsupported asymmetric private key type %T", key)
+	}
+}
+
+// asymmetricKeyID derives a stable key ID from a public key's content, so a key keeps the same ID
+// whether it's the active signing key or a past, verification-only key.
+func asymmetricKeyID(public crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", fmt.Errorf("Failed to marshal public key: %w", err)
+	}
+
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// jwtMethod returns the jwt-go SigningMethod that implements this signer's scheme, reusing
+// jwt-go's own RS256/ES256 implementations instead of hand rolling RSA/ECDSA signing. EdDSA isn't
+// implemented by this version of jwt-go, so Sign and Verify handle it directly via crypto/ed25519.
+func (signer *asymmetricSigner) jwtMethod() jwt.SigningMethod {
+	switch signer.scheme {
+	case jose.RS256:
+		return jwt.SigningMethodRS256
+	case jose.ES256:
+		return jwt.SigningMethodES256
+	default:
+		return nil
+	}
+}
+
+func (signer *asymmetricSigner) Sign(payload []byte) ([]byte, error) {
+	key := signer.keysByKeyID[signer.activeKeyID]
+
+	if signer.scheme == jose.EdDSA {
+		privateKey, ok := key.private.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("Active asymmetric key is not an Ed25519 private key")
+		}
+		return ed25519.Sign(privateKey, payload), nil
+	}
+
+	encodedSig, err := signer.jwtMethod().Sign(string(payload), key.private)
+	if err != nil {
+		return nil, err
+	}
+
+	return jwt.DecodeSegment(encodedSig)
+}
+
+func (signer *asymmetricSigner) Verify(payload []byte, sig []byte, keyID string) bool {
+	key, ok := signer.keysByKeyID[keyID]
+	if !ok {
+		return false
+	}
+
+	if signer.scheme == jose.EdDSA {
+		publicKey, ok := key.public.(ed25519.PublicKey)
+		if !ok {
+			return false
+		}
+		return ed25519.Verify(publicKey, payload, sig)
+	}
+
+	return signer.jwtMethod().Verify(string(payload), jwt.EncodeSegment(sig), key.public) == nil
+}
+
+func (signer *asymmetricSigner) ActiveKeyID() string {
+	return signer.activeKeyID
+}
+
+func (signer *asymmetricSigner) KeyIDs() []string {
+	keyIDs := make([]string, 0, 1+len(signer.previousKeyIDs))
+	keyIDs = append(keyIDs, signer.activeKeyID)
+	keyIDs = append(keyIDs, signer.previousKeyIDs...)
+	return keyIDs
+}
+
+func (signer *asymmetricSigner) Alg() string {
+	return string(signer.scheme)
+}
+
+// PublicJWKS implements PublicKeyProvider, publishing every key this signer knows about (active
+// and past) so downstream consumers can verify tokens against any of them during a rotation.
+func (signer *asymmetricSigner) PublicJWKS() jose.JSONWebKeySet {
+	keySet := jose.JSONWebKeySet{Keys: make([]jose.JSONWebKey, 0, len(signer.keysByKeyID))}
+
+	for keyID, material := range signer.keysByKeyID {
+		keySet.Keys = append(keySet.Keys, jose.JSONWebKey{
+			Key:       material.public,
+			KeyID:     keyID,
+			Algorithm: string(signer.scheme),
+			Use:       "sig",
+		})
+	}
+
+	return keySet
+}
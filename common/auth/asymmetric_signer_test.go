@@ -0,0 +1,213 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// writeRSAKeyPairPEM generates an RSA key pair and writes both halves as PEM files under dir,
+// returning their paths.
+func writeRSAKeyPairPEM(t *testing.T, dir string, name string) (privatePath string, publicPath string) {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA test key: %v", err)
+	}
+
+	return writeKeyPairPEM(t, dir, name, privateKey, &privateKey.PublicKey)
+}
+
+// writeECKeyPairPEM generates a P-256 ECDSA key pair and writes both halves as PEM files under
+// dir, returning their paths.
+func writeECKeyPairPEM(t *testing.T, dir string, name string) (privatePath string, publicPath string) {
+	t.Helper()
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate ECDSA test key: %v", err)
+	}
+
+	return writeKeyPairPEM(t, dir, name, privateKey, &privateKey.PublicKey)
+}
+
+// writeEd25519KeyPairPEM generates an Ed25519 key pair and writes both halves as PEM files under
+// dir, returning their paths.
+func writeEd25519KeyPairPEM(t *testing.T, dir string, name string) (privatePath string, publicPath string) {
+	t.Helper()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 test key: %v", err)
+	}
+
+	return writeKeyPairPEM(t, dir, name, privateKey, publicKey)
+}
+
+func writeKeyPairPEM(t *testing.T, dir string, name string, privateKey interface{}, publicKey interface{}) (privatePath string, publicPath string) {
+	t.Helper()
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal private key: %v", err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+
+	privatePath = filepath.Join(dir, name+"-private.pem")
+	publicPath = filepath.Join(dir, name+"-public.pem")
+
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	if err := ioutil.WriteFile(privatePath, privatePEM, 0600); err != nil {
+		t.Fatalf("Failed to write %s: %v", privatePath, err)
+	}
+	if err := ioutil.WriteFile(publicPath, publicPEM, 0600); err != nil {
+		t.Fatalf("Failed to write %s: %v", publicPath, err)
+	}
+
+	return privatePath, publicPath
+}
+
+func TestAsymmetricSignerRoundTripsByScheme(t *testing.T) {
+	dir := t.TempDir()
+
+	rsaPrivate, _ := writeRSAKeyPairPEM(t, dir, "rsa")
+	ecPrivate, _ := writeECKeyPairPEM(t, dir, "ec")
+	edPrivate, _ := writeEd25519KeyPairPEM(t, dir, "ed")
+
+	cases := map[string]string{
+		"RS256": rsaPrivate,
+		"ES256": ecPrivate,
+		"EdDSA": edPrivate,
+	}
+
+	for wantAlg, privateKeyPath := range cases {
+		signer, err := NewAsymmetricSigner(AsymmetricConfig{
+			Enabled: true,
+			Current: AsymmetricKeyConfig{PrivateKeyPath: privateKeyPath},
+		})
+		if err != nil {
+			t.Fatalf("NewAsymmetricSigner(%s) failed: %v", wantAlg, err)
+		}
+
+		if signer.Alg() != wantAlg {
+			t.Fatalf("Expected alg %s, got %s", wantAlg, signer.Alg())
+		}
+
+		payload := []byte("sign me")
+		sig, err := signer.Sign(payload)
+		if err != nil {
+			t.Fatalf("Sign failed for %s: %v", wantAlg, err)
+		}
+
+		if !signer.Verify(payload, sig, signer.ActiveKeyID()) {
+			t.Fatalf("Verify rejected a signature produced by Sign for %s", wantAlg)
+		}
+
+		if signer.Verify([]byte("tampered"), sig, signer.ActiveKeyID()) {
+			t.Fatalf("Verify accepted a signature over the wrong payload for %s", wantAlg)
+		}
+	}
+}
+
+func TestAsymmetricSignerVerifiesPastKeysDuringRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	retiredPrivate, retiredPublic := writeRSAKeyPairPEM(t, dir, "retired")
+	activePrivate, _ := writeRSAKeyPairPEM(t, dir, "active")
+
+	retiredSigner, err := NewAsymmetricSigner(AsymmetricConfig{
+		Enabled: true,
+		Current: AsymmetricKeyConfig{PrivateKeyPath: retiredPrivate},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create signer for the pre-rotation key: %v", err)
+	}
+
+	payload := []byte("signed before rotation")
+	sig, err := retiredSigner.Sign(payload)
+	if err != nil {
+		t.Fatalf("Failed to sign with the pre-rotation key: %v", err)
+	}
+	retiredKeyID := retiredSigner.ActiveKeyID()
+
+	rotatedSigner, err := NewAsymmetricSigner(AsymmetricConfig{
+		Enabled: true,
+		Current: AsymmetricKeyConfig{PrivateKeyPath: activePrivate},
+		Past:    []AsymmetricKeyConfig{{PublicKeyPath: retiredPublic}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create signer after rotation: %v", err)
+	}
+
+	if rotatedSigner.ActiveKeyID() == retiredKeyID {
+		t.Fatal("Expected the rotated signer's active key to differ from the retired key")
+	}
+
+	if !rotatedSigner.Verify(payload, sig, retiredKeyID) {
+		t.Fatal("Expected the rotated signer to still verify a token signed by the retired key")
+	}
+
+	keyIDs := rotatedSigner.KeyIDs()
+	if len(keyIDs) != 2 || keyIDs[0] != rotatedSigner.ActiveKeyID() || keyIDs[1] != retiredKeyID {
+		t.Fatalf("Unexpected KeyIDs() result: %v", keyIDs)
+	}
+}
+
+func TestAsymmetricSignerExposesJWKS(t *testing.T) {
+	dir := t.TempDir()
+	privateKeyPath, _ := writeRSAKeyPairPEM(t, dir, "rsa")
+
+	signer, err := NewAsymmetricSigner(AsymmetricConfig{
+		Enabled: true,
+		Current: AsymmetricKeyConfig{PrivateKeyPath: privateKeyPath},
+	})
+	if err != nil {
+		t.Fatalf("NewAsymmetricSigner failed: %v", err)
+	}
+
+	provider, ok := signer.(PublicKeyProvider)
+	if !ok {
+		t.Fatal("Expected asymmetricSigner to implement PublicKeyProvider")
+	}
+
+	keySet := provider.PublicJWKS()
+	if len(keySet.Keys) != 1 {
+		t.Fatalf("Expected 1 published key, got %d", len(keySet.Keys))
+	}
+
+	if keySet.Keys[0].KeyID != signer.ActiveKeyID() {
+		t.Fatalf("Expected published key ID %s, got %s", signer.ActiveKeyID(), keySet.Keys[0].KeyID)
+	}
+}
@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Authenticator verifies sensor-provided credentials and, on success, resolves them to the
+// group the sensor is allowed to install into and the sensor identity itself. Credentials are
+// specific to each implementation: KeyAuthenticator expects a Key, OIDCAuthenticator expects a
+// raw ID token string.
+type Authenticator interface {
+	Authenticate(ctx context.Context, credentials interface{}) (group uuid.UUID, sensor uuid.UUID, err error)
+}
+
+// KeyAuthenticator adapts the existing PBKDF2 Key/Verifier scheme to the Authenticator
+// interface. Credentials must be a Key. The returned sensor identifier is always the nil UUID
+// since install keys don't name a sensor, only a group; callers generate one for new installs.
+type KeyAuthenticator struct {
+	Verifiers VerifierStore
+}
+
+// Authenticate checks credentials, which must be a Key, against the Verifiers configured for the
+// Key's group
+func (keyAuthenticator KeyAuthenticator) Authenticate(ctx context.Context, credentials interface{}) (uuid.UUID, uuid.UUID, error) {
+	key, ok := credentials.(Key)
+	if !ok {
+		return uuid.UUID{}, uuid.UUID{}, fmt.Errorf("KeyAuthenticator credentials must be a Key")
+	}
+
+	verifiers, err := keyAuthenticator.Verifiers.Verifiers(ctx, key.Group)
+	if err != nil {
+		return uuid.UUID{}, uuid.UUID{}, fmt.Errorf("Failed to look up verifiers for group: %w", err)
+	}
+
+	for _, verifier := range verifiers {
+		if Verify(key, verifier) {
+			return key.Group, uuid.UUID{}, nil
+		}
+	}
+
+	return uuid.UUID{}, uuid.UUID{}, fmt.Errorf("Key did not verify against any configured verifier")
+}
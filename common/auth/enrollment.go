@@ -0,0 +1,301 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// userCodeAlphabet excludes characters that are easy to confuse when read aloud or copied by
+// hand: 0/O and 1/I.
+const userCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// userCodeLength is the number of characters in a generated user code
+const userCodeLength = 8
+
+// deviceCodeByteLength is the number of random bytes a device code is derived from
+const deviceCodeByteLength = 32
+
+// enrollmentRateLimit and enrollmentRateLimitWindow bound how many device-code enrollments a
+// single client IP may start within the window, so a misbehaving or malicious client can't
+// exhaust the user code space or spam operators with approval requests.
+const enrollmentRateLimit = 5
+const enrollmentRateLimitWindow = time.Hour
+
+// EnrollmentStatus reports where a device-code enrollment is in its lifecycle
+type EnrollmentStatus int
+
+const (
+	// EnrollmentPending means the user code has not yet been approved by an operator
+	EnrollmentPending EnrollmentStatus = iota
+	// EnrollmentApproved means an operator approved the user code and a Key was issued
+	EnrollmentApproved
+	// EnrollmentExpired means the device code's TTL elapsed before it was approved and polled
+	EnrollmentExpired
+)
+
+type enrollment struct {
+	deviceCode string
+	userCode   string
+	expiresAt  time.Time
+	approved   bool
+	key        Key
+}
+
+// EnrollmentStore tracks in-flight device-code sensor enrollments, modeled on the OAuth 2.0
+// device authorization grant (RFC 8628): a sensor requests a device code/user code pair, an
+// operator approves the user code against a group, and the sensor polls the device code until
+// a freshly generated Key is issued. Entries are single-use and removed once the sensor
+// retrieves its Key or the TTL elapses, whichever happens first.
+type EnrollmentStore struct {
+	ttl time.Duration
+
+	mutex    sync.Mutex
+	byDevice map[string]*enrollment
+	byUser   map[string]*enrollment
+
+	rateLimitMutex sync.Mutex
+	attempts       map[string][]time.Time
+
+	// stopSweep signals reapExpiredEnrollments to stop. Closed by Close.
+	stopSweep chan struct{}
+}
+
+// enrollmentSweepInterval is how often EnrollmentStore scans byDevice for expired entries to
+// evict. Approve and Poll only ever remove an entry lazily, when that entry's own code is looked
+// up again; an enrollment that is never approved and never polled again (the sensor crashed, or
+// an operator never got to it) would otherwise sit in both maps forever.
+const enrollmentSweepInterval = 1 * time.Minute
+
+// NewEnrollmentStore creates an empty EnrollmentStore whose codes expire ttl after issuance. A
+// background goroutine periodically evicts expired, never-approved-or-polled entries; see
+// reapExpiredEnrollments.
+func NewEnrollmentStore(ttl time.Duration) *EnrollmentStore {
+	store := &EnrollmentStore{
+		ttl:       ttl,
+		byDevice:  make(map[string]*enrollment),
+		byUser:    make(map[string]*enrollment),
+		attempts:  make(map[string][]time.Time),
+		stopSweep: make(chan struct{}),
+	}
+
+	go store.reapExpiredEnrollments()
+
+	return store
+}
+
+// Close stops the background sweep started by NewEnrollmentStore. It is safe, but not required,
+// to never call Close: the store is otherwise harmless to leave running for the lifetime of the
+// process.
+func (store *EnrollmentStore) Close() {
+	close(store.stopSweep)
+}
+
+// reapExpiredEnrollments periodically evicts enrollments past their expiresAt that were never
+// approved-and-polled to completion. Runs until stopSweep is closed.
+func (store *EnrollmentStore) reapExpiredEnrollments() {
+	ticker := time.NewTicker(enrollmentSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			store.reapExpiredEnrollmentsNow(time.Now())
+		case <-store.stopSweep:
+			return
+		}
+	}
+}
+
+// reapExpiredEnrollmentsNow removes every enrollment whose expiresAt is before now. Split out
+// from reapExpiredEnrollments so tests can trigger a sweep without waiting on
+// enrollmentSweepInterval.
+func (store *EnrollmentStore) reapExpiredEnrollmentsNow(now time.Time) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, entry := range store.byDevice {
+		if now.After(entry.expiresAt) {
+			store.remove(entry)
+		}
+	}
+}
+
+// RequestDevice begins a new enrollment on behalf of a sensor at clientIP, returning a device
+// code for the sensor to poll with and a short user code for an operator to approve. It returns
+// an error if clientIP has exceeded the enrollment rate limit.
+func (store *EnrollmentStore) RequestDevice(clientIP string) (deviceCode string, userCode string, expiresAt time.Time, err error) {
+	if !store.allow(clientIP) {
+		return "", "", time.Time{}, fmt.Errorf("Enrollment request rate limit exceeded for this client")
+	}
+
+	deviceCode, err = newDeviceCode()
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for {
+		userCode, err = newUserCode()
+		if err != nil {
+			return "", "", time.Time{}, err
+		}
+		if _, exists := store.byUser[userCode]; !exists {
+			break
+		}
+	}
+
+	expiresAt = time.Now().Add(store.ttl)
+	entry := &enrollment{
+		deviceCode: deviceCode,
+		userCode:   userCode,
+		expiresAt:  expiresAt,
+	}
+
+	store.byDevice[deviceCode] = entry
+	store.byUser[userCode] = entry
+
+	return deviceCode, userCode, expiresAt, nil
+}
+
+// Approve marks the enrollment matching userCode as approved for group, generating a fresh
+// install Key for the sensor and the corresponding Verifier for the caller to register. userCode
+// is single-use: approving an unknown, expired, or already-approved code returns an error.
+func (store *EnrollmentStore) Approve(userCode string, group uuid.UUID) (Key, Verifier, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	entry, ok := store.byUser[userCode]
+	if !ok {
+		return Key{}, Verifier{}, fmt.Errorf("Unknown or expired enrollment user code")
+	}
+
+	if time.Now().After(entry.expiresAt) {
+		store.remove(entry)
+		return Key{}, Verifier{}, fmt.Errorf("Enrollment user code has expired")
+	}
+
+	if entry.approved {
+		return Key{}, Verifier{}, fmt.Errorf("Enrollment user code was already approved")
+	}
+
+	key, err := NewKey(group)
+	if err != nil {
+		return Key{}, Verifier{}, fmt.Errorf("Failed to generate enrollment install key: %w", err)
+	}
+
+	verifier, err := NewVerifier(key)
+	if err != nil {
+		return Key{}, Verifier{}, fmt.Errorf("Failed to generate enrollment install verifier: %w", err)
+	}
+
+	entry.approved = true
+	entry.key = key
+
+	return key, verifier, nil
+}
+
+// Poll reports the status of a device-code enrollment. Once approved, the issued Key is
+// returned and the entry is removed, so a Key is only ever handed out once.
+func (store *EnrollmentStore) Poll(deviceCode string) (Key, EnrollmentStatus, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	entry, ok := store.byDevice[deviceCode]
+	if !ok {
+		return Key{}, EnrollmentExpired, fmt.Errorf("Unknown or expired device code")
+	}
+
+	if time.Now().After(entry.expiresAt) {
+		store.remove(entry)
+		return Key{}, EnrollmentExpired, nil
+	}
+
+	if !entry.approved {
+		return Key{}, EnrollmentPending, nil
+	}
+
+	key := entry.key
+	store.remove(entry)
+
+	return key, EnrollmentApproved, nil
+}
+
+// remove deletes an enrollment from both indices. Callers must hold store.mutex.
+func (store *EnrollmentStore) remove(entry *enrollment) {
+	delete(store.byDevice, entry.deviceCode)
+	delete(store.byUser, entry.userCode)
+}
+
+// allow applies the per-IP sliding window rate limit, recording this attempt if it is allowed
+func (store *EnrollmentStore) allow(clientIP string) bool {
+	store.rateLimitMutex.Lock()
+	defer store.rateLimitMutex.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-enrollmentRateLimitWindow)
+
+	recent := store.attempts[clientIP][:0]
+	for _, attempt := range store.attempts[clientIP] {
+		if attempt.After(cutoff) {
+			recent = append(recent, attempt)
+		}
+	}
+
+	if len(recent) >= enrollmentRateLimit {
+		store.attempts[clientIP] = recent
+		return false
+	}
+
+	store.attempts[clientIP] = append(recent, now)
+	return true
+}
+
+func newDeviceCode() (string, error) {
+	raw, err := NewRandomBytes(deviceCodeByteLength)
+	if err != nil {
+		return "", fmt.Errorf("Device code generation failed: %w", err)
+	}
+
+	return hex.EncodeToString(raw), nil
+}
+
+func newUserCode() (string, error) {
+	indices := make([]byte, userCodeLength)
+	_, err := rand.Read(indices)
+	if err != nil {
+		return "", fmt.Errorf("User code generation failed: %w", err)
+	}
+
+	code := make([]byte, userCodeLength)
+	for i, index := range indices {
+		code[i] = userCodeAlphabet[int(index)%len(userCodeAlphabet)]
+	}
+
+	return string(code), nil
+}
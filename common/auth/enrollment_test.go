@@ -0,0 +1,189 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestEnrollmentStoreApproveAndPoll(t *testing.T) {
+	store := NewEnrollmentStore(time.Minute)
+	defer store.Close()
+
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate test group UUID: %v", err)
+	}
+
+	deviceCode, userCode, _, err := store.RequestDevice("127.0.0.1")
+	if err != nil {
+		t.Fatalf("RequestDevice failed: %v", err)
+	}
+
+	if _, status, err := store.Poll(deviceCode); err != nil || status != EnrollmentPending {
+		t.Fatalf("Expected pending status before approval, got status %v err %v", status, err)
+	}
+
+	key, _, err := store.Approve(userCode, group)
+	if err != nil {
+		t.Fatalf("Approve failed: %v", err)
+	}
+
+	if !UUIDEquals(key.Group, group) {
+		t.Fatalf("Issued key's group %s did not match approved group %s", key.Group, group)
+	}
+
+	polledKey, status, err := store.Poll(deviceCode)
+	if err != nil {
+		t.Fatalf("Poll after approval failed: %v", err)
+	}
+	if status != EnrollmentApproved {
+		t.Fatalf("Expected approved status after approval, got %v", status)
+	}
+	if !UUIDEquals(polledKey.Group, group) {
+		t.Fatalf("Polled key's group %s did not match approved group %s", polledKey.Group, group)
+	}
+
+	if _, status, err := store.Poll(deviceCode); err == nil {
+		t.Fatalf("Expected polling an already-claimed device code to fail, got status %v", status)
+	}
+}
+
+func TestEnrollmentStoreApproveTwiceFails(t *testing.T) {
+	store := NewEnrollmentStore(time.Minute)
+	defer store.Close()
+
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate test group UUID: %v", err)
+	}
+
+	_, userCode, _, err := store.RequestDevice("127.0.0.1")
+	if err != nil {
+		t.Fatalf("RequestDevice failed: %v", err)
+	}
+
+	if _, _, err := store.Approve(userCode, group); err != nil {
+		t.Fatalf("First approval failed: %v", err)
+	}
+
+	if _, _, err := store.Approve(userCode, group); err == nil {
+		t.Fatalf("Expected second approval of the same user code to fail")
+	}
+}
+
+func TestEnrollmentStoreExpiry(t *testing.T) {
+	store := NewEnrollmentStore(-time.Minute)
+	defer store.Close()
+
+	deviceCode, userCode, _, err := store.RequestDevice("127.0.0.1")
+	if err != nil {
+		t.Fatalf("RequestDevice failed: %v", err)
+	}
+
+	if _, _, _, err := store.RequestDevice("127.0.0.1"); err != nil {
+		t.Fatalf("Unexpected rate limit error: %v", err)
+	}
+
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate test group UUID: %v", err)
+	}
+
+	if _, _, err := store.Approve(userCode, group); err == nil {
+		t.Fatalf("Expected approving an expired user code to fail")
+	}
+
+	if _, status, _ := store.Poll(deviceCode); status != EnrollmentExpired {
+		t.Fatalf("Expected expired status for an expired device code, got status %v", status)
+	}
+}
+
+func TestEnrollmentStoreRateLimit(t *testing.T) {
+	store := NewEnrollmentStore(time.Minute)
+	defer store.Close()
+
+	for i := 0; i < enrollmentRateLimit; i++ {
+		if _, _, _, err := store.RequestDevice("10.0.0.1"); err != nil {
+			t.Fatalf("Unexpected error on enrollment request %d: %v", i, err)
+		}
+	}
+
+	if _, _, _, err := store.RequestDevice("10.0.0.1"); err == nil {
+		t.Fatalf("Expected enrollment request to be rate limited after %d requests", enrollmentRateLimit)
+	}
+
+	if _, _, _, err := store.RequestDevice("10.0.0.2"); err != nil {
+		t.Fatalf("Expected a different client IP to be unaffected by another client's rate limit: %v", err)
+	}
+}
+
+// TestEnrollmentStoreReapsExpiredEnrollments confirms an enrollment that is never approved or
+// polled again is still evicted from both indices, rather than sitting there indefinitely.
+func TestEnrollmentStoreReapsExpiredEnrollments(t *testing.T) {
+	store := NewEnrollmentStore(time.Nanosecond)
+	defer store.Close()
+
+	deviceCode, userCode, _, err := store.RequestDevice("127.0.0.1")
+	if err != nil {
+		t.Fatalf("RequestDevice failed: %v", err)
+	}
+
+	store.mutex.Lock()
+	_, hasDevice := store.byDevice[deviceCode]
+	_, hasUser := store.byUser[userCode]
+	store.mutex.Unlock()
+	if !hasDevice || !hasUser {
+		t.Fatalf("Expected the new enrollment to be present in both indices")
+	}
+
+	time.Sleep(time.Millisecond)
+	store.reapExpiredEnrollmentsNow(time.Now())
+
+	store.mutex.Lock()
+	_, hasDevice = store.byDevice[deviceCode]
+	_, hasUser = store.byUser[userCode]
+	store.mutex.Unlock()
+	if hasDevice || hasUser {
+		t.Fatalf("Expected the expired enrollment to be reaped from both indices")
+	}
+}
+
+func TestNewUserCodeUsesUnambiguousAlphabet(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		userCode, err := newUserCode()
+		if err != nil {
+			t.Fatalf("newUserCode failed: %v", err)
+		}
+
+		if len(userCode) != userCodeLength {
+			t.Fatalf("Expected user code of length %d, got %q", userCodeLength, userCode)
+		}
+
+		for _, character := range userCode {
+			if character == '0' || character == 'O' || character == '1' || character == 'I' {
+				t.Fatalf("User code %q contains an ambiguous character %q", userCode, character)
+			}
+		}
+	}
+}
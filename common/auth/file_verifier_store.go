@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// FileVerifierStoreConfig configures a FileVerifierStore
+type FileVerifierStoreConfig struct {
+	// Path is a JSON file holding an array of Verifiers, the same shape as
+	// sensor.install.verifiers in the gateway config.
+	Path string
+}
+
+// FileVerifierStore loads install Verifiers from a JSON file on disk. Unlike VerifierRegistry,
+// its contents can be refreshed at runtime by calling Reload, e.g. on SIGHUP, so an operator or
+// an external process managing the file doesn't need to restart the gateway to roll out changes.
+type FileVerifierStore struct {
+	path string
+
+	mutex     sync.RWMutex
+	verifiers map[uuid.UUID][]Verifier
+}
+
+// NewFileVerifierStore creates a FileVerifierStore backed by the JSON file at path, loading it
+// immediately.
+func NewFileVerifierStore(path string) (*FileVerifierStore, error) {
+	store := &FileVerifierStore{path: path}
+
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Reload re-reads the store's backing file, replacing its in-memory Verifiers. It is safe to
+// call while Verifiers is being called concurrently from request handling goroutines.
+func (store *FileVerifierStore) Reload() error {
+	fileBytes, err := ioutil.ReadFile(store.path)
+	if err != nil {
+		return fmt.Errorf("Failed to read verifier store file %s: %w", store.path, err)
+	}
+
+	var verifierList []Verifier
+	if err := json.Unmarshal(fileBytes, &verifierList); err != nil {
+		return fmt.Errorf("Failed to parse verifier store file %s: %w", store.path, err)
+	}
+
+	verifiers := make(map[uuid.UUID][]Verifier)
+	for _, verifier := range verifierList {
+		verifiers[verifier.Group] = append(verifiers[verifier.Group], verifier)
+	}
+
+	store.mutex.Lock()
+	store.verifiers = verifiers
+	store.mutex.Unlock()
+
+	return nil
+}
+
+// Verifiers implements VerifierStore, returning a snapshot of the Verifiers currently loaded for
+// group
+func (store *FileVerifierStore) Verifiers(ctx context.Context, group uuid.UUID) ([]Verifier, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	snapshot := make([]Verifier, len(store.verifiers[group]))
+	copy(snapshot, store.verifiers[group])
+
+	return snapshot, nil
+}
@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// defaultHTTPVerifierStoreTimeout bounds a lookup round trip when Timeout isn't configured
+const defaultHTTPVerifierStoreTimeout = 5 * time.Second
+
+// HTTPVerifierStoreConfig configures an HTTPVerifierStore
+type HTTPVerifierStoreConfig struct {
+	// URL is the base URL of the lookup service. The requested group's UUID is appended as the
+	// final path segment, e.g. "https://verifiers.internal/groups" becomes
+	// "https://verifiers.internal/groups/<group>".
+	URL string
+	// Timeout bounds how long a single lookup round trip is allowed to take, in microseconds.
+	// Defaults to defaultHTTPVerifierStoreTimeout if zero.
+	Timeout int64
+}
+
+// HTTPVerifierStore resolves install Verifiers from a remote lookup service instead of holding
+// every group's Verifiers in memory, for large multi-tenant deployments where enumerating every
+// group at gateway startup is infeasible. Verifiers makes an HTTP request on every call, so
+// callers should wrap it in a CachingVerifierStore; NewInstallAPI does this automatically.
+type HTTPVerifierStore struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPVerifierStore creates an HTTPVerifierStore from config
+func NewHTTPVerifierStore(config HTTPVerifierStoreConfig) *HTTPVerifierStore {
+	timeout := time.Duration(config.Timeout) * time.Microsecond
+	if timeout <= 0 {
+		timeout = defaultHTTPVerifierStoreTimeout
+	}
+
+	return &HTTPVerifierStore{
+		url:    config.URL,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Verifiers implements VerifierStore, looking group up against the configured lookup service. A
+// 404 response is treated as "no verifiers configured for this group" rather than an error.
+func (store *HTTPVerifierStore) Verifiers(ctx context.Context, group uuid.UUID) ([]Verifier, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/%s", store.url, group.String()), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to build verifier lookup request: %w", err)
+	}
+
+	response, err := store.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to contact verifier lookup service: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Verifier lookup service returned unexpected status %d", response.StatusCode)
+	}
+
+	var verifiers []Verifier
+	if err := json.NewDecoder(response.Body).Decode(&verifiers); err != nil {
+		return nil, fmt.Errorf("Failed to parse verifier lookup response: %w", err)
+	}
+
+	return verifiers, nil
+}
@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// SigningKeyRegistry holds the per-sensor public keys used to verify signed event envelopes.
+// Keys are registered at enrollment time (e.g. when a sensor installs) and looked up by sensor id.
+type SigningKeyRegistry struct {
+	mutex sync.RWMutex
+	keys  map[uuid.UUID]ed25519.PublicKey
+}
+
+// NewSigningKeyRegistry creates an empty SigningKeyRegistry
+func NewSigningKeyRegistry() *SigningKeyRegistry {
+	return &SigningKeyRegistry{
+		keys: make(map[uuid.UUID]ed25519.PublicKey),
+	}
+}
+
+// RegisterKey associates a sensor with the public key it will use to sign event envelopes.
+// Registering a key for a sensor that already has one overwrites the previous key.
+func (registry *SigningKeyRegistry) RegisterKey(sensor uuid.UUID, publicKey ed25519.PublicKey) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	registry.keys[sensor] = publicKey
+}
+
+// Lookup returns the public key registered for the given sensor, if any
+func (registry *SigningKeyRegistry) Lookup(sensor uuid.UUID) (ed25519.PublicKey, bool) {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	key, ok := registry.keys[sensor]
+	return key, ok
+}
+
+// Verify reports whether signature is a valid ed25519 signature over message for the given sensor.
+// It returns false if the sensor has no registered key.
+func (registry *SigningKeyRegistry) Verify(sensor uuid.UUID, message []byte, signature []byte) bool {
+	publicKey, ok := registry.Lookup(sensor)
+	if !ok {
+		return false
+	}
+
+	return ed25519.Verify(publicKey, message, signature)
+}
+
+// VerifierRegistry holds the install Verifiers that KeyAuthenticator checks credentials
+// against, keyed by group so a lookup never has to scan verifiers belonging to other groups.
+// Unlike a plain slice, it can grow at runtime as new sensors enroll, e.g. via the device-code
+// enrollment flow, in addition to the Verifiers configured at startup. It implements VerifierStore.
+type VerifierRegistry struct {
+	mutex     sync.RWMutex
+	verifiers map[uuid.UUID][]Verifier
+}
+
+// NewVerifierRegistry creates a VerifierRegistry seeded with the given Verifiers
+func NewVerifierRegistry(verifiers []Verifier) *VerifierRegistry {
+	registry := &VerifierRegistry{verifiers: make(map[uuid.UUID][]Verifier)}
+
+	for _, verifier := range verifiers {
+		registry.verifiers[verifier.Group] = append(registry.verifiers[verifier.Group], verifier)
+	}
+
+	return registry
+}
+
+// Register adds a Verifier, e.g. once an operator approves a device-code enrollment
+func (registry *VerifierRegistry) Register(verifier Verifier) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	registry.verifiers[verifier.Group] = append(registry.verifiers[verifier.Group], verifier)
+}
+
+// Verifiers implements VerifierStore, returning a snapshot of the Verifiers registered for group
+func (registry *VerifierRegistry) Verifiers(ctx context.Context, group uuid.UUID) ([]Verifier, error) {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	snapshot := make([]Verifier, len(registry.verifiers[group]))
+	copy(snapshot, registry.verifiers[group])
+
+	return snapshot, nil
+}
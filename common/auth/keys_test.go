@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSigningKeyRegistryVerify(t *testing.T) {
+	sensor, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate test sensor UUID: %v", err)
+	}
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate test key pair: %v", err)
+	}
+
+	registry := NewSigningKeyRegistry()
+	registry.RegisterKey(sensor, publicKey)
+
+	message := []byte("test message")
+	signature := ed25519.Sign(privateKey, message)
+
+	if !registry.Verify(sensor, message, signature) {
+		t.Fatal("Expected signature to verify against registered key")
+	}
+
+	if registry.Verify(sensor, []byte("different message"), signature) {
+		t.Fatal("Signature should not verify against a different message")
+	}
+}
+
+func TestSigningKeyRegistryUnregisteredSensor(t *testing.T) {
+	sensor, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate test sensor UUID: %v", err)
+	}
+
+	registry := NewSigningKeyRegistry()
+
+	if registry.Verify(sensor, []byte("message"), []byte("signature")) {
+		t.Fatal("Expected no verification to succeed for a sensor with no registered key")
+	}
+}
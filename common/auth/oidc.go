@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	oidc "gopkg.in/coreos/go-oidc.v2"
+)
+
+// OIDCIssuer configures a trusted OIDC identity provider that sensors may authenticate against,
+// with GroupClaim naming the ID token claim that holds the sensor group UUID. RequiredClaims
+// lets an issuer be scoped further, e.g. restricting it to a single tenant or workload identity
+// subject prefix.
+type OIDCIssuer struct {
+	IssuerURL      string              `json:"issuerURL"`
+	Audience       string              `json:"audience"`
+	GroupClaim     string              `json:"groupClaim"`
+	RequiredClaims []OIDCRequiredClaim `json:"requiredClaims"`
+}
+
+// OIDCRequiredClaim constrains an ID token claim to either an exact value or a prefix, so an
+// issuer can be trusted only for a subset of the identities it can mint tokens for. Exactly one
+// of Equals or Prefix should be set.
+type OIDCRequiredClaim struct {
+	Claim  string `json:"claim"`
+	Equals string `json:"equals,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// OIDCAuthenticator is an Authenticator that accepts OIDC ID tokens issued by a single trusted
+// issuer in place of the PBKDF2 Key/Verifier scheme. Credentials must be the raw ID token
+// string. The returned sensor identifier is always the nil UUID since an ID token names an
+// identity/group, not a specific sensor; callers generate one for new installs.
+type OIDCAuthenticator struct {
+	groupClaim     string
+	requiredClaims []OIDCRequiredClaim
+	verifier       *oidc.IDTokenVerifier
+}
+
+// NewOIDCAuthenticator contacts the issuer's discovery document and builds an Authenticator
+// that verifies ID tokens against it for the configured audience
+func NewOIDCAuthenticator(ctx context.Context, issuer OIDCIssuer) (OIDCAuthenticator, error) {
+	var oidcAuthenticator OIDCAuthenticator
+
+	provider, err := oidc.NewProvider(ctx, issuer.IssuerURL)
+	if err != nil {
+		return oidcAuthenticator, fmt.Errorf("Failed to contact OIDC issuer %s: %w", issuer.IssuerURL, err)
+	}
+
+	oidcAuthenticator = OIDCAuthenticator{
+		groupClaim:     issuer.GroupClaim,
+		requiredClaims: issuer.RequiredClaims,
+		verifier:       provider.Verifier(&oidc.Config{ClientID: issuer.Audience}),
+	}
+
+	return oidcAuthenticator, nil
+}
+
+// Authenticate verifies credentials, which must be a raw ID token string, and resolves the
+// configured group claim to a sensor group UUID
+func (oidcAuthenticator OIDCAuthenticator) Authenticate(ctx context.Context, credentials interface{}) (uuid.UUID, uuid.UUID, error) {
+	rawIDToken, ok := credentials.(string)
+	if !ok {
+		return uuid.UUID{}, uuid.UUID{}, fmt.Errorf("OIDCAuthenticator credentials must be a raw ID token string")
+	}
+
+	idToken, err := oidcAuthenticator.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return uuid.UUID{}, uuid.UUID{}, fmt.Errorf("Failed to verify OIDC ID token: %w", err)
+	}
+
+	var claims map[string]interface{}
+	err = idToken.Claims(&claims)
+	if err != nil {
+		return uuid.UUID{}, uuid.UUID{}, fmt.Errorf("Failed to parse OIDC ID token claims: %w", err)
+	}
+
+	err = checkRequiredClaims(claims, oidcAuthenticator.requiredClaims)
+	if err != nil {
+		return uuid.UUID{}, uuid.UUID{}, err
+	}
+
+	groupClaimValue, ok := claims[oidcAuthenticator.groupClaim].(string)
+	if !ok {
+		return uuid.UUID{}, uuid.UUID{}, fmt.Errorf("OIDC ID token is missing the configured group claim '%s'", oidcAuthenticator.groupClaim)
+	}
+
+	group, err := uuid.Parse(groupClaimValue)
+	if err != nil {
+		return uuid.UUID{}, uuid.UUID{}, fmt.Errorf("OIDC ID token group claim '%s' is not a valid UUID: %w", oidcAuthenticator.groupClaim, err)
+	}
+
+	return group, uuid.UUID{}, nil
+}
+
+// checkRequiredClaims verifies that claims satisfies every constraint in requiredClaims, either
+// an exact match or a prefix match depending on which is set.
+func checkRequiredClaims(claims map[string]interface{}, requiredClaims []OIDCRequiredClaim) error {
+	for _, requiredClaim := range requiredClaims {
+		claimValue, ok := claims[requiredClaim.Claim].(string)
+		if !ok {
+			return fmt.Errorf("OIDC ID token is missing the required claim '%s'", requiredClaim.Claim)
+		}
+
+		if requiredClaim.Equals != "" && claimValue != requiredClaim.Equals {
+			return fmt.Errorf("OIDC ID token claim '%s' did not equal the required value", requiredClaim.Claim)
+		}
+
+		if requiredClaim.Prefix != "" && !strings.HasPrefix(claimValue, requiredClaim.Prefix) {
+			return fmt.Errorf("OIDC ID token claim '%s' did not have the required prefix", requiredClaim.Claim)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,226 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// oidcTestServer serves a discovery document and JWKS backed by a freshly generated RSA key,
+// and mints ID tokens signed with that key.
+type oidcTestServer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+}
+
+func newOIDCTestServer(t *testing.T) *oidcTestServer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+
+	testServer := &oidcTestServer{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":   testServer.server.URL,
+			"jwks_uri": testServer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		jwk := jose.JSONWebKey{Key: &testServer.key.PublicKey, Algorithm: "RS256", Use: "sig", KeyID: "test-key"}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{jwk}})
+	})
+
+	testServer.server = httptest.NewServer(mux)
+
+	return testServer
+}
+
+func (testServer *oidcTestServer) issueToken(t *testing.T, audience string, claims map[string]interface{}) string {
+	t.Helper()
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: testServer.key}, &jose.SignerOptions{
+		ExtraHeaders: map[jose.HeaderKey]interface{}{"kid": "test-key"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to build JWT signer: %v", err)
+	}
+
+	allClaims := map[string]interface{}{
+		"iss": testServer.server.URL,
+		"aud": audience,
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"iat": time.Now().Unix(),
+	}
+	for claim, value := range claims {
+		allClaims[claim] = value
+	}
+
+	token, err := jwt.Signed(signer).Claims(allClaims).CompactSerialize()
+	if err != nil {
+		t.Fatalf("Failed to sign test ID token: %v", err)
+	}
+
+	return token
+}
+
+func TestOIDCAuthenticatorAcceptsValidToken(t *testing.T) {
+	testServer := newOIDCTestServer(t)
+	defer testServer.server.Close()
+
+	groupID := uuid.New()
+
+	authenticator, err := NewOIDCAuthenticator(context.Background(), OIDCIssuer{
+		IssuerURL:  testServer.server.URL,
+		Audience:   "weaklayer-sensor",
+		GroupClaim: "weaklayer_group",
+	})
+	if err != nil {
+		t.Fatalf("Failed to build OIDC authenticator: %v", err)
+	}
+
+	token := testServer.issueToken(t, "weaklayer-sensor", map[string]interface{}{"weaklayer_group": groupID.String()})
+
+	group, sensor, err := authenticator.Authenticate(context.Background(), token)
+	if err != nil {
+		t.Fatalf("Authenticate failed for a valid token: %v", err)
+	}
+
+	if !UUIDEquals(group, groupID) {
+		t.Fatalf("Authenticated group %s did not match expected group %s", group, groupID)
+	}
+
+	if !UUIDEquals(sensor, uuid.UUID{}) {
+		t.Fatalf("Expected nil sensor UUID for an OIDC-authenticated install, got %s", sensor)
+	}
+}
+
+func TestOIDCAuthenticatorRejectsMissingGroupClaim(t *testing.T) {
+	testServer := newOIDCTestServer(t)
+	defer testServer.server.Close()
+
+	authenticator, err := NewOIDCAuthenticator(context.Background(), OIDCIssuer{
+		IssuerURL:  testServer.server.URL,
+		Audience:   "weaklayer-sensor",
+		GroupClaim: "weaklayer_group",
+	})
+	if err != nil {
+		t.Fatalf("Failed to build OIDC authenticator: %v", err)
+	}
+
+	token := testServer.issueToken(t, "weaklayer-sensor", nil)
+
+	if _, _, err := authenticator.Authenticate(context.Background(), token); err == nil {
+		t.Fatal("Expected authentication to fail for a token missing the group claim")
+	}
+}
+
+func TestOIDCAuthenticatorRequiredClaims(t *testing.T) {
+	testServer := newOIDCTestServer(t)
+	defer testServer.server.Close()
+
+	groupID := uuid.New()
+
+	authenticator, err := NewOIDCAuthenticator(context.Background(), OIDCIssuer{
+		IssuerURL:  testServer.server.URL,
+		Audience:   "weaklayer-sensor",
+		GroupClaim: "weaklayer_group",
+		RequiredClaims: []OIDCRequiredClaim{
+			{Claim: "tenant", Equals: "acme"},
+			{Claim: "sub", Prefix: "repo:my-org/"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to build OIDC authenticator: %v", err)
+	}
+
+	cases := []struct {
+		name    string
+		claims  map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name: "satisfies all required claims",
+			claims: map[string]interface{}{
+				"weaklayer_group": groupID.String(),
+				"tenant":          "acme",
+				"sub":             "repo:my-org/widgets",
+			},
+			wantErr: false,
+		},
+		{
+			name: "wrong tenant",
+			claims: map[string]interface{}{
+				"weaklayer_group": groupID.String(),
+				"tenant":          "other",
+				"sub":             "repo:my-org/widgets",
+			},
+			wantErr: true,
+		},
+		{
+			name: "subject missing required prefix",
+			claims: map[string]interface{}{
+				"weaklayer_group": groupID.String(),
+				"tenant":          "acme",
+				"sub":             "repo:other-org/widgets",
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing required claim entirely",
+			claims: map[string]interface{}{
+				"weaklayer_group": groupID.String(),
+				"sub":             "repo:my-org/widgets",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			token := testServer.issueToken(t, "weaklayer-sensor", testCase.claims)
+
+			_, _, err := authenticator.Authenticate(context.Background(), token)
+			if testCase.wantErr && err == nil {
+				t.Fatal("Expected authentication to fail")
+			}
+			if !testCase.wantErr && err != nil {
+				t.Fatalf("Expected authentication to succeed, got error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// PKCS11Config describes how to reach the persistent keys an HSM holds for signing and
+// verifying sensor auth tokens.
+type PKCS11Config struct {
+	// Enabled selects a PKCS#11-backed TokenSigner instead of the raw HMAC secrets configured
+	// under sensor.token.secrets.
+	Enabled bool
+	// ModulePath is the filesystem path to the PKCS#11 module (.so) provided by the HSM vendor.
+	ModulePath string
+	// SlotID selects the token slot by id. Takes precedence over SlotLabel if both are set.
+	SlotID *uint
+	// SlotLabel selects the token slot by its label, if SlotID isn't set.
+	SlotLabel string
+	// PIN is the login PIN, used as-is if set.
+	PIN string
+	// PINEnv, if set and PIN is empty, names an environment variable holding the login PIN.
+	PINEnv string
+	// PINFile, if set and PIN and PINEnv are empty, is a path to a file holding the login PIN.
+	PINFile string
+	// ActiveKeyLabel is the CKA_LABEL of the persistent key that Sign uses.
+	ActiveKeyLabel string
+	// PreviousKeyLabels are CKA_LABELs of persistent keys still accepted by Verify during rotation.
+	PreviousKeyLabels []string
+}
+
+// resolvePIN returns the configured login PIN, reading it from the environment or a file as configured.
+func (config PKCS11Config) resolvePIN() (string, error) {
+	if config.PIN != "" {
+		return config.PIN, nil
+	}
+
+	if config.PINEnv != "" {
+		pin, ok := os.LookupEnv(config.PINEnv)
+		if !ok {
+			return "", fmt.Errorf("PIN environment variable %s is not set", config.PINEnv)
+		}
+		return pin, nil
+	}
+
+	if config.PINFile != "" {
+		pinBytes, err := ioutil.ReadFile(config.PINFile)
+		if err != nil {
+			return "", fmt.Errorf("Failed to read PIN file: %w", err)
+		}
+		return strings.TrimSpace(string(pinBytes)), nil
+	}
+
+	return "", fmt.Errorf("Must configure a PIN, a PIN environment variable, or a PIN file")
+}
+
+// pkcs11Signer is a TokenSigner backed by a persistent HMAC key held in a PKCS#11 token (HSM).
+// It re-opens its session and logs back in on demand, so that it tolerates the HSM dropping
+// long-lived sessions, which some HSMs do periodically.
+type pkcs11Signer struct {
+	config PKCS11Config
+	ctx    *pkcs11.Ctx
+	slotID uint
+
+	mutex   sync.Mutex
+	session pkcs11.SessionHandle
+	keys    map[string]pkcs11.ObjectHandle
+}
+
+// NewPKCS11Signer opens a session against the PKCS#11 module described by config, finds the
+// active and previous keys by their CKA_LABEL, and returns a TokenSigner that uses them.
+func NewPKCS11Signer(config PKCS11Config) (TokenSigner, error) {
+	ctx := pkcs11.New(config.ModulePath)
+	if ctx == nil {
+		return nil, fmt.Errorf("Failed to load PKCS#11 module at %s", config.ModulePath)
+	}
+
+	if err := ctx.Initialize(); err != nil {
+		return nil, fmt.Errorf("Failed to initialize PKCS#11 module: %w", err)
+	}
+
+	slotID, err := findSlot(ctx, config)
+	if err != nil {
+		ctx.Finalize()
+		return nil, err
+	}
+
+	signer := &pkcs11Signer{
+		config: config,
+		ctx:    ctx,
+		slotID: slotID,
+	}
+
+	if err := signer.reconnect(); err != nil {
+		ctx.Finalize()
+		return nil, err
+	}
+
+	return signer, nil
+}
+
+// findSlot resolves config's SlotID or SlotLabel to a concrete slot id.
+func findSlot(ctx *pkcs11.Ctx, config PKCS11Config) (uint, error) {
+	if config.SlotID != nil {
+		return *config.SlotID, nil
+	}
+
+	slotIDs, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to list PKCS#11 slots: %w", err)
+	}
+
+	for _, slotID := range slotIDs {
+		tokenInfo, err := ctx.GetTokenInfo(slotID)
+		if err != nil {
+			continue
+		}
+		if strings.TrimRight(tokenInfo.Label, "\x00 ") == config.SlotLabel {
+			return slotID, nil
+		}
+	}
+
+	return 0, fmt.Errorf("No PKCS#11 slot found with label %q", config.SlotLabel)
+}
+
+// reconnect (re)opens the PKCS#11 session, logs in, and looks up the active/previous keys by
+// label. It is called once at startup and again whenever a session is found to have gone stale.
+func (signer *pkcs11Signer) reconnect() error {
+	signer.mutex.Lock()
+	defer signer.mutex.Unlock()
+
+	pin, err := signer.config.resolvePIN()
+	if err != nil {
+		return err
+	}
+
+	session, err := signer.ctx.OpenSession(signer.slotID, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		return fmt.Errorf("Failed to open PKCS#11 session: %w", err)
+	}
+
+	if err := signer.ctx.Login(session, pkcs11.CKU_USER, pin); err != nil {
+		signer.ctx.CloseSession(session)
+		return fmt.Errorf("Failed to log in to PKCS#11 token: %w", err)
+	}
+
+	labels := append([]string{signer.config.ActiveKeyLabel}, signer.config.PreviousKeyLabels...)
+	keys := make(map[string]pkcs11.ObjectHandle, len(labels))
+	for _, label := range labels {
+		key, err := findKeyByLabel(signer.ctx, session, label)
+		if err != nil {
+			signer.ctx.Logout(session)
+			signer.ctx.CloseSession(session)
+			return fmt.Errorf("Failed to find PKCS#11 key labeled %q: %w", label, err)
+		}
+		keys[label] = key
+	}
+
+	signer.session = session
+	signer.keys = keys
+
+	return nil
+}
+
+// findKeyByLabel looks up the single secret key object with the given CKA_LABEL.
+func findKeyByLabel(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, label string) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+
+	if err := ctx.FindObjectsInit(session, template); err != nil {
+		return 0, fmt.Errorf("FindObjectsInit failed: %w", err)
+	}
+	defer ctx.FindObjectsFinal(session)
+
+	objects, _, err := ctx.FindObjects(session, 1)
+	if err != nil {
+		return 0, fmt.Errorf("FindObjects failed: %w", err)
+	}
+
+	if len(objects) == 0 {
+		return 0, fmt.Errorf("No key found with label %q", label)
+	}
+
+	return objects[0], nil
+}
+
+// withSession runs op against the current session, retrying once against a freshly reconnected
+// session if op fails with an invalid-session error, since long-lived HSM sessions can drop.
+func (signer *pkcs11Signer) withSession(op func(session pkcs11.SessionHandle, keys map[string]pkcs11.ObjectHandle) error) error {
+	signer.mutex.Lock()
+	session, keys := signer.session, signer.keys
+	signer.mutex.Unlock()
+
+	err := op(session, keys)
+	if err == nil {
+		return nil
+	}
+
+	if !isSessionInvalid(err) {
+		return err
+	}
+
+	if reconnectErr := signer.reconnect(); reconnectErr != nil {
+		return fmt.Errorf("PKCS#11 session was invalid and reconnecting failed: %w", reconnectErr)
+	}
+
+	signer.mutex.Lock()
+	session, keys = signer.session, signer.keys
+	signer.mutex.Unlock()
+
+	return op(session, keys)
+}
+
+// isSessionInvalid reports whether err indicates the HSM dropped the current session, e.g. due
+// to a timeout or a device reset, and a reconnect should be attempted.
+func isSessionInvalid(err error) bool {
+	pkcs11Err, ok := err.(pkcs11.Error)
+	if !ok {
+		return false
+	}
+
+	switch uint(pkcs11Err) {
+	case pkcs11.CKR_SESSION_HANDLE_INVALID, pkcs11.CKR_SESSION_CLOSED, pkcs11.CKR_DEVICE_ERROR:
+		return true
+	default:
+		return false
+	}
+}
+
+func (signer *pkcs11Signer) Sign(payload []byte) ([]byte, error) {
+	var signature []byte
+
+	err := signer.withSession(func(session pkcs11.SessionHandle, keys map[string]pkcs11.ObjectHandle) error {
+		key, ok := keys[signer.config.ActiveKeyLabel]
+		if !ok {
+			return fmt.Errorf("Active key %q is not loaded", signer.config.ActiveKeyLabel)
+		}
+
+		if err := signer.ctx.SignInit(session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_SHA512_HMAC, nil)}, key); err != nil {
+			return fmt.Errorf("SignInit failed: %w", err)
+		}
+
+		sig, err := signer.ctx.Sign(session, payload)
+		if err != nil {
+			return fmt.Errorf("Sign failed: %w", err)
+		}
+
+		signature = sig
+		return nil
+	})
+
+	return signature, err
+}
+
+func (signer *pkcs11Signer) Verify(payload []byte, sig []byte, keyID string) bool {
+	err := signer.withSession(func(session pkcs11.SessionHandle, keys map[string]pkcs11.ObjectHandle) error {
+		key, ok := keys[keyID]
+		if !ok {
+			return fmt.Errorf("Key %q is not loaded", keyID)
+		}
+
+		if err := signer.ctx.VerifyInit(session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_SHA512_HMAC, nil)}, key); err != nil {
+			return fmt.Errorf("VerifyInit failed: %w", err)
+		}
+
+		return signer.ctx.Verify(session, payload, sig)
+	})
+
+	return err == nil
+}
+
+func (signer *pkcs11Signer) ActiveKeyID() string {
+	return signer.config.ActiveKeyLabel
+}
+
+func (signer *pkcs11Signer) KeyIDs() []string {
+	keyIDs := make([]string, 0, 1+len(signer.config.PreviousKeyLabels))
+	keyIDs = append(keyIDs, signer.config.ActiveKeyLabel)
+	keyIDs = append(keyIDs, signer.config.PreviousKeyLabels...)
+	return keyIDs
+}
+
+func (signer *pkcs11Signer) Alg() string {
+	return HMACSignerAlg
+}
@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// HMACSignerAlg is the JWS "alg" header value tokens carry when signed by hmacSecretsSigner or
+// pkcs11Signer, both of which sign with an opaque HMAC-SHA512 key.
+const HMACSignerAlg = "WL-HMAC-SHA512"
+
+// TokenSigner signs and verifies sensor auth token payloads. It abstracts over where the
+// signing key material actually lives, so sensor auth tokens can be backed by raw secrets
+// embedded in the config file, persistent keys held in a PKCS#11-backed HSM, or local asymmetric
+// key pairs.
+type TokenSigner interface {
+	// Sign signs payload with the currently active key and returns the raw signature.
+	Sign(payload []byte) ([]byte, error)
+	// Verify reports whether sig is a valid signature over payload under the key identified by keyID.
+	Verify(payload []byte, sig []byte, keyID string) bool
+	// ActiveKeyID identifies the key that Sign signs with.
+	ActiveKeyID() string
+	// KeyIDs returns the active key ID followed by the previous key IDs still accepted by Verify.
+	// Callers use this to retry verification across a key rotation.
+	KeyIDs() []string
+	// Alg identifies the JWS "alg" header value tokens should be signed with under this signer.
+	Alg() string
+}
+
+// PublicKeyProvider is implemented by TokenSigner backends whose keys can be safely published for
+// offline verification, e.g. an asymmetric signer. The HMAC secret and PKCS#11-backed signers
+// don't implement it, since their key material must stay secret.
+type PublicKeyProvider interface {
+	// PublicJWKS returns every key this signer knows about (active and past) as a JSON Web Key Set.
+	PublicJWKS() jose.JSONWebKeySet
+}
+
+// hmacSecretsSigner is a TokenSigner backed by raw HMAC-SHA512 secrets embedded in the config
+// file. This is the signer that has always backed sensor auth tokens.
+type hmacSecretsSigner struct {
+	activeKeyID    string
+	previousKeyIDs []string
+	secretsByKeyID map[string][]byte
+}
+
+// NewHMACSecretsSigner creates a TokenSigner from a currently active secret and zero or more
+// previous secrets still accepted for verification during rotation. Key IDs are derived from the
+// secret's own content rather than its position, so a secret keeps the same key ID across
+// rotations: it doesn't matter whether one Processor signed a token while the secret was active
+// and a later Processor (after rotation) verifies it while the secret is listed as previous.
+func NewHMACSecretsSigner(activeSecret []byte, previousSecrets [][]byte) TokenSigner {
+	activeKeyID := hmacSecretKeyID(activeSecret)
+	secretsByKeyID := map[string][]byte{activeKeyID: activeSecret}
+	previousKeyIDs := make([]string, len(previousSecrets))
+	for i, secret := range previousSecrets {
+		keyID := hmacSecretKeyID(secret)
+		previousKeyIDs[i] = keyID
+		secretsByKeyID[keyID] = secret
+	}
+
+	return &hmacSecretsSigner{
+		activeKeyID:    activeKeyID,
+		previousKeyIDs: previousKeyIDs,
+		secretsByKeyID: secretsByKeyID,
+	}
+}
+
+// hmacSecretKeyID derives a stable key ID from a secret's content, so the ID a secret is known by
+// doesn't change when it moves from active to previous (or vice versa) across a rotation.
+func hmacSecretKeyID(secret []byte) string {
+	sum := sha256.Sum256(secret)
+	return hex.EncodeToString(sum[:8])
+}
+
+func (signer *hmacSecretsSigner) Sign(payload []byte) ([]byte, error) {
+	mac := hmac.New(sha512.New, signer.secretsByKeyID[signer.activeKeyID])
+	mac.Write(payload)
+	return mac.Sum(nil), nil
+}
+
+func (signer *hmacSecretsSigner) Verify(payload []byte, sig []byte, keyID string) bool {
+	secret, ok := signer.secretsByKeyID[keyID]
+	if !ok {
+		return false
+	}
+
+	mac := hmac.New(sha512.New, secret)
+	mac.Write(payload)
+	return hmac.Equal(mac.Sum(nil), sig)
+}
+
+func (signer *hmacSecretsSigner) ActiveKeyID() string {
+	return signer.activeKeyID
+}
+
+func (signer *hmacSecretsSigner) KeyIDs() []string {
+	keyIDs := make([]string, 0, 1+len(signer.previousKeyIDs))
+	keyIDs = append(keyIDs, signer.activeKeyID)
+	keyIDs = append(keyIDs, signer.previousKeyIDs...)
+	return keyIDs
+}
+
+func (signer *hmacSecretsSigner) Alg() string {
+	return HMACSignerAlg
+}
@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VerifierStore resolves the install Verifiers configured for a group. Implementations include
+// the in-memory VerifierRegistry, a FileVerifierStore reloadable without a gateway restart, and
+// an HTTPVerifierStore for large multi-tenant deployments where enumerating every group's
+// verifiers at startup is infeasible.
+type VerifierStore interface {
+	Verifiers(ctx context.Context, group uuid.UUID) ([]Verifier, error)
+}
+
+// cachingVerifierStoreEntry is a single group's cached lookup result, valid until expiresAt.
+type cachingVerifierStoreEntry struct {
+	verifiers []Verifier
+	expiresAt time.Time
+}
+
+// CachingVerifierStore wraps a VerifierStore with a short in-process cache keyed by group, so a
+// burst of install requests for the same group doesn't repeatedly hit a file or remote lookup
+// service.
+type CachingVerifierStore struct {
+	store VerifierStore
+	ttl   time.Duration
+
+	mutex sync.Mutex
+	cache map[uuid.UUID]cachingVerifierStoreEntry
+
+	// stopSweep signals reapExpiredEntries to stop. Closed by Close.
+	stopSweep chan struct{}
+}
+
+// cacheSweepInterval is how often CachingVerifierStore scans its cache for expired entries to
+// evict. Unlike install Keys, the Key.Group an unauthenticated install request is evaluated
+// against is attacker-controlled, so a stream of install requests each using a fresh, never-seen
+// group would otherwise grow the cache without bound: entries only ever expired lazily, on the
+// next lookup for that same group, which may never come.
+const cacheSweepInterval = 1 * time.Minute
+
+// NewCachingVerifierStore wraps store with an in-process cache that holds each group's lookup
+// result for ttl. A background goroutine periodically evicts expired entries; see
+// reapExpiredEntries.
+func NewCachingVerifierStore(store VerifierStore, ttl time.Duration) *CachingVerifierStore {
+	cachingStore := &CachingVerifierStore{
+		store:     store,
+		ttl:       ttl,
+		cache:     make(map[uuid.UUID]cachingVerifierStoreEntry),
+		stopSweep: make(chan struct{}),
+	}
+
+	go cachingStore.reapExpiredEntries()
+
+	return cachingStore
+}
+
+// Close stops the background sweep started by NewCachingVerifierStore. It is safe, but not
+// required, to never call Close: the cache is otherwise harmless to leave running for the
+// lifetime of the process.
+func (cachingStore *CachingVerifierStore) Close() {
+	close(cachingStore.stopSweep)
+}
+
+// reapExpiredEntries periodically evicts cache entries past their expiresAt, bounding the cache's
+// size even when groups are looked up once and never again. Runs until stopSweep is closed.
+func (cachingStore *CachingVerifierStore) reapExpiredEntries() {
+	ticker := time.NewTicker(cacheSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cachingStore.reapExpiredEntriesNow(time.Now())
+		case <-cachingStore.stopSweep:
+			return
+		}
+	}
+}
+
+// reapExpiredEntriesNow deletes every cache entry whose expiresAt is before now. Split out from
+// reapExpiredEntries so tests can trigger a sweep without waiting on cacheSweepInterval.
+func (cachingStore *CachingVerifierStore) reapExpiredEntriesNow(now time.Time) {
+	cachingStore.mutex.Lock()
+	defer cachingStore.mutex.Unlock()
+
+	for group, entry := range cachingStore.cache {
+		if now.After(entry.expiresAt) {
+			delete(cachingStore.cache, group)
+		}
+	}
+}
+
+// Verifiers returns the cached result for group if still fresh, otherwise queries the underlying
+// store and caches the result for ttl.
+func (cachingStore *CachingVerifierStore) Verifiers(ctx context.Context, group uuid.UUID) ([]Verifier, error) {
+	cachingStore.mutex.Lock()
+	entry, cached := cachingStore.cache[group]
+	cachingStore.mutex.Unlock()
+
+	if cached && time.Now().Before(entry.expiresAt) {
+		return entry.verifiers, nil
+	}
+
+	verifiers, err := cachingStore.store.Verifiers(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+
+	cachingStore.mutex.Lock()
+	cachingStore.cache[group] = cachingVerifierStoreEntry{verifiers: verifiers, expiresAt: time.Now().Add(cachingStore.ttl)}
+	cachingStore.mutex.Unlock()
+
+	return verifiers, nil
+}
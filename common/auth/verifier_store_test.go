@@ -0,0 +1,186 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newTestVerifier(t *testing.T, group uuid.UUID) Verifier {
+	t.Helper()
+
+	key, err := NewKey(group)
+	if err != nil {
+		t.Fatalf("Failed to create test Key: %v", err)
+	}
+
+	verifier, err := NewVerifier(key)
+	if err != nil {
+		t.Fatalf("Failed to create test Verifier: %v", err)
+	}
+
+	return verifier
+}
+
+func TestVerifierRegistryScopesLookupByGroup(t *testing.T) {
+	groupA, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to create test group UUID: %v", err)
+	}
+	groupB, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to create test group UUID: %v", err)
+	}
+
+	verifierA := newTestVerifier(t, groupA)
+	registry := NewVerifierRegistry([]Verifier{verifierA})
+
+	verifiersA, err := registry.Verifiers(context.Background(), groupA)
+	if err != nil {
+		t.Fatalf("Verifiers returned an error: %v", err)
+	}
+	if len(verifiersA) != 1 {
+		t.Fatalf("Expected 1 verifier for groupA, got %d", len(verifiersA))
+	}
+
+	verifiersB, err := registry.Verifiers(context.Background(), groupB)
+	if err != nil {
+		t.Fatalf("Verifiers returned an error: %v", err)
+	}
+	if len(verifiersB) != 0 {
+		t.Fatalf("Expected no verifiers for groupB, got %d", len(verifiersB))
+	}
+}
+
+func TestVerifierRegistryRegister(t *testing.T) {
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to create test group UUID: %v", err)
+	}
+
+	registry := NewVerifierRegistry(nil)
+	registry.Register(newTestVerifier(t, group))
+
+	verifiers, err := registry.Verifiers(context.Background(), group)
+	if err != nil {
+		t.Fatalf("Verifiers returned an error: %v", err)
+	}
+	if len(verifiers) != 1 {
+		t.Fatalf("Expected 1 verifier after Register, got %d", len(verifiers))
+	}
+}
+
+type countingVerifierStore struct {
+	calls     int
+	verifiers []Verifier
+}
+
+func (store *countingVerifierStore) Verifiers(ctx context.Context, group uuid.UUID) ([]Verifier, error) {
+	store.calls++
+	return store.verifiers, nil
+}
+
+func TestCachingVerifierStoreCachesWithinTTL(t *testing.T) {
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to create test group UUID: %v", err)
+	}
+
+	backing := &countingVerifierStore{verifiers: []Verifier{newTestVerifier(t, group)}}
+	cachingStore := NewCachingVerifierStore(backing, time.Hour)
+	defer cachingStore.Close()
+
+	for i := 0; i < 3; i++ {
+		verifiers, err := cachingStore.Verifiers(context.Background(), group)
+		if err != nil {
+			t.Fatalf("Verifiers returned an error: %v", err)
+		}
+		if len(verifiers) != 1 {
+			t.Fatalf("Expected 1 verifier, got %d", len(verifiers))
+		}
+	}
+
+	if backing.calls != 1 {
+		t.Fatalf("Expected the backing store to be queried once, got %d calls", backing.calls)
+	}
+}
+
+func TestCachingVerifierStoreRefreshesAfterTTL(t *testing.T) {
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to create test group UUID: %v", err)
+	}
+
+	backing := &countingVerifierStore{verifiers: []Verifier{newTestVerifier(t, group)}}
+	cachingStore := NewCachingVerifierStore(backing, time.Nanosecond)
+	defer cachingStore.Close()
+
+	if _, err := cachingStore.Verifiers(context.Background(), group); err != nil {
+		t.Fatalf("Verifiers returned an error: %v", err)
+	}
+
+	time.Sleep(time.Millisecond)
+
+	if _, err := cachingStore.Verifiers(context.Background(), group); err != nil {
+		t.Fatalf("Verifiers returned an error: %v", err)
+	}
+
+	if backing.calls != 2 {
+		t.Fatalf("Expected the backing store to be queried again after the TTL expired, got %d calls", backing.calls)
+	}
+}
+
+// TestCachingVerifierStoreReapsExpiredEntries confirms expired entries are evicted from the
+// cache even when their group is never looked up again, rather than sitting there indefinitely.
+func TestCachingVerifierStoreReapsExpiredEntries(t *testing.T) {
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to create test group UUID: %v", err)
+	}
+
+	backing := &countingVerifierStore{verifiers: []Verifier{newTestVerifier(t, group)}}
+	cachingStore := NewCachingVerifierStore(backing, time.Nanosecond)
+	defer cachingStore.Close()
+
+	if _, err := cachingStore.Verifiers(context.Background(), group); err != nil {
+		t.Fatalf("Verifiers returned an error: %v", err)
+	}
+
+	cachingStore.mutex.Lock()
+	cacheSize := len(cachingStore.cache)
+	cachingStore.mutex.Unlock()
+	if cacheSize != 1 {
+		t.Fatalf("Expected 1 cache entry after lookup, got %d", cacheSize)
+	}
+
+	time.Sleep(time.Millisecond)
+	cachingStore.reapExpiredEntriesNow(time.Now())
+
+	cachingStore.mutex.Lock()
+	cacheSize = len(cachingStore.cache)
+	cachingStore.mutex.Unlock()
+	if cacheSize != 0 {
+		t.Fatalf("Expected the expired entry to be reaped, cache still has %d entries", cacheSize)
+	}
+}
@@ -0,0 +1,228 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package configsig implements detached JWS signing and verification for the gateway config
+// file, so operators can ship the config through untrusted channels (config maps, git) with
+// tamper evidence independent of the transport.
+package configsig
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// allowedAlgorithms are the JWS signature algorithms accepted when verifying a config signature.
+var allowedAlgorithms = map[jose.SignatureAlgorithm]bool{
+	jose.RS256: true,
+	jose.ES256: true,
+	jose.EdDSA: true,
+}
+
+// LoadVerificationKey resolves the public key used to verify a config signature. Exactly one of
+// pemPath or jwksURL must be set: pemPath names a local PEM-encoded public key file, jwksURL names
+// a JWKS endpoint to fetch keys from. The returned value is either a crypto.PublicKey or a
+// jose.JSONWebKeySet, suitable for passing to VerifyDetachedSignature.
+func LoadVerificationKey(pemPath string, jwksURL string) (interface{}, error) {
+	if pemPath != "" && jwksURL != "" {
+		return nil, fmt.Errorf("Must specify only one of a PEM public key path or a JWKS URL")
+	}
+
+	if pemPath != "" {
+		return loadPEMPublicKey(pemPath)
+	}
+
+	if jwksURL != "" {
+		return fetchJWKS(jwksURL)
+	}
+
+	return nil, fmt.Errorf("Must specify either a PEM public key path or a JWKS URL")
+}
+
+func loadPEMPublicKey(pemPath string) (crypto.PublicKey, error) {
+	pemBytes, err := ioutil.ReadFile(pemPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read public key file: %w", err)
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("Failed to decode PEM block from public key file")
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse public key: %w", err)
+	}
+
+	return publicKey, nil
+}
+
+func fetchJWKS(jwksURL string) (jose.JSONWebKeySet, error) {
+	var keySet jose.JSONWebKeySet
+
+	client := http.Client{Timeout: 10 * time.Second}
+	response, err := client.Get(jwksURL)
+	if err != nil {
+		return keySet, fmt.Errorf("Failed to fetch JWKS from %s: %w", jwksURL, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return keySet, fmt.Errorf("Unexpected HTTP status %d fetching JWKS from %s", response.StatusCode, jwksURL)
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(&keySet); err != nil {
+		return keySet, fmt.Errorf("Failed to parse JWKS from %s: %w", jwksURL, err)
+	}
+
+	return keySet, nil
+}
+
+// VerifyDetachedSignature checks that signature is a valid detached JWS over payload, signed with
+// one of the allowed algorithms (RS256, ES256, EdDSA) and verifiable under key. key is whatever
+// LoadVerificationKey returned: either a single public key, or a jose.JSONWebKeySet to select a
+// key from by the signature's "kid" header.
+func VerifyDetachedSignature(payload []byte, signature []byte, key interface{}) error {
+	object, err := jose.ParseDetached(string(signature), payload)
+	if err != nil {
+		return fmt.Errorf("Failed to parse config signature: %w", err)
+	}
+
+	header := object.Signatures[0].Header
+	if !allowedAlgorithms[jose.SignatureAlgorithm(header.Algorithm)] {
+		return fmt.Errorf("Config signature uses disallowed algorithm '%s'", header.Algorithm)
+	}
+
+	verificationKey, err := resolveKey(key, header.KeyID)
+	if err != nil {
+		return err
+	}
+
+	if err := object.DetachedVerify(payload, verificationKey); err != nil {
+		return fmt.Errorf("Config signature verification failed: %w", err)
+	}
+
+	return nil
+}
+
+// resolveKey picks the concrete key to verify with. If key is a JWKS, the signature's key ID
+// selects which key in the set to use; otherwise key is already a single public key.
+func resolveKey(key interface{}, keyID string) (interface{}, error) {
+	keySet, ok := key.(jose.JSONWebKeySet)
+	if !ok {
+		return key, nil
+	}
+
+	matches := keySet.Key(keyID)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("No JWKS key found with key ID '%s'", keyID)
+	}
+
+	return matches[0].Key, nil
+}
+
+// LoadSigningKey reads a PEM-encoded private key from pemPath and returns it along with the JWS
+// algorithm appropriate for its type (RS256, ES256, or EdDSA).
+func LoadSigningKey(pemPath string) (interface{}, jose.SignatureAlgorithm, error) {
+	pemBytes, err := ioutil.ReadFile(pemPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to read private key file: %w", err)
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, "", fmt.Errorf("Failed to decode PEM block from private key file")
+	}
+
+	key, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	alg, err := algorithmForKey(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return key, alg, nil
+}
+
+func parsePrivateKey(der []byte) (interface{}, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("Failed to parse private key: unsupported or invalid format")
+}
+
+func algorithmForKey(key interface{}) (jose.SignatureAlgorithm, error) {
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		return jose.RS256, nil
+	case *ecdsa.PrivateKey:
+		return jose.ES256, nil
+	case ed25519.PrivateKey:
+		return jose.EdDSA, nil
+	default:
+		return "", fmt.Errorf("Unsupported private key type %T", key)
+	}
+}
+
+// SignConfig produces a detached JWS signature over payload using privateKey with alg. If keyID is
+// non-empty, it is embedded as the signature's "kid" header so verifiers can select this key out
+// of a JWKS.
+func SignConfig(payload []byte, privateKey interface{}, alg jose.SignatureAlgorithm, keyID string) ([]byte, error) {
+	signerOptions := &jose.SignerOptions{}
+	if keyID != "" {
+		signerOptions = signerOptions.WithHeader("kid", keyID)
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: privateKey}, signerOptions)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create JWS signer: %w", err)
+	}
+
+	object, err := signer.Sign(payload)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to sign config: %w", err)
+	}
+
+	serialized, err := object.DetachedCompactSerialize()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to serialize config signature: %w", err)
+	}
+
+	return []byte(serialized), nil
+}
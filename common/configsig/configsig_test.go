@@ -0,0 +1,163 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package configsig
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+func TestSignAndVerifyDetachedEdDSA(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate test key pair: %v", err)
+	}
+
+	payload := []byte("sensor:\n  token:\n    duration: 100\n")
+
+	signature, err := SignConfig(payload, privateKey, jose.EdDSA, "")
+	if err != nil {
+		t.Fatalf("Failed to sign config: %v", err)
+	}
+
+	err = VerifyDetachedSignature(payload, signature, publicKey)
+	if err != nil {
+		t.Fatalf("Expected signature to verify: %v", err)
+	}
+}
+
+func TestSignAndVerifyDetachedES256(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate test key pair: %v", err)
+	}
+
+	payload := []byte("sensor:\n  token:\n    duration: 100\n")
+
+	signature, err := SignConfig(payload, privateKey, jose.ES256, "")
+	if err != nil {
+		t.Fatalf("Failed to sign config: %v", err)
+	}
+
+	err = VerifyDetachedSignature(payload, signature, &privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Expected signature to verify: %v", err)
+	}
+}
+
+func TestVerifyDetachedTamperedPayload(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate test key pair: %v", err)
+	}
+
+	payload := []byte("sensor:\n  token:\n    duration: 100\n")
+
+	signature, err := SignConfig(payload, privateKey, jose.EdDSA, "")
+	if err != nil {
+		t.Fatalf("Failed to sign config: %v", err)
+	}
+
+	err = VerifyDetachedSignature([]byte("sensor:\n  token:\n    duration: 999\n"), signature, publicKey)
+	if err == nil {
+		t.Fatal("Expected signature verification to fail for a tampered payload")
+	}
+}
+
+func TestVerifyDetachedWrongKey(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate test key pair: %v", err)
+	}
+
+	otherPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate second test key pair: %v", err)
+	}
+
+	payload := []byte("sensor:\n  token:\n    duration: 100\n")
+
+	signature, err := SignConfig(payload, privateKey, jose.EdDSA, "")
+	if err != nil {
+		t.Fatalf("Failed to sign config: %v", err)
+	}
+
+	err = VerifyDetachedSignature(payload, signature, otherPublicKey)
+	if err == nil {
+		t.Fatal("Expected signature verification to fail against the wrong public key")
+	}
+}
+
+func TestVerifyDetachedJWKSSelectsByKeyID(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate test key pair: %v", err)
+	}
+
+	otherPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate second test key pair: %v", err)
+	}
+
+	payload := []byte("sensor:\n  token:\n    duration: 100\n")
+
+	signature, err := SignConfig(payload, privateKey, jose.EdDSA, "signing-key-1")
+	if err != nil {
+		t.Fatalf("Failed to sign config: %v", err)
+	}
+
+	keySet := jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{
+			{Key: otherPublicKey, KeyID: "signing-key-0", Algorithm: "EdDSA", Use: "sig"},
+			{Key: publicKey, KeyID: "signing-key-1", Algorithm: "EdDSA", Use: "sig"},
+		},
+	}
+
+	err = VerifyDetachedSignature(payload, signature, keySet)
+	if err != nil {
+		t.Fatalf("Expected signature to verify against the matching JWKS entry: %v", err)
+	}
+}
+
+func TestVerifyDetachedUnknownKeyID(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate test key pair: %v", err)
+	}
+
+	payload := []byte("sensor:\n  token:\n    duration: 100\n")
+
+	signature, err := SignConfig(payload, privateKey, jose.EdDSA, "signing-key-1")
+	if err != nil {
+		t.Fatalf("Failed to sign config: %v", err)
+	}
+
+	keySet := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{}}
+
+	err = VerifyDetachedSignature(payload, signature, keySet)
+	if err == nil {
+		t.Fatal("Expected signature verification to fail when the JWKS has no matching key ID")
+	}
+}
@@ -0,0 +1,194 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package envelope implements envelope encryption for event output files, modeled on OCI image
+// envelope encryption: a fresh content-encryption key protects the file's content, and the key
+// itself is wrapped for one or more recipients so only their holders can recover it.
+package envelope
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+)
+
+// Recipient identifies one way to wrap a content-encryption key, so it can later be unwrapped by
+// whoever holds the matching private material. Wrap produces one WrappedKey per Recipient.
+type Recipient struct {
+	// Type selects the wrapping scheme: "jwe", "pkcs7", or "keyprovider".
+	Type string
+
+	// PublicKeyPath is a PEM-encoded RSA or EC public key, used when Type is "jwe".
+	PublicKeyPath string
+
+	// CertificatePath is a PEM-encoded X.509 certificate, used when Type is "pkcs7".
+	CertificatePath string
+
+	// ProviderName and ProviderEndpoint identify an external key-wrapping service, used when Type
+	// is "keyprovider". This mirrors the ocicrypt "keyprovider" protocol, but as a plain HTTP call
+	// rather than gRPC, since the gateway carries no other gRPC dependency.
+	ProviderName     string
+	ProviderEndpoint string
+}
+
+// WrappedKey is a single wrapped content-encryption key, as recorded in an encrypted output
+// file's header.
+type WrappedKey struct {
+	Type    string `json:"type"`
+	Label   string `json:"label,omitempty"`
+	Wrapped []byte `json:"wrapped"`
+}
+
+// UnwrapKey identifies the private material used to recover the content-encryption key from a
+// WrappedKey of the matching Type.
+type UnwrapKey struct {
+	// Type selects the wrapping scheme: "jwe", "pkcs7", or "keyprovider".
+	Type string
+
+	// PrivateKeyPath is a PEM-encoded RSA or EC private key, used when Type is "jwe".
+	PrivateKeyPath string
+
+	// CertificatePath and PrivateKeyPath together identify the PKCS#7 recipient, used when Type
+	// is "pkcs7".
+	CertificatePath string
+
+	// ProviderName and ProviderEndpoint identify the external key-wrapping service to call to
+	// unwrap the key, used when Type is "keyprovider".
+	ProviderName     string
+	ProviderEndpoint string
+}
+
+// Wrap wraps cek once for each recipient, in order.
+func Wrap(cek []byte, recipients []Recipient) ([]WrappedKey, error) {
+	wrappedKeys := make([]WrappedKey, 0, len(recipients))
+
+	for _, recipient := range recipients {
+		var wrapped []byte
+		var err error
+
+		switch recipient.Type {
+		case "jwe":
+			wrapped, err = wrapJWE(cek, recipient.PublicKeyPath)
+		case "pkcs7":
+			wrapped, err = wrapPKCS7(cek, recipient.CertificatePath)
+		case "keyprovider":
+			wrapped, err = wrapKeyProvider(cek, recipient.ProviderName, recipient.ProviderEndpoint)
+		default:
+			err = fmt.Errorf("Unknown key wrapping type '%s'", recipient.Type)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to wrap content encryption key for recipient type '%s': %w", recipient.Type, err)
+		}
+
+		wrappedKeys = append(wrappedKeys, WrappedKey{Type: recipient.Type, Label: recipient.ProviderName, Wrapped: wrapped})
+	}
+
+	return wrappedKeys, nil
+}
+
+// Unwrap finds the WrappedKey matching unwrapKey's type (and, for keyprovider, its provider name)
+// and unwraps it to recover the content-encryption key.
+func Unwrap(wrappedKeys []WrappedKey, unwrapKey UnwrapKey) ([]byte, error) {
+	for _, wrappedKey := range wrappedKeys {
+		if wrappedKey.Type != unwrapKey.Type {
+			continue
+		}
+		if unwrapKey.Type == "keyprovider" && wrappedKey.Label != unwrapKey.ProviderName {
+			continue
+		}
+
+		switch unwrapKey.Type {
+		case "jwe":
+			return unwrapJWE(wrappedKey.Wrapped, unwrapKey.PrivateKeyPath)
+		case "pkcs7":
+			return unwrapPKCS7(wrappedKey.Wrapped, unwrapKey.CertificatePath, unwrapKey.PrivateKeyPath)
+		case "keyprovider":
+			return unwrapKeyProvider(wrappedKey.Wrapped, unwrapKey.ProviderName, unwrapKey.ProviderEndpoint)
+		default:
+			return nil, fmt.Errorf("Unknown key wrapping type '%s'", unwrapKey.Type)
+		}
+	}
+
+	return nil, fmt.Errorf("No wrapped content encryption key found matching type '%s'", unwrapKey.Type)
+}
+
+func loadPEMPublicKey(pemPath string) (crypto.PublicKey, error) {
+	pemBytes, err := ioutil.ReadFile(pemPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read public key file: %w", err)
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("Failed to decode PEM block from public key file")
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse public key: %w", err)
+	}
+
+	return publicKey, nil
+}
+
+func loadPEMPrivateKey(pemPath string) (crypto.PrivateKey, error) {
+	pemBytes, err := ioutil.ReadFile(pemPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read private key file: %w", err)
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("Failed to decode PEM block from private key file")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("Failed to parse private key: unsupported or invalid format")
+}
+
+func loadPEMCertificate(pemPath string) (*x509.Certificate, error) {
+	pemBytes, err := ioutil.ReadFile(pemPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read certificate file: %w", err)
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("Failed to decode PEM block from certificate file")
+	}
+
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse certificate: %w", err)
+	}
+
+	return certificate, nil
+}
@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package envelope
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func writeTempPEMFile(t *testing.T, dir string, name string, block *pem.Block) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+
+	return path
+}
+
+func TestWrapUnwrapJWERoundTrip(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate test key pair: %v", err)
+	}
+
+	dir := t.TempDir()
+
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+	publicKeyPath := writeTempPEMFile(t, dir, "public.pem", &pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyBytes})
+
+	privateKeyBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal private key: %v", err)
+	}
+	privateKeyPath := writeTempPEMFile(t, dir, "private.pem", &pem.Block{Type: "PRIVATE KEY", Bytes: privateKeyBytes})
+
+	cek := make([]byte, 32)
+	if _, err := rand.Read(cek); err != nil {
+		t.Fatalf("Failed to generate content encryption key: %v", err)
+	}
+
+	wrappedKeys, err := Wrap(cek, []Recipient{{Type: "jwe", PublicKeyPath: publicKeyPath}})
+	if err != nil {
+		t.Fatalf("Failed to wrap content encryption key: %v", err)
+	}
+	if len(wrappedKeys) != 1 {
+		t.Fatalf("Expected 1 wrapped key, got %d", len(wrappedKeys))
+	}
+
+	unwrapped, err := Unwrap(wrappedKeys, UnwrapKey{Type: "jwe", PrivateKeyPath: privateKeyPath})
+	if err != nil {
+		t.Fatalf("Failed to unwrap content encryption key: %v", err)
+	}
+
+	if string(unwrapped) != string(cek) {
+		t.Fatal("Unwrapped content encryption key does not match the original")
+	}
+}
+
+func TestUnwrapJWEWrongKeyFails(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate test key pair: %v", err)
+	}
+
+	otherPrivateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate second test key pair: %v", err)
+	}
+
+	dir := t.TempDir()
+
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+	publicKeyPath := writeTempPEMFile(t, dir, "public.pem", &pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyBytes})
+
+	otherPrivateKeyBytes, err := x509.MarshalPKCS8PrivateKey(otherPrivateKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal private key: %v", err)
+	}
+	otherPrivateKeyPath := writeTempPEMFile(t, dir, "other-private.pem", &pem.Block{Type: "PRIVATE KEY", Bytes: otherPrivateKeyBytes})
+
+	cek := make([]byte, 32)
+	if _, err := rand.Read(cek); err != nil {
+		t.Fatalf("Failed to generate content encryption key: %v", err)
+	}
+
+	wrappedKeys, err := Wrap(cek, []Recipient{{Type: "jwe", PublicKeyPath: publicKeyPath}})
+	if err != nil {
+		t.Fatalf("Failed to wrap content encryption key: %v", err)
+	}
+
+	_, err = Unwrap(wrappedKeys, UnwrapKey{Type: "jwe", PrivateKeyPath: otherPrivateKeyPath})
+	if err == nil {
+		t.Fatal("Expected unwrap to fail with the wrong private key")
+	}
+}
@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package envelope
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// wrapJWE wraps cek as the plaintext of a compact JWE addressed to the RSA or EC public key at
+// pemPath, so only the holder of the matching private key can recover it.
+func wrapJWE(cek []byte, pemPath string) ([]byte, error) {
+	publicKey, err := loadPEMPublicKey(pemPath)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm, err := jweAlgorithmForPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypter, err := jose.NewEncrypter(jose.A256GCM, jose.Recipient{Algorithm: algorithm, Key: publicKey}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create JWE encrypter: %w", err)
+	}
+
+	object, err := encrypter.Encrypt(cek)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encrypt content encryption key: %w", err)
+	}
+
+	serialized, err := object.CompactSerialize()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to serialize wrapped content encryption key: %w", err)
+	}
+
+	return []byte(serialized), nil
+}
+
+// unwrapJWE recovers the content encryption key from a compact JWE produced by wrapJWE, using the
+// RSA or EC private key at pemPath.
+func unwrapJWE(wrapped []byte, pemPath string) ([]byte, error) {
+	privateKey, err := loadPEMPrivateKey(pemPath)
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := jose.ParseEncrypted(string(wrapped))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse wrapped content encryption key: %w", err)
+	}
+
+	cek, err := object.Decrypt(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt content encryption key: %w", err)
+	}
+
+	return cek, nil
+}
+
+func jweAlgorithmForPublicKey(publicKey crypto.PublicKey) (jose.KeyAlgorithm, error) {
+	switch publicKey.(type) {
+	case *rsa.PublicKey:
+		return jose.RSA_OAEP_256, nil
+	case *ecdsa.PublicKey:
+		return jose.ECDH_ES, nil
+	default:
+		return "", fmt.Errorf("Unsupported public key type %T for JWE key wrapping", publicKey)
+	}
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package envelope
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// keyProviderRequest is the body posted to an external key-wrapping service. It mirrors the shape
+// of the ocicrypt "keyprovider" protocol (operation, provider name, and the key material to
+// wrap/unwrap), but as a plain HTTP POST rather than gRPC, since the gateway carries no other
+// gRPC dependency and the external provider is expected to be a small, purpose-built sidecar.
+type keyProviderRequest struct {
+	Operation    string `json:"operation"`
+	ProviderName string `json:"providerName"`
+	KeyBytes     []byte `json:"keyBytes"`
+}
+
+type keyProviderResponse struct {
+	KeyBytes []byte `json:"keyBytes"`
+}
+
+var keyProviderClient = http.Client{Timeout: 10 * time.Second}
+
+// wrapKeyProvider asks the external key-wrapping service at endpoint to wrap cek.
+func wrapKeyProvider(cek []byte, providerName string, endpoint string) ([]byte, error) {
+	return callKeyProvider("wrap", cek, providerName, endpoint)
+}
+
+// unwrapKeyProvider asks the external key-wrapping service at endpoint to unwrap wrapped.
+func unwrapKeyProvider(wrapped []byte, providerName string, endpoint string) ([]byte, error) {
+	return callKeyProvider("unwrap", wrapped, providerName, endpoint)
+}
+
+func callKeyProvider(operation string, keyBytes []byte, providerName string, endpoint string) ([]byte, error) {
+	requestBody, err := json.Marshal(keyProviderRequest{
+		Operation:    operation,
+		ProviderName: providerName,
+		KeyBytes:     keyBytes,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to serialize key provider request: %w", err)
+	}
+
+	httpResponse, err := keyProviderClient.Post(endpoint, "application/json", bytes.NewReader(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to reach key provider '%s' at %s: %w", providerName, endpoint, err)
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Key provider '%s' at %s returned unexpected status %d", providerName, endpoint, httpResponse.StatusCode)
+	}
+
+	var response keyProviderResponse
+	if err := json.NewDecoder(httpResponse.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("Failed to parse key provider '%s' response: %w", providerName, err)
+	}
+
+	return response.KeyBytes, nil
+}
@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package envelope
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	"go.mozilla.org/pkcs7"
+)
+
+// wrapPKCS7 wraps cek as PKCS#7 enveloped data addressed to the X.509 certificate at certPath.
+func wrapPKCS7(cek []byte, certPath string) ([]byte, error) {
+	certificate, err := loadPEMCertificate(certPath)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := pkcs7.Encrypt(cek, []*x509.Certificate{certificate})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to PKCS#7-encrypt content encryption key: %w", err)
+	}
+
+	return wrapped, nil
+}
+
+// unwrapPKCS7 recovers the content encryption key from PKCS#7 enveloped data produced by
+// wrapPKCS7, using the certificate and matching private key at certPath and privateKeyPath.
+func unwrapPKCS7(wrapped []byte, certPath string, privateKeyPath string) ([]byte, error) {
+	certificate, err := loadPEMCertificate(certPath)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := loadPEMPrivateKey(privateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	envelopedData, err := pkcs7.Parse(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse wrapped content encryption key: %w", err)
+	}
+
+	cek, err := envelopedData.Decrypt(certificate, privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt content encryption key: %w", err)
+	}
+
+	return cek, nil
+}
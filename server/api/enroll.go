@@ -0,0 +1,249 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/weaklayer/gateway/common/auth"
+)
+
+const (
+	enrollDevicePath  = "/v1/enroll/device"
+	enrollTokenPath   = "/v1/enroll/token"
+	enrollApprovePath = "/v1/enroll/approve"
+)
+
+// DeviceEnrollResponse is returned by POST /v1/enroll/device.
+// Field names follow RFC 8628 (OAuth 2.0 Device Authorization Grant) since this endpoint
+// mirrors that flow for sensor enrollment.
+type DeviceEnrollResponse struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	Interval        int64  `json:"interval"`
+	ExpiresIn       int64  `json:"expires_in"`
+}
+
+type tokenEnrollRequest struct {
+	DeviceCode string `json:"device_code"`
+}
+
+// TokenEnrollResponse is returned by POST /v1/enroll/token once the enrollment is approved
+type TokenEnrollResponse struct {
+	Key auth.Key `json:"key"`
+}
+
+type approveEnrollRequest struct {
+	IDToken  string    `json:"idToken"`
+	UserCode string    `json:"userCode"`
+	Group    uuid.UUID `json:"group"`
+}
+
+// ApproveEnrollResponse is returned by POST /v1/enroll/approve on success
+type ApproveEnrollResponse struct {
+	Group uuid.UUID `json:"group"`
+}
+
+// EnrollAPI handles requests to the /v1/enroll path for device-code sensor enrollment.
+// It mirrors the OAuth 2.0 device authorization grant (RFC 8628): a sensor without an install
+// Key starts an enrollment, an operator approves it against a group, and the sensor polls until
+// a freshly generated Key is issued.
+type EnrollAPI struct {
+	store              *auth.EnrollmentStore
+	verifiers          *auth.VerifierRegistry
+	oidcAuthenticators []auth.OIDCAuthenticator
+	verificationURI    string
+	interval           int64
+}
+
+// NewEnrollAPI provisions an enroll API with its required resources.
+// verifiers is shared with the install API so a Verifier registered here is honored by /install
+// immediately. verificationURI is the operator-facing URL returned to sensors; interval is the
+// number of seconds a sensor should wait between polls of /v1/enroll/token.
+func NewEnrollAPI(store *auth.EnrollmentStore, verifiers *auth.VerifierRegistry, oidcIssuers []auth.OIDCIssuer, verificationURI string, interval int64) (EnrollAPI, error) {
+	var enrollAPI EnrollAPI
+
+	oidcAuthenticators := make([]auth.OIDCAuthenticator, 0, len(oidcIssuers))
+	for _, oidcIssuer := range oidcIssuers {
+		oidcAuthenticator, err := auth.NewOIDCAuthenticator(context.Background(), oidcIssuer)
+		if err != nil {
+			return enrollAPI, fmt.Errorf("Failed to provision OIDC authenticator for issuer %s: %w", oidcIssuer.IssuerURL, err)
+		}
+		oidcAuthenticators = append(oidcAuthenticators, oidcAuthenticator)
+	}
+
+	return EnrollAPI{
+		store:              store,
+		verifiers:          verifiers,
+		oidcAuthenticators: oidcAuthenticators,
+		verificationURI:    verificationURI,
+		interval:           interval,
+	}, nil
+}
+
+// Handle dispatches to the device, token, and approve sub-endpoints
+func (enrollAPI EnrollAPI) Handle(responseWriter http.ResponseWriter, request *http.Request) {
+	switch request.URL.Path {
+	case enrollDevicePath:
+		enrollAPI.handleDevice(responseWriter, request)
+	case enrollTokenPath:
+		enrollAPI.handleToken(responseWriter, request)
+	case enrollApprovePath:
+		enrollAPI.handleApprove(responseWriter, request)
+	default:
+		responseWriter.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// handleDevice starts a new enrollment for the requesting sensor
+func (enrollAPI EnrollAPI) handleDevice(responseWriter http.ResponseWriter, request *http.Request) {
+	deviceCode, userCode, expiresAt, err := enrollAPI.store.RequestDevice(clientIP(request))
+	if err != nil {
+		log.Info().Err(err).Msg("Device enrollment request denied")
+		responseWriter.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	response := DeviceEnrollResponse{
+		DeviceCode:      deviceCode,
+		UserCode:        userCode,
+		VerificationURI: enrollAPI.verificationURI,
+		Interval:        enrollAPI.interval,
+		ExpiresIn:       int64(time.Until(expiresAt).Seconds()),
+	}
+
+	writeEnrollJSON(responseWriter, response)
+}
+
+// handleToken lets the sensor poll for the Key issued once its user code is approved
+func (enrollAPI EnrollAPI) handleToken(responseWriter http.ResponseWriter, request *http.Request) {
+	bodyContents, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to read request body contents")
+		responseWriter.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var tokenRequest tokenEnrollRequest
+	if err := json.Unmarshal(bodyContents, &tokenRequest); err != nil || tokenRequest.DeviceCode == "" {
+		log.Info().Msg("Malformed enrollment token request")
+		responseWriter.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	key, status, err := enrollAPI.store.Poll(tokenRequest.DeviceCode)
+	if err != nil {
+		log.Info().Err(err).Msg("Enrollment token poll failed")
+		responseWriter.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	switch status {
+	case auth.EnrollmentPending:
+		responseWriter.WriteHeader(http.StatusAccepted)
+	case auth.EnrollmentExpired:
+		responseWriter.WriteHeader(http.StatusGone)
+	case auth.EnrollmentApproved:
+		writeEnrollJSON(responseWriter, TokenEnrollResponse{Key: key})
+	}
+}
+
+// handleApprove lets an operator, authenticated via OIDC, approve a sensor's user code for a group
+func (enrollAPI EnrollAPI) handleApprove(responseWriter http.ResponseWriter, request *http.Request) {
+	bodyContents, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to read request body contents")
+		responseWriter.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var approveRequest approveEnrollRequest
+	if err := json.Unmarshal(bodyContents, &approveRequest); err != nil || approveRequest.UserCode == "" {
+		log.Info().Msg("Malformed enrollment approval request")
+		responseWriter.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !enrollAPI.authenticateOperator(request.Context(), approveRequest.IDToken) {
+		log.Info().Msg("Unauthenticated enrollment approval request")
+		responseWriter.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	_, verifier, err := enrollAPI.store.Approve(approveRequest.UserCode, approveRequest.Group)
+	if err != nil {
+		log.Info().Err(err).Msg("Enrollment approval failed")
+		responseWriter.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	enrollAPI.verifiers.Register(verifier)
+
+	writeEnrollJSON(responseWriter, ApproveEnrollResponse{Group: approveRequest.Group})
+}
+
+// authenticateOperator reports whether idToken verifies against any configured OIDC issuer.
+// The resolved group claim is irrelevant here: unlike sensor install, approval only needs to
+// confirm the caller is a trusted operator, not which group they administer.
+func (enrollAPI EnrollAPI) authenticateOperator(ctx context.Context, idToken string) bool {
+	if idToken == "" {
+		return false
+	}
+
+	for _, oidcAuthenticator := range enrollAPI.oidcAuthenticators {
+		if _, _, err := oidcAuthenticator.Authenticate(ctx, idToken); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientIP extracts the remote client's address without its ephemeral port, for rate limiting
+func clientIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+
+	return host
+}
+
+func writeEnrollJSON(responseWriter http.ResponseWriter, value interface{}) {
+	responseBytes, err := json.Marshal(value)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to marshal response body")
+		responseWriter.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	responseWriter.Header().Set("Content-Type", "application/json")
+	responseWriter.Write(responseBytes)
+}
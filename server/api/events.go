@@ -20,34 +20,89 @@
 package api
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
 	"github.com/rs/zerolog/log"
 
+	"github.com/weaklayer/gateway/common/auth"
 	"github.com/weaklayer/gateway/server/events"
+	"github.com/weaklayer/gateway/server/output"
 	"github.com/weaklayer/gateway/server/processing"
+	"github.com/weaklayer/gateway/server/telemetry"
 	"github.com/weaklayer/gateway/server/token"
 )
 
+// backpressureRetryAfterSeconds is the value of the Retry-After header sent alongside a 503 when
+// an output sink is under backpressure, giving sensors a concrete backoff to honor.
+const backpressureRetryAfterSeconds = "1"
+
+// ndjsonContentType is the Content-Type sensors use to stream events one-per-line instead of
+// submitting a single (potentially large) JSON array
+const ndjsonContentType = "application/x-ndjson"
+
 // EventsAPI handles requests to the /events path
 type EventsAPI struct {
 	tokenProcessor token.Processor
 	eventProcessor processing.EventProcessor
+	signingKeys    *auth.SigningKeyRegistry
+	// revocationStore is consulted after token verification succeeds and may be nil, in which
+	// case no sensor or group is treated as revoked
+	revocationStore *token.RevocationStore
+	// chunkSize is the maximum number of NDJSON events buffered before they are handed off to
+	// eventProcessor.Consume
+	chunkSize int
+	// chunkInterval is the maximum amount of time NDJSON events are buffered before they are
+	// handed off to eventProcessor.Consume, even if chunkSize hasn't been reached
+	chunkInterval time.Duration
+	// maxRequestBodySize bounds the decompressed size of a request body, in addition to
+	// SensorAPI.MaxRequestBodySize bounding the compressed bytes read off the wire. Without this,
+	// a small Content-Encoding: gzip or zstd request could decompress to an unbounded size before
+	// ever being parsed. Zero means unbounded.
+	maxRequestBodySize int64
 }
 
-// NewEventsAPI provisions an events API with its required resources
-func NewEventsAPI(tokenProcessor token.Processor, eventProcessor processing.EventProcessor) (EventsAPI, error) {
+// NewEventsAPI provisions an events API with its required resources. chunkSize and chunkInterval
+// bound how large, and how long, a batch of NDJSON events is buffered before being handed off to
+// eventProcessor.Consume - this keeps memory use bounded for large uploads from sensors on flaky
+// links, unlike the JSON array format which is parsed as a single batch. revocationStore may be
+// nil to disable revocation checking. maxRequestBodySize should match SensorAPI.MaxRequestBodySize
+// and bounds a request body's decompressed size the same way that field bounds its size on the
+// wire; zero means unbounded.
+func NewEventsAPI(tokenProcessor token.Processor, eventProcessor processing.EventProcessor, signingKeys *auth.SigningKeyRegistry, revocationStore *token.RevocationStore, chunkSize int, chunkInterval time.Duration, maxRequestBodySize int64) (EventsAPI, error) {
 	return EventsAPI{
-		tokenProcessor: tokenProcessor,
-		eventProcessor: eventProcessor,
+		tokenProcessor:     tokenProcessor,
+		eventProcessor:     eventProcessor,
+		signingKeys:        signingKeys,
+		revocationStore:    revocationStore,
+		chunkSize:          chunkSize,
+		chunkInterval:      chunkInterval,
+		maxRequestBodySize: maxRequestBodySize,
 	}, nil
 }
 
-// Handle does nothing right now
+// Handle authenticates the request, checks for revocation, and hands the request body off to
+// consumeNDJSON or the JSON array parsing path below depending on Content-Type
 func (eventsAPI EventsAPI) Handle(responseWriter http.ResponseWriter, request *http.Request) {
 
+	recorder := newStatusRecorder(responseWriter)
+	responseWriter = recorder
+	defer func() {
+		telemetry.RecordResponse(request.Context(), "/events", recorder.statusCode)
+	}()
+
 	// Authenticate the request
 	var authToken string
 	authHeader := request.Header.Get("Authorization")
@@ -76,55 +131,247 @@ func (eventsAPI EventsAPI) Handle(responseWriter http.ResponseWriter, request *h
 	sensor := claims.Sensor
 	group := claims.Group
 
-	// Start parsing the request body
-	// The request body is expected to be a (potentially large) JSON array of events
-	// Different event types can be mixed in the array
-	decoder := json.NewDecoder(request.Body)
+	if eventsAPI.revocationStore != nil && eventsAPI.revocationStore.IsRevoked(sensor, group, claims.IssuedAt) {
+		log.Info().Str("sensor", sensor.String()).Str("group", group.String()).Msg("Token sensor or group has been revoked")
+		responseWriter.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	ctx, span := telemetry.StartRequestSpan(request.Context(), "events", sensor.String(), group.String())
+	defer span.End()
 
-	openingToken, err := decoder.Token()
+	body, err := decodeContentEncoding(request, eventsAPI.maxRequestBodySize)
 	if err != nil {
-		log.Info().Err(err).Msg("Could not parse request body as json")
+		log.Info().Err(err).Msg("Could not decode request body Content-Encoding")
 		responseWriter.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	defer body.Close()
+
+	if request.Header.Get("Content-type") == ndjsonContentType {
+		err = eventsAPI.consumeNDJSON(ctx, body, sensor, group)
+		if err != nil {
+			writeConsumeError(responseWriter, err)
+			return
+		}
+		return
+	}
 
-	delimiter, ok := openingToken.(json.Delim)
-	if !ok || delimiter != '[' {
-		log.Info().Msg("Request body is not a JSON array")
+	// The request body is expected to be either a (potentially large) JSON array of events,
+	// or a DSSE SignedEnvelope wrapping such an array for batches that need integrity/non-repudiation.
+	// Different event types can be mixed in the array.
+	bodyContents, err := ioutil.ReadAll(body)
+	if err != nil {
+		log.Info().Err(err).Msg("Could not read request body")
 		responseWriter.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	var parsedEvents []events.Event
 
-	for decoder.More() {
+	if bytes.HasPrefix(bytes.TrimSpace(bodyContents), []byte("{")) {
+		// A signed envelope is rejected in its entirety on any failure
+		envelopeEvents, err := events.ParseEnvelope(bodyContents, sensor, group, eventsAPI.signingKeys)
+		if err != nil {
+			log.Info().Err(err).Msg("Could not parse or verify signed event envelope")
+			responseWriter.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		parsedEvents = append(parsedEvents, envelopeEvents...)
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(bodyContents))
 
-		var eventData json.RawMessage
-		err := decoder.Decode(&eventData)
+		openingToken, err := decoder.Token()
 		if err != nil {
-			log.Info().Err(err).Msg("Could not parse request body JSON entry")
+			log.Info().Err(err).Msg("Could not parse request body as json")
 			responseWriter.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		event, err := events.ParseEvent(eventData, sensor, group)
+		delimiter, ok := openingToken.(json.Delim)
+		if !ok || delimiter != '[' {
+			log.Info().Msg("Request body is not a JSON array")
+			responseWriter.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		for decoder.More() {
+
+			var eventData json.RawMessage
+			err := decoder.Decode(&eventData)
+			if err != nil {
+				log.Info().Err(err).Msg("Could not parse request body JSON entry")
+				responseWriter.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			event, err := events.ParseEvent(eventData, sensor, group)
+			if err != nil {
+				// Event parsing errors are isolated to a single event
+				// Just skip over it and log that this happened
+				// For example, this could happen if the sensor sends an event type the server doesn't know about
+				log.Info().Err(err).Msg("Skipping event due to failed parsing")
+				telemetry.RecordEventsDropped(ctx, 1, "parse_failure")
+				continue
+			}
+
+			parsedEvents = append(parsedEvents, event)
+		}
+	}
+
+	telemetry.RecordEventsReceived(ctx, int64(len(parsedEvents)), sensor.String(), group.String())
+
+	err = eventsAPI.eventProcessor.Consume(ctx, parsedEvents)
+	if err != nil {
+		writeConsumeError(responseWriter, err)
+		return
+	}
+
+	return
+}
+
+// writeConsumeError maps a processing.EventProcessor.Consume error to a response. A sink under
+// backpressure, or one that has exhausted its overflow capacity entirely, gets a 503 with a
+// Retry-After header, so sensors back off instead of assuming their events were accepted when
+// they were actually dropped; any other failure is a 500.
+func writeConsumeError(responseWriter http.ResponseWriter, err error) {
+	if errors.Is(err, output.ErrBackpressure) || errors.Is(err, output.ErrQueueFull) {
+		log.Info().Err(err).Msg("Event processing backpressured")
+		responseWriter.Header().Set("Retry-After", backpressureRetryAfterSeconds)
+		responseWriter.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	log.Info().Err(err).Msg("Event processing failed")
+	responseWriter.WriteHeader(http.StatusInternalServerError)
+}
+
+// decodeContentEncoding wraps request.Body in the decompressor matching its Content-Encoding
+// header, if any, and bounds the decompressed output to maxRequestBodySize bytes (zero means
+// unbounded). Without this bound, a small, highly compressible request body could decompress to
+// an unbounded size - a decompression bomb - regardless of how small SensorAPI.MaxRequestBodySize
+// made the bytes read off the wire. The returned ReadCloser must be closed once the caller is done
+// reading it.
+func decodeContentEncoding(request *http.Request, maxRequestBodySize int64) (io.ReadCloser, error) {
+	var decoded io.ReadCloser
+
+	switch request.Header.Get("Content-Encoding") {
+	case "":
+		decoded = request.Body
+	case "gzip":
+		reader, err := gzip.NewReader(request.Body)
+		if err != nil {
+			return nil, err
+		}
+		decoded = reader
+	case "zstd":
+		decoder, err := zstd.NewReader(request.Body)
+		if err != nil {
+			return nil, fmt.Errorf("Could not create zstd decoder: %w", err)
+		}
+		decoded = decoder.IOReadCloser()
+	default:
+		return nil, fmt.Errorf("Unsupported Content-Encoding: %s", request.Header.Get("Content-Encoding"))
+	}
+
+	if maxRequestBodySize <= 0 {
+		return decoded, nil
+	}
+
+	return &limitedReadCloser{reader: io.LimitReader(decoded, maxRequestBodySize+1), closer: decoded, limit: maxRequestBodySize}, nil
+}
+
+// limitedReadCloser fails a Read once more than limit bytes have been read in total, rather than
+// silently truncating like io.LimitReader would - a truncated decompressed body would otherwise
+// be parsed as if it were the sensor's complete, valid request.
+type limitedReadCloser struct {
+	reader    io.Reader
+	closer    io.Closer
+	limit     int64
+	readSoFar int64
+}
+
+func (limited *limitedReadCloser) Read(data []byte) (int, error) {
+	n, err := limited.reader.Read(data)
+	limited.readSoFar += int64(n)
+	if limited.readSoFar > limited.limit {
+		return n, fmt.Errorf("Decompressed request body exceeds maximum size of %d bytes", limited.limit)
+	}
+	return n, err
+}
+
+func (limited *limitedReadCloser) Close() error {
+	return limited.closer.Close()
+}
+
+// consumeNDJSON parses one event per line of body and hands parsed events off to
+// eventProcessor.Consume in batches bounded by chunkSize and chunkInterval, rather than buffering
+// the whole request body - this keeps memory use bounded for large uploads from sensors on flaky
+// links.
+func (eventsAPI EventsAPI) consumeNDJSON(ctx context.Context, body io.Reader, sensor uuid.UUID, group uuid.UUID) error {
+	batch := make([]events.Event, 0, eventsAPI.chunkSize)
+	batchStart := time.Now()
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		telemetry.RecordEventsReceived(ctx, int64(len(batch)), sensor.String(), group.String())
+		err := eventsAPI.eventProcessor.Consume(ctx, batch)
+		batch = make([]events.Event, 0, eventsAPI.chunkSize)
+		batchStart = time.Now()
+		return err
+	}
+
+	var encounteredError bool
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		event, err := events.ParseEvent(json.RawMessage(line), sensor, group)
 		if err != nil {
 			// Event parsing errors are isolated to a single event
 			// Just skip over it and log that this happened
 			// For example, this could happen if the sensor sends an event type the server doesn't know about
 			log.Info().Err(err).Msg("Skipping event due to failed parsing")
+			telemetry.RecordEventsDropped(ctx, 1, "parse_failure")
 			continue
 		}
 
-		parsedEvents = append(parsedEvents, event)
+		batch = append(batch, event)
+
+		if len(batch) >= eventsAPI.chunkSize || time.Since(batchStart) >= eventsAPI.chunkInterval {
+			if err := flush(); err != nil {
+				if errors.Is(err, output.ErrBackpressure) || errors.Is(err, output.ErrQueueFull) {
+					return err
+				}
+				log.Info().Err(err).Msg("Event processing failed for NDJSON chunk")
+				encounteredError = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Could not read NDJSON request body: %w", err)
 	}
 
-	err = eventsAPI.eventProcessor.Consume(parsedEvents)
-	if err != nil {
-		log.Info().Err(err).Msg("Event processing failed")
-		responseWriter.WriteHeader(http.StatusInternalServerError)
-		return
+	if err := flush(); err != nil {
+		if errors.Is(err, output.ErrBackpressure) || errors.Is(err, output.ErrQueueFull) {
+			return err
+		}
+		log.Info().Err(err).Msg("Event processing failed for NDJSON chunk")
+		encounteredError = true
 	}
 
-	return
+	if encounteredError {
+		return fmt.Errorf("One or more NDJSON event chunks failed processing")
+	}
+
+	return nil
 }
@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// gzipRequestBody builds an http.Request whose body is content gzip-compressed, with
+// Content-Encoding set accordingly.
+func gzipRequestBody(t *testing.T, content []byte) *http.Request {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(content); err != nil {
+		t.Fatalf("Failed to compress test body: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to finalize compressed test body: %v", err)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(compressed.Bytes()))
+	request.Header.Set("Content-Encoding", "gzip")
+	return request
+}
+
+func TestDecodeContentEncodingUnbounded(t *testing.T) {
+	content := []byte("hello world")
+	request := gzipRequestBody(t, content)
+
+	body, err := decodeContentEncoding(request, 0)
+	if err != nil {
+		t.Fatalf("decodeContentEncoding failed: %v", err)
+	}
+	defer body.Close()
+
+	read, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatalf("Failed to read decoded body: %v", err)
+	}
+	if string(read) != string(content) {
+		t.Fatalf("Expected %q, got %q", content, read)
+	}
+}
+
+func TestDecodeContentEncodingWithinLimit(t *testing.T) {
+	content := []byte("hello world")
+	request := gzipRequestBody(t, content)
+
+	body, err := decodeContentEncoding(request, int64(len(content)))
+	if err != nil {
+		t.Fatalf("decodeContentEncoding failed: %v", err)
+	}
+	defer body.Close()
+
+	read, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatalf("Failed to read decoded body: %v", err)
+	}
+	if string(read) != string(content) {
+		t.Fatalf("Expected %q, got %q", content, read)
+	}
+}
+
+func TestDecodeContentEncodingRejectsDecompressionBomb(t *testing.T) {
+	// A small, highly compressible payload that decompresses to far more than the configured
+	// limit.
+	content := bytes.Repeat([]byte("a"), 1<<20)
+	request := gzipRequestBody(t, content)
+
+	body, err := decodeContentEncoding(request, 1024)
+	if err != nil {
+		t.Fatalf("decodeContentEncoding failed: %v", err)
+	}
+	defer body.Close()
+
+	_, err = ioutil.ReadAll(body)
+	if err == nil {
+		t.Fatal("Expected reading a decompressed body over the configured limit to fail")
+	}
+}
@@ -20,7 +20,10 @@
 package api
 
 import (
+	"context"
+	"crypto/ed25519"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -32,11 +35,19 @@ import (
 
 	"github.com/weaklayer/gateway/common/auth"
 	"github.com/weaklayer/gateway/server/events"
+	"github.com/weaklayer/gateway/server/output"
 	"github.com/weaklayer/gateway/server/processing"
+	"github.com/weaklayer/gateway/server/signing"
+	"github.com/weaklayer/gateway/server/telemetry"
 	"github.com/weaklayer/gateway/server/token"
 	"github.com/xeipuuv/gojsonschema"
 )
 
+// installVerifierCacheTTL bounds how long a group's Verifiers are cached in memory before
+// InstallAPI queries its VerifierStore again. This keeps a file or remote-backed store from
+// taking a lookup on every single install request while still picking up changes quickly.
+const installVerifierCacheTTL = 10 * time.Second
+
 // InstallResponse forms the response body that the sensor will receive on a successful install request
 type InstallResponse struct {
 	Token     string    `json:"token"`
@@ -45,10 +56,18 @@ type InstallResponse struct {
 	IssuedAt  int64     `json:"issuedAt"`
 }
 
-// InstallRequest is what the sensor sends in the HTTP body to request installation
+// InstallRequest is what the sensor sends in the HTTP body to request installation.
+// Exactly one of Key or IDToken should be provided, depending on which auth scheme the sensor
+// was configured with.
 type InstallRequest struct {
-	Key   auth.Key `json:"key"`
-	Label string   `json:"label"`
+	Key     auth.Key `json:"key"`
+	IDToken string   `json:"idToken"`
+	Label   string   `json:"label"`
+	// SigningPublicKey is the sensor's ed25519 public key, optionally provided so that
+	// subsequent event submissions can be sent as signed DSSE envelopes (see
+	// events.ParseEnvelope) instead of raw JSON. It is registered against the issued sensor
+	// identifier on a successful install.
+	SigningPublicKey []byte `json:"signingPublicKey,omitempty"`
 }
 
 var installRequestJSONSchema = fmt.Sprintf(`
@@ -60,7 +79,15 @@ var installRequestJSONSchema = fmt.Sprintf(`
 		"label": {
 			"type": "string"
 		},
-		"key": %s
+		"key": %s,
+		"idToken": {
+			"type": "string",
+			"description": "An OIDC ID token, provided instead of key for sensors authenticating through a configured OIDC issuer."
+		},
+		"signingPublicKey": {
+			"type": "string",
+			"description": "Base64-encoded ed25519 public key the sensor will use to sign event envelopes, if it submits events that way."
+		}
 	}
 }
 `, auth.KeyJSONSchema)
@@ -70,11 +97,26 @@ type InstallAPI struct {
 	tokenProcessor       token.Processor
 	installRequestSchema *gojsonschema.Schema
 	eventProcessor       processing.EventProcessor
-	verifiers            []auth.Verifier
+	keyAuthenticator     auth.KeyAuthenticator
+	oidcAuthenticators   []auth.OIDCAuthenticator
+	// signingKeys registers a sensor's event-envelope public key on successful install, so that
+	// sensor's signed envelopes can later be verified by events.ParseEnvelope. Shared with
+	// EventsAPI.
+	signingKeys *auth.SigningKeyRegistry
+	// gatewaySigner is optional; when non-nil, Handle signs the response body with it and returns
+	// the detached signature in the X-Gateway-Signature header.
+	gatewaySigner *signing.Signer
 }
 
-// NewInstallAPI provisions a sensor API with its required resources
-func NewInstallAPI(tokenProcessor token.Processor, verifiers []auth.Verifier) (InstallAPI, error) {
+// NewInstallAPI provisions a sensor API with its required resources.
+// oidcIssuers is optional; sensors may authenticate with an install Key regardless of whether
+// any OIDC issuers are configured. verifiers may be backed by an in-memory registry shared with
+// the enroll API, a file watched for changes, or a remote lookup service; it is wrapped in a
+// short-lived cache so Handle can query it on every request without a file read or network round
+// trip on the hot path. signingKeys should be the same registry given to EventsAPI, so that a
+// sensor's SigningPublicKey registered here is the one EventsAPI verifies signed envelopes
+// against. gatewaySigner is optional; pass nil if gateway signing is disabled.
+func NewInstallAPI(tokenProcessor token.Processor, verifiers auth.VerifierStore, oidcIssuers []auth.OIDCIssuer, signingKeys *auth.SigningKeyRegistry, gatewaySigner *signing.Signer) (InstallAPI, error) {
 	var installAPI InstallAPI
 
 	schemaLoader := gojsonschema.NewStringLoader(installRequestJSONSchema)
@@ -83,41 +125,82 @@ func NewInstallAPI(tokenProcessor token.Processor, verifiers []auth.Verifier) (I
 		return installAPI, fmt.Errorf("Failed to load install request JSON schema: %w", err)
 	}
 
+	oidcAuthenticators := make([]auth.OIDCAuthenticator, 0, len(oidcIssuers))
+	for _, oidcIssuer := range oidcIssuers {
+		oidcAuthenticator, err := auth.NewOIDCAuthenticator(context.Background(), oidcIssuer)
+		if err != nil {
+			return installAPI, fmt.Errorf("Failed to provision OIDC authenticator for issuer %s: %w", oidcIssuer.IssuerURL, err)
+		}
+		oidcAuthenticators = append(oidcAuthenticators, oidcAuthenticator)
+	}
+
 	return InstallAPI{
 		tokenProcessor:       tokenProcessor,
 		installRequestSchema: schemaVerifier,
-		verifiers:            verifiers,
+		keyAuthenticator:     auth.KeyAuthenticator{Verifiers: auth.NewCachingVerifierStore(verifiers, installVerifierCacheTTL)},
+		oidcAuthenticators:   oidcAuthenticators,
+		signingKeys:          signingKeys,
+		gatewaySigner:        gatewaySigner,
 	}, nil
 }
 
-func (installAPI InstallAPI) parseInstallRequest(data []byte) (InstallRequest, error) {
+func (installAPI InstallAPI) parseInstallRequest(ctx context.Context, data []byte) (InstallRequest, uuid.UUID, error) {
 	var installRequest InstallRequest
 
 	documentLoader := gojsonschema.NewBytesLoader(data)
 	result, err := installAPI.installRequestSchema.Validate(documentLoader)
 	if err != nil {
-		return installRequest, fmt.Errorf("Failed to validate install request against json schema: %w", err)
+		return installRequest, uuid.UUID{}, fmt.Errorf("Failed to validate install request against json schema: %w", err)
 	}
 
 	if !result.Valid() {
-		return installRequest, fmt.Errorf("Install request did not match json schema")
+		return installRequest, uuid.UUID{}, fmt.Errorf("Install request did not match json schema")
 	}
 
 	err = json.Unmarshal(data, &installRequest)
 	if err != nil {
-		return installRequest, fmt.Errorf("Failed to unmarshal install request: %w", err)
+		return installRequest, uuid.UUID{}, fmt.Errorf("Failed to unmarshal install request: %w", err)
 	}
 
-	if !installAPI.isInstallRequestValid(installRequest) {
-		return installRequest, fmt.Errorf("Install request verification unsuccessful")
+	group, err := installAPI.authenticateInstallRequest(ctx, installRequest)
+	if err != nil {
+		return installRequest, uuid.UUID{}, fmt.Errorf("Install request verification unsuccessful: %w", err)
 	}
 
-	return installRequest, nil
+	return installRequest, group, nil
+}
+
+// authenticateInstallRequest resolves an InstallRequest to the group it authenticates for,
+// trying an OIDC ID token if one was provided and otherwise falling back to the install Key
+func (installAPI InstallAPI) authenticateInstallRequest(ctx context.Context, installRequest InstallRequest) (uuid.UUID, error) {
+	if installRequest.IDToken != "" {
+		for _, oidcAuthenticator := range installAPI.oidcAuthenticators {
+			group, _, err := oidcAuthenticator.Authenticate(ctx, installRequest.IDToken)
+			if err == nil {
+				return group, nil
+			}
+		}
+
+		return uuid.UUID{}, fmt.Errorf("ID token did not verify against any configured OIDC issuer")
+	}
+
+	group, _, err := installAPI.keyAuthenticator.Authenticate(ctx, installRequest.Key)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	return group, nil
 }
 
 // Handle validates and processes install requests
 func (installAPI InstallAPI) Handle(responseWriter http.ResponseWriter, request *http.Request) {
 
+	recorder := newStatusRecorder(responseWriter)
+	responseWriter = recorder
+	defer func() {
+		telemetry.RecordResponse(request.Context(), "/install", recorder.statusCode)
+	}()
+
 	// Don't want any responses cached
 	responseWriter.Header().Add("Cache-Control", "no-store")
 	responseWriter.Header().Add("Pragma", "no-cache")
@@ -129,7 +212,7 @@ func (installAPI InstallAPI) Handle(responseWriter http.ResponseWriter, request
 		return
 	}
 
-	installRequest, err := installAPI.parseInstallRequest(bodyContents)
+	installRequest, group, err := installAPI.parseInstallRequest(request.Context(), bodyContents)
 	if err != nil {
 		log.Info().Err(err).Msg("Failed to parse install request")
 		responseWriter.WriteHeader(http.StatusBadRequest)
@@ -151,17 +234,16 @@ func (installAPI InstallAPI) Handle(responseWriter http.ResponseWriter, request
 	}
 
 	isInstallationRenewel := false
-	group := installRequest.Key.Group
 	var sensor uuid.UUID
 
 	if tokenProvided {
 		isTokenValid, claims := installAPI.tokenProcessor.VerifyToken(providedToken)
 		if isTokenValid {
-			if auth.UUIDEquals(installRequest.Key.Group, claims.Group) {
+			if auth.UUIDEquals(group, claims.Group) {
 				sensor = claims.Sensor
 				isInstallationRenewel = true
 			} else {
-				log.Info().Msgf("Token group %s differs from the install key group %s. Proceeding as new install.", claims.Group.String(), installRequest.Key.Group.String())
+				log.Info().Msgf("Token group %s differs from the install request group %s. Proceeding as new install.", claims.Group.String(), group.String())
 			}
 		} else {
 			log.Info().Msg("Received an invalid JWT for install renewel. Proceeding as new install.")
@@ -177,6 +259,15 @@ func (installAPI InstallAPI) Handle(responseWriter http.ResponseWriter, request
 		}
 	}
 
+	if len(installRequest.SigningPublicKey) > 0 {
+		if len(installRequest.SigningPublicKey) != ed25519.PublicKeySize {
+			log.Info().Msg("Install request signingPublicKey is not a valid ed25519 public key")
+			responseWriter.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		installAPI.signingKeys.RegisterKey(sensor, ed25519.PublicKey(installRequest.SigningPublicKey))
+	}
+
 	token, expiresAt, issuedAt, err := installAPI.tokenProcessor.NewToken(group, sensor)
 	if err != nil {
 		log.Warn().Err(err).Msg("Failed to create new sensor token")
@@ -195,7 +286,13 @@ func (installAPI InstallAPI) Handle(responseWriter http.ResponseWriter, request
 		},
 		Label: installRequest.Label,
 	}
-	installAPI.eventProcessor.Consume([]events.Event{installEvent})
+	if err := installAPI.eventProcessor.Consume(request.Context(), []events.Event{installEvent}); err != nil {
+		if errors.Is(err, output.ErrBackpressure) || errors.Is(err, output.ErrQueueFull) {
+			writeConsumeError(responseWriter, err)
+			return
+		}
+		log.Warn().Err(err).Msg("Failed to record install event")
+	}
 
 	response := InstallResponse{
 		Token:     token,
@@ -211,6 +308,16 @@ func (installAPI InstallAPI) Handle(responseWriter http.ResponseWriter, request
 		return
 	}
 
+	if installAPI.gatewaySigner != nil {
+		signature, err := installAPI.gatewaySigner.Sign(responseBytes)
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to sign response body")
+			responseWriter.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		responseWriter.Header().Add("X-Gateway-Signature", string(signature))
+	}
+
 	// responseWriter.Write sets Content-Length and the status to 200
 	// Therefore don't try to se the status on error
 	responseWriter.Header().Add("Content-Type", "application/json")
@@ -225,18 +332,3 @@ func (installAPI InstallAPI) Handle(responseWriter http.ResponseWriter, request
 
 	return
 }
-
-func (installAPI InstallAPI) isInstallRequestValid(installRequest InstallRequest) bool {
-
-	// TODO: put the verifiers into a map keyed by groupid and then only go through the verifies for the given group
-
-	for _, verifier := range installAPI.verifiers {
-		if auth.UUIDEquals(verifier.Group, installRequest.Key.Group) {
-			if auth.Verify(installRequest.Key, verifier) {
-				return true
-			}
-		}
-	}
-
-	return false
-}
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	jose "gopkg.in/square/go-jose.v2"
+
+	"github.com/weaklayer/gateway/common/auth"
+	"github.com/weaklayer/gateway/server/signing"
+)
+
+const jwksPath = "/v1/jwks"
+
+// JWKSAPI publishes the sensor token signer's public keys, and the gateway signing key's public
+// keys, as a single JSON Web Key Set. Consumers select the key they need by the "kid" on whatever
+// they're verifying - a sensor token or a signed event - so both purposes are safe to serve from
+// one set.
+type JWKSAPI struct {
+	tokenKeyProvider auth.PublicKeyProvider
+	gatewaySigner    *signing.Signer
+}
+
+// NewJWKSAPI creates a JWKSAPI for tokenSigner and the optional gatewaySigner. If tokenSigner
+// doesn't implement auth.PublicKeyProvider - true of the HMAC secret and PKCS#11-backed signers,
+// whose key material must stay secret - its keys are omitted. gatewaySigner may be nil if
+// gateway signing is disabled.
+func NewJWKSAPI(tokenSigner auth.TokenSigner, gatewaySigner *signing.Signer) JWKSAPI {
+	tokenKeyProvider, _ := tokenSigner.(auth.PublicKeyProvider)
+	return JWKSAPI{tokenKeyProvider: tokenKeyProvider, gatewaySigner: gatewaySigner}
+}
+
+// Handle serves GET /v1/jwks
+func (jwksAPI JWKSAPI) Handle(responseWriter http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		responseWriter.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	keySet := jose.JSONWebKeySet{}
+	if jwksAPI.tokenKeyProvider != nil {
+		keySet.Keys = append(keySet.Keys, jwksAPI.tokenKeyProvider.PublicJWKS().Keys...)
+	}
+	if jwksAPI.gatewaySigner != nil {
+		keySet.Keys = append(keySet.Keys, jwksAPI.gatewaySigner.PublicJWKS().Keys...)
+	}
+
+	responseBytes, err := json.Marshal(keySet)
+	if err != nil {
+		responseWriter.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	responseWriter.Header().Set("Content-Type", "application/json")
+	responseWriter.Write(responseBytes)
+}
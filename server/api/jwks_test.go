@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jose "gopkg.in/square/go-jose.v2"
+
+	"github.com/weaklayer/gateway/common/auth"
+)
+
+func TestJWKSAPIServesEmptyKeySetForHMACSigner(t *testing.T) {
+	jwksAPI := NewJWKSAPI(auth.NewHMACSecretsSigner([]byte("seeeeeeecret"), nil), nil)
+
+	request := httptest.NewRequest(http.MethodGet, jwksPath, nil)
+	recorder := httptest.NewRecorder()
+
+	jwksAPI.Handle(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", recorder.Code)
+	}
+
+	var keySet jose.JSONWebKeySet
+	if err := json.Unmarshal(recorder.Body.Bytes(), &keySet); err != nil {
+		t.Fatalf("Failed to unmarshal response body: %v", err)
+	}
+
+	if len(keySet.Keys) != 0 {
+		t.Fatalf("Expected an empty key set for an HMAC signer, got %d keys", len(keySet.Keys))
+	}
+}
+
+func TestJWKSAPIRejectsNonGet(t *testing.T) {
+	jwksAPI := NewJWKSAPI(auth.NewHMACSecretsSigner([]byte("seeeeeeecret"), nil), nil)
+
+	request := httptest.NewRequest(http.MethodPost, jwksPath, nil)
+	recorder := httptest.NewRecorder()
+
+	jwksAPI.Handle(recorder, request)
+
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected status 405, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/weaklayer/gateway/server/events"
+)
+
+const schemasPathPrefix = "/v1/schemas"
+
+// SchemasAPI serves the JSON schemas registered for sensor event kinds, so sensors and
+// dashboards can discover event shapes at runtime instead of hard coding them
+type SchemasAPI struct{}
+
+// Handle serves GET /v1/schemas for the list of registered kinds, and
+// GET /v1/schemas/{kind}/{version} for a specific kind's schema. version may be omitted to get
+// the latest registered version.
+func (schemasAPI SchemasAPI) Handle(responseWriter http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		responseWriter.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	pathSuffix := strings.Trim(strings.TrimPrefix(request.URL.Path, schemasPathPrefix), "/")
+	if pathSuffix == "" {
+		schemasAPI.writeJSON(responseWriter, events.ListKinds())
+		return
+	}
+
+	pathParts := strings.SplitN(pathSuffix, "/", 2)
+	kind := events.EventType(pathParts[0])
+	var version string
+	if len(pathParts) > 1 {
+		version = pathParts[1]
+	}
+
+	schemaJSON, ok := events.SchemaFor(kind, version)
+	if !ok {
+		responseWriter.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	responseWriter.Header().Set("Content-Type", "application/json")
+	responseWriter.Write(schemaJSON)
+}
+
+func (schemasAPI SchemasAPI) writeJSON(responseWriter http.ResponseWriter, value interface{}) {
+	responseBytes, err := json.Marshal(value)
+	if err != nil {
+		responseWriter.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	responseWriter.Header().Set("Content-Type", "application/json")
+	responseWriter.Write(responseBytes)
+}
@@ -21,6 +21,7 @@ package api
 
 import (
 	"net/http"
+	"strings"
 )
 
 var lincensePaths = map[string]struct{}{"": {}, "/": {}, "/index": {}, "/index.html": {}, "/index.txt": {}, "/license": {}, "/license.html": {}, "/license.txt": {}}
@@ -29,25 +30,37 @@ var lincensePaths = map[string]struct{}{"": {}, "/": {}, "/index": {}, "/index.h
 type SensorAPI struct {
 	InstallHandler InstallAPI
 	EventsHandler  EventsAPI
+	SchemasHandler SchemasAPI
+	TailHandler    TailAPI
+	EnrollHandler  EnrollAPI
+	JWKSHandler    JWKSAPI
+	// MaxRequestBodySize is the maximum number of bytes read from a POST request body. Requests
+	// exceeding it fail with an error from the request body reader. Zero means unbounded.
+	MaxRequestBodySize int64
 }
 
 func (sensorAPI SensorAPI) ServeHTTP(responseWriter http.ResponseWriter, request *http.Request) {
 
 	if request.Method == http.MethodPost {
 
-		// This api only accepts json
-		if request.Header.Get("Content-type") != "application/json" {
+		// This api only accepts json, either as a single document or as newline-delimited events
+		contentType := request.Header.Get("Content-type")
+		if contentType != "application/json" && contentType != ndjsonContentType {
 			responseWriter.WriteHeader(http.StatusUnsupportedMediaType)
 			return
 		}
 
-		// TODO: Other generic validations. E.g. request size
+		if sensorAPI.MaxRequestBodySize > 0 {
+			request.Body = http.MaxBytesReader(responseWriter, request.Body, sensorAPI.MaxRequestBodySize)
+		}
 
 		switch request.URL.Path {
 		case "/events":
 			sensorAPI.EventsHandler.Handle(responseWriter, request)
 		case "/install":
 			sensorAPI.InstallHandler.Handle(responseWriter, request)
+		case enrollDevicePath, enrollTokenPath, enrollApprovePath:
+			sensorAPI.EnrollHandler.Handle(responseWriter, request)
 		default:
 			responseWriter.WriteHeader(http.StatusNotFound)
 		}
@@ -55,6 +68,12 @@ func (sensorAPI SensorAPI) ServeHTTP(responseWriter http.ResponseWriter, request
 	} else if request.Method == http.MethodGet {
 		if _, ok := lincensePaths[request.URL.Path]; ok {
 			displayLicense(responseWriter, request)
+		} else if strings.HasPrefix(request.URL.Path, schemasPathPrefix) {
+			sensorAPI.SchemasHandler.Handle(responseWriter, request)
+		} else if request.URL.Path == tailPath {
+			sensorAPI.TailHandler.Handle(responseWriter, request)
+		} else if request.URL.Path == jwksPath {
+			sensorAPI.JWKSHandler.Handle(responseWriter, request)
 		} else {
 			responseWriter.WriteHeader(http.StatusNotFound)
 		}
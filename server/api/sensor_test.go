@@ -21,15 +21,27 @@ package api
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
 
 	"github.com/google/uuid"
 	"github.com/weaklayer/gateway/common/auth"
+	"github.com/weaklayer/gateway/server/events"
 	"github.com/weaklayer/gateway/server/processing"
+	"github.com/weaklayer/gateway/server/signing"
 	"github.com/weaklayer/gateway/server/token"
 )
 
@@ -42,7 +54,7 @@ func TestInstallAndEvents(t *testing.T) {
 	pastSecrets[1] = []byte("seeeeeeecret2")
 
 	var tokenDuration int64 = 10000
-	tokenProcessor := token.NewProcessor(tokenSecret, pastSecrets, tokenDuration)
+	tokenProcessor := token.NewProcessor(auth.NewHMACSecretsSigner(tokenSecret, pastSecrets), tokenDuration)
 
 	group, err := uuid.NewRandom()
 	if err != nil {
@@ -59,14 +71,16 @@ func TestInstallAndEvents(t *testing.T) {
 		t.Fatalf("Failed to create test Verifier: %v", err)
 	}
 
-	installAPI, err := NewInstallAPI(tokenProcessor, []auth.Verifier{verifier})
+	signingKeys := auth.NewSigningKeyRegistry()
+
+	installAPI, err := NewInstallAPI(tokenProcessor, auth.NewVerifierRegistry([]auth.Verifier{verifier}), nil, signingKeys, nil)
 	if err != nil {
 		t.Fatalf("Falied to create install API endpoint: %v", err)
 	}
 
 	eventsProcessor := processing.EventProcessor{}
 
-	eventsAPI, err := NewEventsAPI(tokenProcessor, eventsProcessor)
+	eventsAPI, err := NewEventsAPI(tokenProcessor, eventsProcessor, signingKeys, nil, 1000, time.Second, 0)
 	if err != nil {
 		t.Fatalf("Falied to create events API endpoint: %v", err)
 	}
@@ -158,3 +172,186 @@ func TestInstallAndEvents(t *testing.T) {
 		t.Fatalf("Install request failed with status code %d", responseRecorder.Code)
 	}
 }
+
+// TestInstallRegistersSigningPublicKeyForSignedEnvelope installs a sensor with a
+// signingPublicKey and confirms a signed event envelope using the matching private key is then
+// accepted by /events, i.e. that InstallAPI actually registers the key EventsAPI verifies
+// against.
+func TestInstallRegistersSigningPublicKeyForSignedEnvelope(t *testing.T) {
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate test group UUID: %v", err)
+	}
+
+	var tokenDuration int64 = 10000
+	tokenProcessor := token.NewProcessor(auth.NewHMACSecretsSigner([]byte("seeeeeeecret"), nil), tokenDuration)
+
+	key, err := auth.NewKey(group)
+	if err != nil {
+		t.Fatalf("Failed to create test Key: %v", err)
+	}
+
+	verifier, err := auth.NewVerifier(key)
+	if err != nil {
+		t.Fatalf("Failed to create test Verifier: %v", err)
+	}
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate test signing key pair: %v", err)
+	}
+
+	signingKeys := auth.NewSigningKeyRegistry()
+
+	installAPI, err := NewInstallAPI(tokenProcessor, auth.NewVerifierRegistry([]auth.Verifier{verifier}), nil, signingKeys, nil)
+	if err != nil {
+		t.Fatalf("Failed to create install API endpoint: %v", err)
+	}
+
+	eventsProcessor := processing.EventProcessor{}
+	eventsAPI, err := NewEventsAPI(tokenProcessor, eventsProcessor, signingKeys, nil, 1000, time.Second, 0)
+	if err != nil {
+		t.Fatalf("Failed to create events API endpoint: %v", err)
+	}
+
+	sensorAPI := SensorAPI{
+		EventsHandler:  eventsAPI,
+		InstallHandler: installAPI,
+	}
+	handler := http.HandlerFunc(sensorAPI.ServeHTTP)
+
+	installRequest := InstallRequest{
+		Key:              key,
+		Label:            "Test sensor!",
+		SigningPublicKey: []byte(publicKey),
+	}
+
+	bodyBytes, err := json.Marshal(installRequest)
+	if err != nil {
+		t.Fatalf("Failed to create request body: %v", err)
+	}
+
+	request, err := http.NewRequest("POST", "/install", bytes.NewReader(bodyBytes))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	request.Header.Add("Content-type", "application/json")
+
+	responseRecorder := httptest.NewRecorder()
+	handler.ServeHTTP(responseRecorder, request)
+
+	if responseRecorder.Code != http.StatusOK {
+		t.Fatalf("Install request failed with status code %d", responseRecorder.Code)
+	}
+
+	var installResponse InstallResponse
+	if err := json.Unmarshal(responseRecorder.Body.Bytes(), &installResponse); err != nil {
+		t.Fatalf("Failed to unmarshal install response: %v", err)
+	}
+
+	payload := []byte(`[{"time":1, "type":"PageLoad", "protocol": "https", "hostname": "weaklayer.com", "port": 443}]`)
+	message := []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(events.SensorEventsPayloadType), events.SensorEventsPayloadType, len(payload), payload))
+	envelope := events.SignedEnvelope{
+		PayloadType: events.SensorEventsPayloadType,
+		Payload:     payload,
+		Signatures: []events.EnvelopeSignature{
+			{KeyID: "test", Sig: ed25519.Sign(privateKey, message)},
+		},
+	}
+
+	envelopeBytes, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatalf("Failed to marshal test envelope: %v", err)
+	}
+
+	request, err = http.NewRequest("POST", "/events", bytes.NewReader(envelopeBytes))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	request.Header.Add("Content-type", "application/json")
+	request.Header.Add("Authorization", "Bearer "+installResponse.Token)
+
+	responseRecorder = httptest.NewRecorder()
+	handler.ServeHTTP(responseRecorder, request)
+
+	if responseRecorder.Code != http.StatusOK {
+		t.Fatalf("Signed envelope event submission failed with status code %d", responseRecorder.Code)
+	}
+}
+
+func TestInstallResponseSignedWhenGatewaySigningEnabled(t *testing.T) {
+	dir := t.TempDir()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal private key: %v", err)
+	}
+	privateKeyPath := filepath.Join(dir, "gateway-signing-key-private.pem")
+	if err := ioutil.WriteFile(privateKeyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600); err != nil {
+		t.Fatalf("Failed to write private key file: %v", err)
+	}
+
+	gatewaySigner, err := signing.NewSigner(signing.Config{Enabled: true, Current: signing.KeyConfig{PrivateKeyPath: privateKeyPath}})
+	if err != nil {
+		t.Fatalf("Failed to create gateway signer: %v", err)
+	}
+
+	tokenProcessor := token.NewProcessor(auth.NewHMACSecretsSigner([]byte("seeeeeeecret"), nil), 10000)
+
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to create test group UUID: %v", err)
+	}
+
+	key, err := auth.NewKey(group)
+	if err != nil {
+		t.Fatalf("Failed to create test Key: %v", err)
+	}
+
+	verifier, err := auth.NewVerifier(key)
+	if err != nil {
+		t.Fatalf("Failed to create test Verifier: %v", err)
+	}
+
+	installAPI, err := NewInstallAPI(tokenProcessor, auth.NewVerifierRegistry([]auth.Verifier{verifier}), nil, auth.NewSigningKeyRegistry(), &gatewaySigner)
+	if err != nil {
+		t.Fatalf("Failed to create install API endpoint: %v", err)
+	}
+
+	bodyBytes, err := json.Marshal(InstallRequest{Key: key, Label: "Test sensor!"})
+	if err != nil {
+		t.Fatalf("Failed to create request body: %v", err)
+	}
+
+	request, err := http.NewRequest("POST", "/install", bytes.NewReader(bodyBytes))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	request.Header.Add("Content-type", "application/json")
+
+	responseRecorder := httptest.NewRecorder()
+	installAPI.Handle(responseRecorder, request)
+
+	if responseRecorder.Code != http.StatusOK {
+		t.Fatalf("Install request failed with status code %d", responseRecorder.Code)
+	}
+
+	signature := responseRecorder.HeaderMap.Get("X-Gateway-Signature")
+	if signature == "" {
+		t.Fatal("Expected an X-Gateway-Signature response header")
+	}
+
+	object, err := jose.ParseDetached(signature, responseRecorder.Body.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to parse X-Gateway-Signature as a detached JWS: %v", err)
+	}
+
+	if err := object.DetachedVerify(responseRecorder.Body.Bytes(), publicKey); err != nil {
+		t.Fatalf("Failed to verify X-Gateway-Signature against the signing key: %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+
+	"github.com/weaklayer/gateway/server/events"
+	"github.com/weaklayer/gateway/server/output/tail"
+)
+
+const tailPath = "/v1/tail"
+
+var tailUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// TailAPI handles requests to the /v1/tail path
+// It upgrades requests to a WebSocket connection and streams matching events to operators
+type TailAPI struct {
+	hub    *tail.Hub
+	tokens map[string]struct{}
+}
+
+// NewTailAPI provisions a tail API with its required resources
+func NewTailAPI(hub *tail.Hub, tokens []string) TailAPI {
+	tokenSet := make(map[string]struct{}, len(tokens))
+	for _, token := range tokens {
+		tokenSet[token] = struct{}{}
+	}
+
+	return TailAPI{
+		hub:    hub,
+		tokens: tokenSet,
+	}
+}
+
+// authenticate checks the Authorization header against the configured operator tokens.
+// It returns the token itself since it doubles as the operator's identity for the Hub.
+func (tailAPI TailAPI) authenticate(request *http.Request) (string, bool) {
+	authHeader := request.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return "", false
+	}
+
+	operatorToken := strings.TrimPrefix(authHeader, "Bearer ")
+	if _, ok := tailAPI.tokens[operatorToken]; !ok {
+		return "", false
+	}
+
+	return operatorToken, true
+}
+
+func parseTailFilter(query url.Values) (tail.Filter, error) {
+	var filter tail.Filter
+
+	if groupString := query.Get("group"); groupString != "" {
+		group, err := uuid.Parse(groupString)
+		if err != nil {
+			return tail.Filter{}, fmt.Errorf("Invalid group query parameter: %w", err)
+		}
+		filter.Group = group
+	}
+
+	if sensorString := query.Get("sensor"); sensorString != "" {
+		sensor, err := uuid.Parse(sensorString)
+		if err != nil {
+			return tail.Filter{}, fmt.Errorf("Invalid sensor query parameter: %w", err)
+		}
+		filter.Sensor = sensor
+	}
+
+	filter.Type = events.EventType(query.Get("type"))
+
+	return filter, nil
+}
+
+// Handle authenticates the operator, upgrades the connection to a WebSocket, and streams
+// matching events until the connection closes or a newer subscription from the same operator
+// cancels this one
+func (tailAPI TailAPI) Handle(responseWriter http.ResponseWriter, request *http.Request) {
+	operator, ok := tailAPI.authenticate(request)
+	if !ok {
+		log.Info().Msg("Unauthenticated tail request")
+		responseWriter.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	filter, err := parseTailFilter(request.URL.Query())
+	if err != nil {
+		log.Info().Err(err).Msg("Invalid tail filter")
+		responseWriter.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	connection, err := tailUpgrader.Upgrade(responseWriter, request, nil)
+	if err != nil {
+		log.Info().Err(err).Msg("Failed to upgrade tail request to WebSocket")
+		return
+	}
+	defer connection.Close()
+
+	subscription := tailAPI.hub.Subscribe(operator, filter)
+	defer tailAPI.hub.Unsubscribe(subscription)
+
+	// gorilla/websocket requires a reader goroutine to notice the peer closing the connection
+	disconnected := make(chan struct{})
+	go func() {
+		defer close(disconnected)
+		for {
+			if _, _, err := connection.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case event := <-subscription.Events:
+			if err := connection.WriteJSON(event); err != nil {
+				log.Info().Err(err).Msg("Failed to write tail event to WebSocket. Closing connection.")
+				return
+			}
+		case <-subscription.Cancelled:
+			closeMessage := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "replaced by a newer subscription from the same operator")
+			connection.WriteMessage(websocket.CloseMessage, closeMessage)
+			return
+		case <-disconnected:
+			return
+		}
+	}
+}
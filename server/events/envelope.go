@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/google/uuid"
+
+	"github.com/weaklayer/gateway/common/auth"
+)
+
+// SensorEventsPayloadType identifies the payload type for the sensor-event-batch
+// DSSE envelopes that a sensor can submit in place of a raw JSON array
+const SensorEventsPayloadType = "application/vnd.weaklayer.events+json"
+
+// EnvelopeSignature is a single signature over a SignedEnvelope's payload, identified by the key used to produce it
+type EnvelopeSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   []byte `json:"sig"`
+}
+
+// SignedEnvelope is a Dead Simple Signing Envelope (DSSE) wrapping a batch of sensor events.
+// See https://github.com/secure-systems-lab/dsse for the envelope format this mirrors.
+type SignedEnvelope struct {
+	PayloadType string              `json:"payloadType"`
+	Payload     []byte              `json:"payload"`
+	Signatures  []EnvelopeSignature `json:"signatures"`
+}
+
+// preAuthenticationEncoding builds the DSSE PAE that envelope signatures are computed over:
+// PAE(type, body) = "DSSEv1" SP LEN(type) SP type SP LEN(body) SP body
+// with lengths encoded as ASCII decimal.
+func preAuthenticationEncoding(payloadType string, payload []byte) []byte {
+	header := fmt.Sprintf("DSSEv1 %s %s %s ", strconv.Itoa(len(payloadType)), payloadType, strconv.Itoa(len(payload)))
+	return append([]byte(header), payload...)
+}
+
+// ParseEnvelope verifies a DSSE-style SignedEnvelope against a public key registered for the
+// given sensor and, on success, parses the enclosed JSON array of sensor events exactly as
+// ParseEvent would for an unsigned batch. At least one signature must verify for the batch
+// to be accepted; any failure rejects the whole envelope.
+func ParseEnvelope(data json.RawMessage, sensor uuid.UUID, group uuid.UUID, keyRegistry *auth.SigningKeyRegistry) ([]Event, error) {
+	var envelope SignedEnvelope
+
+	err := json.Unmarshal(data, &envelope)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse signed envelope JSON: %w", err)
+	}
+
+	if envelope.PayloadType != SensorEventsPayloadType {
+		return nil, fmt.Errorf("Unexpected envelope payload type '%s'", envelope.PayloadType)
+	}
+
+	if len(envelope.Signatures) == 0 {
+		return nil, fmt.Errorf("Signed envelope has no signatures")
+	}
+
+	message := preAuthenticationEncoding(envelope.PayloadType, envelope.Payload)
+
+	verified := false
+	for _, signature := range envelope.Signatures {
+		if keyRegistry.Verify(sensor, message, signature.Sig) {
+			verified = true
+			break
+		}
+	}
+
+	if !verified {
+		return nil, fmt.Errorf("No envelope signature could be verified against a registered key for sensor %s", sensor.String())
+	}
+
+	var rawEvents []json.RawMessage
+	err = json.Unmarshal(envelope.Payload, &rawEvents)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse envelope payload as a JSON array of events: %w", err)
+	}
+
+	parsedEvents := make([]Event, 0, len(rawEvents))
+	for _, rawEvent := range rawEvents {
+		event, err := ParseEvent(rawEvent, sensor, group)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse event in signed envelope: %w", err)
+		}
+		parsedEvents = append(parsedEvents, event)
+	}
+
+	return parsedEvents, nil
+}
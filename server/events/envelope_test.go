@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package events
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/weaklayer/gateway/common/auth"
+)
+
+func buildSignedEnvelope(t *testing.T, privateKey ed25519.PrivateKey, keyID string, payload []byte) []byte {
+	envelope := SignedEnvelope{
+		PayloadType: SensorEventsPayloadType,
+		Payload:     payload,
+		Signatures: []EnvelopeSignature{
+			{
+				KeyID: keyID,
+				Sig:   ed25519.Sign(privateKey, preAuthenticationEncoding(SensorEventsPayloadType, payload)),
+			},
+		},
+	}
+
+	envelopeBytes, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatalf("Failed to marshal test envelope: %v", err)
+	}
+
+	return envelopeBytes
+}
+
+func TestParseEnvelopeValid(t *testing.T) {
+	sensor, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate test sensor UUID: %v", err)
+	}
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate test group UUID: %v", err)
+	}
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate test key pair: %v", err)
+	}
+
+	keyRegistry := auth.NewSigningKeyRegistry()
+	keyRegistry.RegisterKey(sensor, publicKey)
+
+	payload := []byte(`[{"type":"WindowLocation","time":45678,"protocol":"https","hostname":"weaklayer.com","port":443,"path":"","search":"","hash":"","windowReference":1}]`)
+	envelopeBytes := buildSignedEnvelope(t, privateKey, "key-1", payload)
+
+	sensorEvents, err := ParseEnvelope(envelopeBytes, sensor, group, keyRegistry)
+	if err != nil {
+		t.Fatalf("Failed to parse valid signed envelope: %v", err)
+	}
+
+	if len(sensorEvents) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(sensorEvents))
+	}
+
+	if !auth.UUIDEquals(sensorEvents[0].GetSensor(), sensor) {
+		t.Fatal("Sensor UUID on parsed event doesn't match")
+	}
+}
+
+func TestParseEnvelopeBadSignature(t *testing.T) {
+	sensor, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate test sensor UUID: %v", err)
+	}
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate test group UUID: %v", err)
+	}
+
+	publicKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate test key pair: %v", err)
+	}
+
+	_, otherPrivateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate second test key pair: %v", err)
+	}
+
+	keyRegistry := auth.NewSigningKeyRegistry()
+	keyRegistry.RegisterKey(sensor, publicKey)
+
+	payload := []byte(`[]`)
+	// Sign with a key that was never registered for this sensor
+	envelopeBytes := buildSignedEnvelope(t, otherPrivateKey, "key-1", payload)
+
+	_, err = ParseEnvelope(envelopeBytes, sensor, group, keyRegistry)
+	if err == nil {
+		t.Fatal("Expected an error when no signature verifies against the registered key")
+	}
+}
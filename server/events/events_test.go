@@ -27,7 +27,6 @@ import (
 )
 
 func TestWindowLocationEvent(t *testing.T) {
-	eventType := "WindowLocation"
 	const validWindowLocationEvent = `{
 		"type": "WindowLocation",
 		"time": 45678,
@@ -41,20 +40,51 @@ func TestWindowLocationEvent(t *testing.T) {
 	}`
 
 	event := testValidParseEvent(t, validWindowLocationEvent)
-	if event.Type != eventType {
-		t.Fatalf("Parsed WindowLocation event as %s", event.Type)
+
+	if event.GetType() != WindowLocation {
+		t.Fatalf("Parsed WindowLocation event as %s", event.GetType())
 	}
 
-	if event.Time != 45678 {
+	if event.GetTime() != 45678 {
 		t.Fatalf("Event time didn't match")
 	}
 
-	if event.Data["hostname"] != "weaklayer.com" {
+	windowLocationEvent, ok := event.(WindowLocationEvent)
+	if !ok {
+		t.Fatalf("Parsed event was not a WindowLocationEvent")
+	}
+
+	if windowLocationEvent.Hostname != "weaklayer.com" {
+		t.Fatalf("Hostname didn't match")
+	}
+}
+
+func TestUnregisteredEventType(t *testing.T) {
+	const validPageLoadEvent = `{
+		"type": "PageLoad",
+		"time": 88,
+		"protocol": "http",
+		"hostname": "weaklayer.com",
+		"port": 80
+	}`
+
+	event := testValidParseEvent(t, validPageLoadEvent)
+
+	if event.GetType() != "PageLoad" {
+		t.Fatalf("Parsed unregistered event as %s", event.GetType())
+	}
+
+	sensorEvent, ok := event.(SensorEvent)
+	if !ok {
+		t.Fatalf("Parsed unregistered event was not a plain SensorEvent")
+	}
+
+	if sensorEvent.Data["hostname"] != "weaklayer.com" {
 		t.Fatalf("Hostname didn't match")
 	}
 }
 
-func testValidParseEvent(t *testing.T, data string) SensorEvent {
+func testValidParseEvent(t *testing.T, data string) Event {
 	sensor, err := uuid.NewRandom()
 	if err != nil {
 		t.Fatalf("Failed to generate UUID: %v", err)
@@ -69,11 +99,11 @@ func testValidParseEvent(t *testing.T, data string) SensorEvent {
 		t.Fatalf("Failed to parse valid event: %v", err)
 	}
 
-	if !auth.UUIDEquals(sensor, event.Sensor) {
+	if !auth.UUIDEquals(sensor, event.GetSensor()) {
 		t.Fatalf("Sensor UUIDs don't match")
 	}
 
-	if !auth.UUIDEquals(group, event.Group) {
+	if !auth.UUIDEquals(group, event.GetGroup()) {
 		t.Fatalf("Group UUIDs don't match")
 	}
 
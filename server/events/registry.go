@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// EventType identifies what kind of event a SensorEvent is, e.g. "Window" or "WindowLocation"
+type EventType string
+
+// Unknown is the EventType given to events whose kind has no registered parser
+const Unknown EventType = "Unknown"
+
+// Event is implemented by everything ParseEvent can produce
+type Event interface {
+	GetType() EventType
+	GetTime() int64
+	GetSensor() uuid.UUID
+	GetGroup() uuid.UUID
+}
+
+// sensorEventJSONSchemaString is the JSON schema fragment common to every event kind.
+// Kind-specific schemas fold it in with allOf. See window_event.go for an example.
+var sensorEventJSONSchemaString = `
+{
+	"type": "object",
+	"required": ["type", "time"],
+	"properties": {
+		"type": {
+			"type": "string"
+		},
+		"time": {
+			"type": "integer",
+			"minimum": 1
+		}
+	}
+}
+`
+
+// eventParser parses a single JSON event, injecting the authenticated sensor and group
+type eventParser func(data json.RawMessage, sensor uuid.UUID, group uuid.UUID) (Event, error)
+
+type registryEntry struct {
+	version    string
+	parser     eventParser
+	schema     *gojsonschema.Schema
+	schemaJSON string
+}
+
+// registryMutex guards registeredEvents. Registration happens from package init functions;
+// lookups happen on every event parsed, so a RWMutex keeps the common path cheap.
+var registryMutex sync.RWMutex
+var registeredEvents = map[EventType][]registryEntry{}
+
+// Register adds a parser and JSON schema for a given event kind and version, so that packages
+// outside this module can ship additional sensor event kinds without forking it. Versions for
+// a kind should be registered oldest first: ParseEvent defaults to the most recently registered
+// version when a parsed event doesn't specify one, so that stays the latest version.
+func Register(kind EventType, version string, parser eventParser, schemaJSON string) error {
+	schemaLoader := gojsonschema.NewStringLoader(schemaJSON)
+	schema, err := gojsonschema.NewSchema(schemaLoader)
+	if err != nil {
+		return fmt.Errorf("Failed to load JSON schema for %s event version %s: %w", kind, version, err)
+	}
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	registeredEvents[kind] = append(registeredEvents[kind], registryEntry{
+		version:    version,
+		parser:     parser,
+		schema:     schema,
+		schemaJSON: schemaJSON,
+	})
+
+	return nil
+}
+
+// ListKinds returns every event kind currently registered, sorted alphabetically
+func ListKinds() []EventType {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	kinds := make([]EventType, 0, len(registeredEvents))
+	for kind := range registeredEvents {
+		kinds = append(kinds, kind)
+	}
+
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i] < kinds[j] })
+
+	return kinds
+}
+
+// lookupEntry returns the registry entry for a kind and version, defaulting to the most
+// recently registered version for that kind when version is empty
+func lookupEntry(kind EventType, version string) (registryEntry, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	entries := registeredEvents[kind]
+	if len(entries) == 0 {
+		return registryEntry{}, false
+	}
+
+	if version == "" {
+		return entries[len(entries)-1], true
+	}
+
+	for _, entry := range entries {
+		if entry.version == version {
+			return entry, true
+		}
+	}
+
+	return registryEntry{}, false
+}
+
+// SchemaFor returns the raw JSON schema registered for an event kind and version, for use by
+// the schema discovery HTTP handler. version may be empty to get the latest registered schema.
+// The bool return is false when the kind or version isn't registered.
+func SchemaFor(kind EventType, version string) ([]byte, bool) {
+	entry, ok := lookupEntry(kind, version)
+	if !ok {
+		return nil, false
+	}
+
+	return []byte(entry.schemaJSON), true
+}
+
+func schemaValidate(data json.RawMessage, schema *gojsonschema.Schema) error {
+	documentLoader := gojsonschema.NewBytesLoader(data)
+	result, err := schema.Validate(documentLoader)
+	if err != nil {
+		return fmt.Errorf("Failed to validate against json schema: %w", err)
+	}
+
+	if !result.Valid() {
+		return fmt.Errorf("Event did not match json schema: %v", result.Errors())
+	}
+
+	return nil
+}
@@ -29,36 +29,95 @@ import (
 // SensorEvent gives all the fields common to sensor events
 // Other fields fall into a map
 type SensorEvent struct {
-	Type   string                 `json:"type"`
+	Type   EventType              `json:"type"`
 	Time   int64                  `json:"time"`
 	Sensor uuid.UUID              `json:"sensor"`
 	Group  uuid.UUID              `json:"group"`
 	Data   map[string]interface{} `json:"-"`
 }
 
-// ParseEvent parses incoming events in JSON form and inject the sensor and group ids
-func ParseEvent(data json.RawMessage, sensor uuid.UUID, group uuid.UUID) (SensorEvent, error) {
-	var sensorEvent SensorEvent
+// GetType returns the event Type field
+func (sensorEvent SensorEvent) GetType() EventType {
+	return sensorEvent.Type
+}
+
+// GetTime returns the event Time field
+func (sensorEvent SensorEvent) GetTime() int64 {
+	return sensorEvent.Time
+}
 
-	err := json.Unmarshal(data, &sensorEvent)
+// GetSensor returns the event Sensor field
+func (sensorEvent SensorEvent) GetSensor() uuid.UUID {
+	return sensorEvent.Sensor
+}
+
+// GetGroup returns the event Group field
+func (sensorEvent SensorEvent) GetGroup() uuid.UUID {
+	return sensorEvent.Group
+}
+
+// eventHeader pulls out the fields ParseEvent needs to route to a registered parser, without
+// committing to any kind-specific shape yet
+type eventHeader struct {
+	Type       EventType `json:"type"`
+	Time       int64     `json:"time"`
+	APIVersion string    `json:"apiVersion"`
+}
+
+// ParseEvent parses an incoming event in JSON form, injecting the sensor and group ids, and
+// routes it to the parser registered for its type and apiVersion. apiVersion is optional and
+// defaults to the latest version registered for the type. Types with no registered parser, for
+// example ones a newer sensor knows about that this server doesn't, parse generically into a
+// SensorEvent with the Unknown type and all kind-specific fields available in Data.
+func ParseEvent(data json.RawMessage, sensor uuid.UUID, group uuid.UUID) (Event, error) {
+	var header eventHeader
+
+	err := json.Unmarshal(data, &header)
 	if err != nil {
-		return sensorEvent, fmt.Errorf("Could not parse request body JSON entry: %w", err)
+		return nil, fmt.Errorf("Could not parse request body JSON entry: %w", err)
+	}
+
+	if header.Time <= 0 {
+		return nil, fmt.Errorf("Invalid or unspecified time value in sensor event")
 	}
 
-	if sensorEvent.Time <= 0 {
-		return sensorEvent, fmt.Errorf("Invalid or unspecified time value in sensor event")
+	entry, ok := lookupEntry(header.Type, header.APIVersion)
+	if !ok {
+		return parseGenericEvent(data, header, sensor, group)
 	}
 
-	err = json.Unmarshal(data, &sensorEvent.Data)
+	err = schemaValidate(data, entry.schema)
 	if err != nil {
-		return sensorEvent, fmt.Errorf("Could not parse request body JSON entry: %w", err)
+		return nil, fmt.Errorf("Invalid %s event: %w", header.Type, err)
+	}
+
+	event, err := entry.parser(data, sensor, group)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse %s event: %w", header.Type, err)
+	}
+
+	return event, nil
+}
+
+func parseGenericEvent(data json.RawMessage, header eventHeader, sensor uuid.UUID, group uuid.UUID) (Event, error) {
+	var sensorEvent SensorEvent
+
+	err := json.Unmarshal(data, &sensorEvent.Data)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse request body JSON entry: %w", err)
 	}
 
 	delete(sensorEvent.Data, "type")
 	delete(sensorEvent.Data, "time")
 	delete(sensorEvent.Data, "sensor")
 	delete(sensorEvent.Data, "group")
+	delete(sensorEvent.Data, "apiVersion")
 
+	sensorEvent.Type = Unknown
+	if header.Type != "" {
+		sensorEvent.Type = header.Type
+	}
+	sensorEvent.Time = header.Time
 	sensorEvent.Sensor = sensor
 	sensorEvent.Group = group
 
@@ -68,6 +127,9 @@ func ParseEvent(data json.RawMessage, sensor uuid.UUID, group uuid.UUID) (Sensor
 // MarshalJSON produces the desired json serialization for sensor events
 func (sensorEvent SensorEvent) MarshalJSON() ([]byte, error) {
 	dataMap := sensorEvent.Data
+	if dataMap == nil {
+		dataMap = make(map[string]interface{})
+	}
 	dataMap["type"] = sensorEvent.Type
 	dataMap["time"] = sensorEvent.Time
 	dataMap["sensor"] = sensorEvent.Sensor
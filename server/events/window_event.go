@@ -25,7 +25,6 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
-	"github.com/xeipuuv/gojsonschema"
 )
 
 // The type field must be this event type
@@ -50,8 +49,6 @@ var windowEventJSONSchemaString = fmt.Sprintf(`{
 // Window is the event type string for Window events
 const Window EventType = "Window"
 
-var windowEventJSONSchema *gojsonschema.Schema
-
 // WindowEvent is a SensorEvent that indicates a web page was loaded on the sensor
 type WindowEvent struct {
 	SensorEvent
@@ -80,12 +77,7 @@ func (event WindowEvent) GetGroup() uuid.UUID {
 func parseWindowEvent(data json.RawMessage, sensor uuid.UUID, group uuid.UUID) (Event, error) {
 	var windowEvent WindowEvent
 
-	err := schemaValidate(data, windowEventJSONSchema)
-	if err != nil {
-		return windowEvent, fmt.Errorf("Invalid Window event: %w", err)
-	}
-
-	json.Unmarshal(data, &windowEvent)
+	err := json.Unmarshal(data, &windowEvent)
 	if err != nil {
 		return windowEvent, fmt.Errorf("Could not parse request body JSON entry into Window event: %w", err)
 	}
@@ -98,13 +90,8 @@ func parseWindowEvent(data json.RawMessage, sensor uuid.UUID, group uuid.UUID) (
 }
 
 func init() {
-	schemaLoader := gojsonschema.NewStringLoader(windowEventJSONSchemaString)
-	s, err := gojsonschema.NewSchema(schemaLoader)
+	err := Register(Window, "v0.0.1", parseWindowEvent, windowEventJSONSchemaString)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to load JSON schema for Window event type")
+		log.Fatal().Err(err).Msg("Failed to register Window event type")
 	}
-
-	windowEventJSONSchema = s
-
-	eventParserMap[Window] = parseWindowEvent
 }
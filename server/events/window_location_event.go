@@ -25,7 +25,6 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
-	"github.com/xeipuuv/gojsonschema"
 )
 
 // The type field must be this event type
@@ -88,8 +87,6 @@ var windowLocaionEventJSONSchemaString = fmt.Sprintf(`{
 // WindowLocation is the event type string for PageLoad events
 const WindowLocation EventType = "WindowLocation"
 
-var windowLocationEventJSONSchema *gojsonschema.Schema
-
 // WindowLocationEvent is a SensorEvent that indicates a web page was loaded on the sensor
 type WindowLocationEvent struct {
 	SensorEvent
@@ -125,12 +122,7 @@ func (event WindowLocationEvent) GetGroup() uuid.UUID {
 func parseWindowLocationEvent(data json.RawMessage, sensor uuid.UUID, group uuid.UUID) (Event, error) {
 	var windowLocationEvent WindowLocationEvent
 
-	err := schemaValidate(data, windowLocationEventJSONSchema)
-	if err != nil {
-		return windowLocationEvent, fmt.Errorf("Invalid WindowLocation event: %w", err)
-	}
-
-	json.Unmarshal(data, &windowLocationEvent)
+	err := json.Unmarshal(data, &windowLocationEvent)
 	if err != nil {
 		return windowLocationEvent, fmt.Errorf("Could not parse request body JSON entry into WindowLocation event: %w", err)
 	}
@@ -143,13 +135,8 @@ func parseWindowLocationEvent(data json.RawMessage, sensor uuid.UUID, group uuid
 }
 
 func init() {
-	schemaLoader := gojsonschema.NewStringLoader(windowLocaionEventJSONSchemaString)
-	s, err := gojsonschema.NewSchema(schemaLoader)
+	err := Register(WindowLocation, "v0.0.1", parseWindowLocationEvent, windowLocaionEventJSONSchemaString)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to load JSON schema for WindowLocation event type")
+		log.Fatal().Err(err).Msg("Failed to register WindowLocation event type")
 	}
-
-	windowLocationEventJSONSchema = s
-
-	eventParserMap[WindowLocation] = parseWindowLocationEvent
 }
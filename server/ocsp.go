@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ocsp"
+)
+
+// defaultOCSPTimeout bounds how long a single OCSP responder round trip is allowed to take
+const defaultOCSPTimeout = 5 * time.Second
+
+// ClientAuthConfig enables mutual TLS for sensor connections and, optionally, OCSP-based
+// revocation checking of the client certificates sensors present.
+type ClientAuthConfig struct {
+	Enabled  bool
+	CABundle string
+	OCSP     OCSPConfig
+}
+
+// OCSPConfig configures revocation checking of sensor client certificates against an OCSP responder
+type OCSPConfig struct {
+	Enabled bool
+	// ResponderOverride, if set, is used instead of the responder URL in the client certificate's AIA extension
+	ResponderOverride string
+	// Timeout bounds how long a single OCSP responder round trip is allowed to take, in microseconds
+	Timeout int64
+	// SoftFail allows handshakes to proceed when the OCSP responder cannot be reached
+	SoftFail bool
+}
+
+// applyClientAuth configures tlsConfig to require sensor client certificates signed by clientAuthConfig's
+// CA bundle, and installs an OCSP revocation check against those certificates if configured.
+func applyClientAuth(tlsConfig *tls.Config, clientAuthConfig ClientAuthConfig) error {
+	caBundleBytes, err := ioutil.ReadFile(clientAuthConfig.CABundle)
+	if err != nil {
+		return fmt.Errorf("Failed to read client CA bundle: %w", err)
+	}
+
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caBundleBytes) {
+		return fmt.Errorf("Failed to parse any certificates from client CA bundle")
+	}
+
+	tlsConfig.ClientCAs = clientCAs
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+
+	if clientAuthConfig.OCSP.Enabled {
+		verifier := newOCSPVerifier(clientAuthConfig.OCSP)
+		tlsConfig.VerifyConnection = verifier.verifyConnection
+	}
+
+	return nil
+}
+
+// ocspCacheEntry is a cached OCSP response for a single certificate serial, valid until nextUpdate
+type ocspCacheEntry struct {
+	status     int
+	nextUpdate time.Time
+}
+
+// ocspVerifier checks verified client certificate chains for revocation via OCSP, caching
+// responder answers in memory by certificate serial number until the response's NextUpdate.
+type ocspVerifier struct {
+	config OCSPConfig
+	client *http.Client
+
+	mutex sync.Mutex
+	cache map[string]ocspCacheEntry
+}
+
+func newOCSPVerifier(config OCSPConfig) *ocspVerifier {
+	timeout := time.Duration(config.Timeout) * time.Microsecond
+	if timeout <= 0 {
+		timeout = defaultOCSPTimeout
+	}
+
+	return &ocspVerifier{
+		config: config,
+		client: &http.Client{Timeout: timeout},
+		cache:  make(map[string]ocspCacheEntry),
+	}
+}
+
+// verifyConnection is installed as a tls.Config's VerifyConnection callback. It checks the leaf
+// certificate of every verified chain against its issuer's OCSP responder and rejects the
+// handshake if any of them are revoked.
+func (verifier *ocspVerifier) verifyConnection(state tls.ConnectionState) error {
+	for _, chain := range state.VerifiedChains {
+		if len(chain) < 2 {
+			// No issuer certificate to check the leaf against. This shouldn't happen for a
+			// chain that crypto/tls has already verified against our ClientCAs pool.
+			continue
+		}
+
+		leaf := chain[0]
+		issuer := chain[1]
+
+		revoked, err := verifier.isRevoked(leaf, issuer)
+		if err != nil {
+			if verifier.config.SoftFail {
+				log.Warn().Err(err).Str("serial", leaf.SerialNumber.String()).Msg("OCSP revocation check failed; allowing connection due to soft-fail")
+				continue
+			}
+			return fmt.Errorf("OCSP revocation check failed: %w", err)
+		}
+
+		if revoked {
+			return fmt.Errorf("Client certificate with serial %s is revoked", leaf.SerialNumber.String())
+		}
+	}
+
+	return nil
+}
+
+// isRevoked answers whether leaf is revoked according to issuer's OCSP responder, consulting
+// the in-memory cache before making a responder round trip.
+func (verifier *ocspVerifier) isRevoked(leaf *x509.Certificate, issuer *x509.Certificate) (bool, error) {
+	serial := leaf.SerialNumber.String()
+
+	verifier.mutex.Lock()
+	entry, cached := verifier.cache[serial]
+	verifier.mutex.Unlock()
+
+	if cached && time.Now().Before(entry.nextUpdate) {
+		return entry.status == ocsp.Revoked, nil
+	}
+
+	responderURL := verifier.config.ResponderOverride
+	if responderURL == "" {
+		if len(leaf.OCSPServer) == 0 {
+			return false, fmt.Errorf("Certificate has no OCSP responder URL in its AIA extension and none is configured")
+		}
+		responderURL = leaf.OCSPServer[0]
+	}
+
+	requestBytes, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return false, fmt.Errorf("Failed to build OCSP request: %w", err)
+	}
+
+	httpRequest, err := http.NewRequest(http.MethodPost, responderURL, bytes.NewReader(requestBytes))
+	if err != nil {
+		return false, fmt.Errorf("Failed to build OCSP responder request: %w", err)
+	}
+	httpRequest.Header.Set("Content-Type", "application/ocsp-request")
+
+	httpResponse, err := verifier.client.Do(httpRequest)
+	if err != nil {
+		return false, fmt.Errorf("Failed to contact OCSP responder %s: %w", responderURL, err)
+	}
+	defer httpResponse.Body.Close()
+
+	responseBytes, err := ioutil.ReadAll(httpResponse.Body)
+	if err != nil {
+		return false, fmt.Errorf("Failed to read OCSP responder response: %w", err)
+	}
+
+	response, err := ocsp.ParseResponse(responseBytes, issuer)
+	if err != nil {
+		return false, fmt.Errorf("Failed to parse OCSP response: %w", err)
+	}
+
+	verifier.mutex.Lock()
+	verifier.cache[serial] = ocspCacheEntry{status: response.Status, nextUpdate: response.NextUpdate}
+	verifier.mutex.Unlock()
+
+	return response.Status == ocsp.Revoked, nil
+}
@@ -0,0 +1,201 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// testOCSPCA is a self-signed CA plus a leaf certificate it issued, used to exercise
+// ocspVerifier without a real TLS handshake.
+type testOCSPCA struct {
+	caCert  *x509.Certificate
+	caKey   *rsa.PrivateKey
+	leaf    *x509.Certificate
+	leafKey *rsa.PrivateKey
+}
+
+func newTestOCSPCA(t *testing.T) testOCSPCA {
+	t.Helper()
+
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test OCSP CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+
+	caCertBytes, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+
+	caCert, err := x509.ParseCertificate(caCertBytes)
+	if err != nil {
+		t.Fatalf("Failed to parse CA certificate: %v", err)
+	}
+
+	leafKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate leaf key: %v", err)
+	}
+
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "test-sensor"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	leafCertBytes, err := x509.CreateCertificate(rand.Reader, leafTemplate, caCert, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create leaf certificate: %v", err)
+	}
+
+	leaf, err := x509.ParseCertificate(leafCertBytes)
+	if err != nil {
+		t.Fatalf("Failed to parse leaf certificate: %v", err)
+	}
+
+	return testOCSPCA{caCert: caCert, caKey: caKey, leaf: leaf, leafKey: leafKey}
+}
+
+// newStubOCSPResponder starts an httptest server that answers every OCSP request for
+// ca.leaf with status, signed by ca's CA key.
+func newStubOCSPResponder(t *testing.T, ca testOCSPCA, status int) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		responseBytes, err := ocsp.CreateResponse(ca.caCert, ca.caCert, ocsp.Response{
+			SerialNumber: ca.leaf.SerialNumber,
+			Status:       status,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}, ca.caKey)
+		if err != nil {
+			t.Fatalf("Failed to create stub OCSP response: %v", err)
+		}
+
+		writer.Header().Set("Content-Type", "application/ocsp-response")
+		writer.Write(responseBytes)
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestOCSPVerifierAllowsGoodCertificate(t *testing.T) {
+	ca := newTestOCSPCA(t)
+	responder := newStubOCSPResponder(t, ca, ocsp.Good)
+
+	verifier := newOCSPVerifier(OCSPConfig{Enabled: true, ResponderOverride: responder.URL})
+
+	state := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{ca.leaf, ca.caCert}}}
+	if err := verifier.verifyConnection(state); err != nil {
+		t.Fatalf("Expected a good certificate to be allowed, got error: %v", err)
+	}
+}
+
+func TestOCSPVerifierRejectsRevokedCertificate(t *testing.T) {
+	ca := newTestOCSPCA(t)
+	responder := newStubOCSPResponder(t, ca, ocsp.Revoked)
+
+	verifier := newOCSPVerifier(OCSPConfig{Enabled: true, ResponderOverride: responder.URL})
+
+	state := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{ca.leaf, ca.caCert}}}
+	if err := verifier.verifyConnection(state); err == nil {
+		t.Fatal("Expected a revoked certificate to be rejected")
+	}
+}
+
+func TestOCSPVerifierCachesResponseUntilNextUpdate(t *testing.T) {
+	ca := newTestOCSPCA(t)
+	requests := 0
+	responder := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requests++
+		responseBytes, err := ocsp.CreateResponse(ca.caCert, ca.caCert, ocsp.Response{
+			SerialNumber: ca.leaf.SerialNumber,
+			Status:       ocsp.Good,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}, ca.caKey)
+		if err != nil {
+			t.Fatalf("Failed to create stub OCSP response: %v", err)
+		}
+		writer.Write(responseBytes)
+	}))
+	t.Cleanup(responder.Close)
+
+	verifier := newOCSPVerifier(OCSPConfig{Enabled: true, ResponderOverride: responder.URL})
+
+	if _, err := verifier.isRevoked(ca.leaf, ca.caCert); err != nil {
+		t.Fatalf("First isRevoked call failed: %v", err)
+	}
+	if _, err := verifier.isRevoked(ca.leaf, ca.caCert); err != nil {
+		t.Fatalf("Second isRevoked call failed: %v", err)
+	}
+
+	if requests != 1 {
+		t.Fatalf("Expected the responder to be contacted once due to caching, got %d requests", requests)
+	}
+}
+
+func TestOCSPVerifierSoftFailAllowsResponderOutage(t *testing.T) {
+	ca := newTestOCSPCA(t)
+
+	verifier := newOCSPVerifier(OCSPConfig{Enabled: true, ResponderOverride: "http://127.0.0.1:0", SoftFail: true})
+
+	state := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{ca.leaf, ca.caCert}}}
+	if err := verifier.verifyConnection(state); err != nil {
+		t.Fatalf("Expected soft-fail to allow the connection on responder outage, got error: %v", err)
+	}
+}
+
+func TestOCSPVerifierHardFailRejectsResponderOutage(t *testing.T) {
+	ca := newTestOCSPCA(t)
+
+	verifier := newOCSPVerifier(OCSPConfig{Enabled: true, ResponderOverride: "http://127.0.0.1:0", SoftFail: false})
+
+	state := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{ca.leaf, ca.caCert}}}
+	if err := verifier.verifyConnection(state); err == nil {
+		t.Fatal("Expected hard-fail to reject the connection on responder outage")
+	}
+}
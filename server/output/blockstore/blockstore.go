@@ -0,0 +1,53 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package blockstore abstracts "write a named blob under a group" behind a small interface so a
+// block of finished output (a rotated event file, for example) can land on the local filesystem,
+// in memory for tests, or eventually in an object store, without its producer caring which.
+//
+// It deliberately does not replace filesystem.FilesystemOutput's own writer: that type streams
+// events into a file incrementally and depends on os.File-specific behavior (fsync, atomic
+// rename-on-rotation) that doesn't fit a simple "write this finished blob" call. BlockStore is
+// aimed at the output of that process - the finished, rotated file - which is exactly what an S3
+// or GCS upload needs. filesystem.RotationConfig.Archive selects a BlockStore to upload each
+// rotated file to, in addition to leaving it on disk; see filesystem.ArchiveConfig and
+// filesystem.finalizeRotatedFile. Only local and in-memory backends are implemented here; an
+// object-storage backend (S3, GCS, Azure Blob) is a straightforward addition behind this same
+// interface once a deployment needs one, following the same Config/Type selection pattern as the
+// other outputs.
+package blockstore
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// BlockStore persists named blocks of data scoped to a sensor group.
+type BlockStore interface {
+	// WriteBlock stores data under name within group, creating or overwriting it.
+	WriteBlock(ctx context.Context, group uuid.UUID, name string, data []byte) error
+	// ReadBlock returns the data previously stored under name within group.
+	ReadBlock(ctx context.Context, group uuid.UUID, name string) ([]byte, error)
+	// ListBlocks returns the names of every block stored within group.
+	ListBlocks(ctx context.Context, group uuid.UUID) ([]string, error)
+	// DeleteBlock removes the named block within group. It is not an error to delete a name that
+	// does not exist.
+	DeleteBlock(ctx context.Context, group uuid.UUID, name string) error
+}
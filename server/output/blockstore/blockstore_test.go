@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package blockstore
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func testBlockStore(t *testing.T, store BlockStore) {
+	ctx := context.Background()
+
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+
+	if _, err := store.ReadBlock(ctx, group, "missing"); err == nil {
+		t.Fatal("Expected an error reading a block that was never written")
+	}
+
+	if err := store.WriteBlock(ctx, group, "a", []byte("hello")); err != nil {
+		t.Fatalf("WriteBlock failed: %v", err)
+	}
+	if err := store.WriteBlock(ctx, group, "b", []byte("world")); err != nil {
+		t.Fatalf("WriteBlock failed: %v", err)
+	}
+
+	data, err := store.ReadBlock(ctx, group, "a")
+	if err != nil {
+		t.Fatalf("ReadBlock failed: %v", err)
+	}
+	if !bytes.Equal(data, []byte("hello")) {
+		t.Fatalf("Expected %q, got %q", "hello", data)
+	}
+
+	names, err := store.ListBlocks(ctx, group)
+	if err != nil {
+		t.Fatalf("ListBlocks failed: %v", err)
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Fatalf("Expected [a b], got %v", names)
+	}
+
+	if err := store.DeleteBlock(ctx, group, "a"); err != nil {
+		t.Fatalf("DeleteBlock failed: %v", err)
+	}
+
+	names, err = store.ListBlocks(ctx, group)
+	if err != nil {
+		t.Fatalf("ListBlocks failed: %v", err)
+	}
+	if len(names) != 1 || names[0] != "b" {
+		t.Fatalf("Expected [b] after delete, got %v", names)
+	}
+
+	// Deleting an already-deleted block is not an error.
+	if err := store.DeleteBlock(ctx, group, "a"); err != nil {
+		t.Fatalf("DeleteBlock of a missing block should not error, got: %v", err)
+	}
+}
+
+func TestMemoryBlockStore(t *testing.T) {
+	testBlockStore(t, NewMemoryBlockStore())
+}
+
+func TestLocalBlockStore(t *testing.T) {
+	store, err := NewLocalBlockStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalBlockStore failed: %v", err)
+	}
+
+	testBlockStore(t, store)
+}
+
+func TestLocalBlockStoreRejectsPathTraversal(t *testing.T) {
+	store, err := NewLocalBlockStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalBlockStore failed: %v", err)
+	}
+
+	ctx := context.Background()
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+
+	for _, name := range []string{"../escaped", "a/../../escaped", "/etc/passwd", ".", ".."} {
+		if err := store.WriteBlock(ctx, group, name, []byte("data")); err == nil {
+			t.Fatalf("Expected WriteBlock to reject name %q", name)
+		}
+		if _, err := store.ReadBlock(ctx, group, name); err == nil {
+			t.Fatalf("Expected ReadBlock to reject name %q", name)
+		}
+		if err := store.DeleteBlock(ctx, group, name); err == nil {
+			t.Fatalf("Expected DeleteBlock to reject name %q", name)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package blockstore
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+// LocalBlockStore stores blocks as files under <directory>/<group>/<name>.
+type LocalBlockStore struct {
+	directory string
+}
+
+// NewLocalBlockStore creates a LocalBlockStore rooted at directory, creating it if it does not
+// exist.
+func NewLocalBlockStore(directory string) (*LocalBlockStore, error) {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create directory %s for local block store: %w", directory, err)
+	}
+
+	return &LocalBlockStore{directory: directory}, nil
+}
+
+func (store *LocalBlockStore) groupDirectory(group uuid.UUID) string {
+	return filepath.Join(store.directory, group.String())
+}
+
+// sanitizeBlockName rejects any name that would not stay a single path element directly under a
+// group directory, e.g. one containing a path separator or a ".." traversal. Without this check a
+// caller-supplied name could escape the group directory, or the store's directory entirely.
+func sanitizeBlockName(name string) error {
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+		return fmt.Errorf("Invalid block name %q", name)
+	}
+
+	return nil
+}
+
+func (store *LocalBlockStore) WriteBlock(ctx context.Context, group uuid.UUID, name string, data []byte) error {
+	if err := sanitizeBlockName(name); err != nil {
+		return err
+	}
+
+	groupDirectory := store.groupDirectory(group)
+	if err := os.MkdirAll(groupDirectory, 0755); err != nil {
+		return fmt.Errorf("Failed to create directory %s for local block store: %w", groupDirectory, err)
+	}
+
+	path := filepath.Join(groupDirectory, name)
+	if err := ioutil.WriteFile(path, data, 0640); err != nil {
+		return fmt.Errorf("Failed to write block %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (store *LocalBlockStore) ReadBlock(ctx context.Context, group uuid.UUID, name string) ([]byte, error) {
+	if err := sanitizeBlockName(name); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(store.groupDirectory(group), name)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read block %s: %w", path, err)
+	}
+
+	return data, nil
+}
+
+func (store *LocalBlockStore) ListBlocks(ctx context.Context, group uuid.UUID) ([]string, error) {
+	groupDirectory := store.groupDirectory(group)
+
+	entries, err := ioutil.ReadDir(groupDirectory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Failed to list blocks in %s: %w", groupDirectory, err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+
+	return names, nil
+}
+
+func (store *LocalBlockStore) DeleteBlock(ctx context.Context, group uuid.UUID, name string) error {
+	if err := sanitizeBlockName(name); err != nil {
+		return err
+	}
+
+	path := filepath.Join(store.groupDirectory(group), name)
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Failed to delete block %s: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package blockstore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// NewMemoryBlockStore creates an in-memory BlockStore. It is meant for tests and local
+// development, not production use: nothing is persisted across a process restart.
+func NewMemoryBlockStore() *MemoryBlockStore {
+	return &MemoryBlockStore{blocks: make(map[uuid.UUID]map[string][]byte)}
+}
+
+// MemoryBlockStore is an in-memory BlockStore.
+type MemoryBlockStore struct {
+	mutex  sync.RWMutex
+	blocks map[uuid.UUID]map[string][]byte
+}
+
+func (store *MemoryBlockStore) WriteBlock(ctx context.Context, group uuid.UUID, name string, data []byte) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	groupBlocks, ok := store.blocks[group]
+	if !ok {
+		groupBlocks = make(map[string][]byte)
+		store.blocks[group] = groupBlocks
+	}
+
+	blockCopy := make([]byte, len(data))
+	copy(blockCopy, data)
+	groupBlocks[name] = blockCopy
+
+	return nil
+}
+
+func (store *MemoryBlockStore) ReadBlock(ctx context.Context, group uuid.UUID, name string) ([]byte, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	data, ok := store.blocks[group][name]
+	if !ok {
+		return nil, fmt.Errorf("No block named %s in group %s", name, group.String())
+	}
+
+	blockCopy := make([]byte, len(data))
+	copy(blockCopy, data)
+
+	return blockCopy, nil
+}
+
+func (store *MemoryBlockStore) ListBlocks(ctx context.Context, group uuid.UUID) ([]string, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	names := make([]string, 0, len(store.blocks[group]))
+	for name := range store.blocks[group] {
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+func (store *MemoryBlockStore) DeleteBlock(ctx context.Context, group uuid.UUID, name string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delete(store.blocks[group], name)
+
+	return nil
+}
@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/weaklayer/gateway/server/events"
+	"github.com/weaklayer/gateway/server/output"
+)
+
+// defaultIndexPrefix is used when Config.IndexPrefix is unset
+const defaultIndexPrefix = "weaklayer-events"
+
+// Config configures the Elasticsearch output sink
+type Config struct {
+	Enabled     bool
+	URL         string
+	IndexPrefix string
+	Username    string
+	Password    string
+	// HighWaterMark is the event batch queue depth at which Consume starts returning
+	// output.ErrBackpressure instead of accepting more batches. A value <= 0 falls back to
+	// output.DefaultHighWaterMark.
+	HighWaterMark int
+}
+
+// NewElasticsearchOutput creates an ElasticsearchOutput instance. Events are indexed through the
+// bulk API, into a daily index named "{indexPrefix}-YYYY.MM.DD" based on each event's timestamp.
+func NewElasticsearchOutput(config Config) (ElasticsearchOutput, error) {
+	if config.URL == "" {
+		return ElasticsearchOutput{}, fmt.Errorf("Must specify an Elasticsearch URL")
+	}
+
+	indexPrefix := config.IndexPrefix
+	if indexPrefix == "" {
+		indexPrefix = defaultIndexPrefix
+	}
+
+	highWaterMark := config.HighWaterMark
+	if highWaterMark <= 0 {
+		highWaterMark = output.DefaultHighWaterMark
+	}
+
+	eventBatches := make(chan []events.Event, 10000)
+
+	elasticsearchOutput := ElasticsearchOutput{
+		eventBatches:  eventBatches,
+		highWaterMark: highWaterMark,
+	}
+
+	go process(config.URL, indexPrefix, config.Username, config.Password, http.DefaultClient, eventBatches)
+
+	return elasticsearchOutput, nil
+}
+
+// ElasticsearchOutput is an event output that indexes events into Elasticsearch via the bulk API
+type ElasticsearchOutput struct {
+	eventBatches  chan<- []events.Event
+	highWaterMark int
+}
+
+// Close should only be called once after Consume is guaranteed not to be called again
+func (elasticsearchOutput ElasticsearchOutput) Close() {
+	close(elasticsearchOutput.eventBatches)
+}
+
+// Consume takes the events and writes them to a channel for processing
+func (elasticsearchOutput ElasticsearchOutput) Consume(ctx context.Context, events []events.Event) error {
+	if len(elasticsearchOutput.eventBatches) >= elasticsearchOutput.highWaterMark {
+		return output.ErrBackpressure
+	}
+
+	select {
+	case elasticsearchOutput.eventBatches <- events:
+		return nil
+	case <-ctx.Done():
+		return output.ErrBackpressure
+	default:
+		return output.ErrBackpressure
+	}
+}
+
+// indexName returns the daily index an event with the given timestamp (microseconds since the
+// epoch) should be indexed into.
+func indexName(indexPrefix string, timeMicros int64) string {
+	eventTime := time.Unix(0, timeMicros*1000).UTC()
+	return fmt.Sprintf("%s-%s", indexPrefix, eventTime.Format("2006.01.02"))
+}
+
+// bulkBody builds the newline-delimited action/source pairs the _bulk API expects: an index
+// action line naming the daily index, followed by the serialized event.
+func bulkBody(indexPrefix string, batch []events.Event) []byte {
+	var body bytes.Buffer
+
+	for _, event := range batch {
+		serializedBytes, err := json.Marshal(event)
+		if err != nil {
+			log.Info().Err(err).Msg("Failed to serialize event for Elasticsearch output. Discarding event")
+			continue
+		}
+
+		action := map[string]interface{}{
+			"index": map[string]string{"_index": indexName(indexPrefix, event.GetTime())},
+		}
+		actionBytes, err := json.Marshal(action)
+		if err != nil {
+			log.Info().Err(err).Msg("Failed to serialize bulk index action. Discarding event")
+			continue
+		}
+
+		body.Write(actionBytes)
+		body.WriteByte('\n')
+		body.Write(serializedBytes)
+		body.WriteByte('\n')
+	}
+
+	return body.Bytes()
+}
+
+func process(url string, indexPrefix string, username string, password string, client *http.Client, eventBatches <-chan []events.Event) {
+	for batch := range eventBatches {
+		body := bulkBody(indexPrefix, batch)
+		if len(body) == 0 {
+			continue
+		}
+
+		request, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(url, "/")+"/_bulk", bytes.NewReader(body))
+		if err != nil {
+			log.Info().Err(err).Msg("Failed to build Elasticsearch bulk request. Discarding batch")
+			continue
+		}
+		request.Header.Set("Content-Type", "application/x-ndjson")
+		if username != "" || password != "" {
+			request.SetBasicAuth(username, password)
+		}
+
+		response, err := client.Do(request)
+		if err != nil {
+			log.Info().Err(err).Msg("Failed to reach Elasticsearch for bulk index. Discarding batch")
+			continue
+		}
+		response.Body.Close()
+
+		if response.StatusCode >= 300 {
+			log.Info().Msgf("Elasticsearch bulk index request returned status %d. Discarding batch", response.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package elasticsearch
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/weaklayer/gateway/server/events"
+)
+
+func TestIndexName(t *testing.T) {
+	eventTime := time.Date(2026, time.March, 5, 12, 0, 0, 0, time.UTC)
+	name := indexName("weaklayer-events", eventTime.UnixNano()/1000)
+
+	if name != "weaklayer-events-2026.03.05" {
+		t.Fatalf("Unexpected index name: %s", name)
+	}
+}
+
+func TestBulkBody(t *testing.T) {
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate test group UUID: %v", err)
+	}
+	sensor, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate test sensor UUID: %v", err)
+	}
+
+	eventTime := time.Date(2026, time.March, 5, 12, 0, 0, 0, time.UTC)
+	event := events.SensorEvent{
+		Type:   "Unknown",
+		Time:   eventTime.UnixNano() / 1000,
+		Sensor: sensor,
+		Group:  group,
+		Data:   make(map[string]interface{}),
+	}
+
+	body := string(bulkBody("weaklayer-events", []events.Event{event}))
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines (action + source), got %d: %s", len(lines), body)
+	}
+
+	if !strings.Contains(lines[0], "weaklayer-events-2026.03.05") {
+		t.Fatalf("Expected index action to reference the daily index, got %s", lines[0])
+	}
+
+	if !strings.Contains(lines[1], group.String()) {
+		t.Fatalf("Expected serialized event to contain the group, got %s", lines[1])
+	}
+}
+
+func TestElasticsearchOutputSendsBulkRequest(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- r
+		bodies <- body
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	elasticsearchOutput, err := NewElasticsearchOutput(Config{URL: testServer.URL})
+	if err != nil {
+		t.Fatalf("Failed to create Elasticsearch output: %v", err)
+	}
+
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate test group UUID: %v", err)
+	}
+	sensor, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate test sensor UUID: %v", err)
+	}
+
+	event := events.SensorEvent{
+		Type:   "Unknown",
+		Time:   time.Now().UnixNano() / 1000,
+		Sensor: sensor,
+		Group:  group,
+		Data:   make(map[string]interface{}),
+	}
+
+	if err := elasticsearchOutput.Consume(context.Background(), []events.Event{event}); err != nil {
+		t.Fatalf("Consume failed: %v", err)
+	}
+
+	select {
+	case r := <-requests:
+		if r.URL.Path != "/_bulk" {
+			t.Fatalf("Expected request to /_bulk, got %s", r.URL.Path)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for bulk request")
+	}
+
+	body := <-bodies
+	if !strings.Contains(string(body), group.String()) {
+		t.Fatalf("Expected bulk body to contain the event, got %s", string(body))
+	}
+
+	elasticsearchOutput.Close()
+}
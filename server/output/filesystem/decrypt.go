@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package filesystem
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/weaklayer/gateway/common/envelope"
+)
+
+// DecryptFile reads an encrypted filesystem output file at path, unwraps its content-encryption
+// key with unwrapKey, and streams the plaintext JSON events it contains to output, one event per
+// line. path is transparently decompressed first if rotation.Compression left it gzip- or
+// zstd-compressed: compression runs after a file is finalized, so it wraps the encrypted content
+// rather than being something the caller needs to already know about or strip beforehand.
+func DecryptFile(path string, unwrapKey envelope.UnwrapKey, output io.Writer) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	reader, err := decompressingReader(file)
+	if err != nil {
+		return fmt.Errorf("Failed to read %s: %w", path, err)
+	}
+
+	headerBytes, err := readLengthPrefixedRecord(reader)
+	if err != nil {
+		return fmt.Errorf("Failed to read header from %s: %w", path, err)
+	}
+
+	var header encryptedFileHeader
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return fmt.Errorf("Failed to parse header from %s: %w", path, err)
+	}
+
+	if header.ContentEncryption != encryptedFileContentEncryption {
+		return fmt.Errorf("Unsupported content encryption algorithm '%s' in %s", header.ContentEncryption, path)
+	}
+
+	cek, err := envelope.Unwrap(header.WrappedKeys, unwrapKey)
+	if err != nil {
+		return fmt.Errorf("Failed to unwrap content encryption key for %s: %w", path, err)
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return fmt.Errorf("Failed to initialize content cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("Failed to initialize content cipher: %w", err)
+	}
+
+	for {
+		sealed, err := readLengthPrefixedRecord(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to read record from %s: %w", path, err)
+		}
+
+		if len(sealed) < gcm.NonceSize() {
+			return fmt.Errorf("Truncated record in %s", path)
+		}
+		nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+
+		plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+		if err != nil {
+			return fmt.Errorf("Failed to decrypt record in %s: %w", path, err)
+		}
+
+		if _, err := output.Write(plaintext); err != nil {
+			return fmt.Errorf("Failed to write decrypted record: %w", err)
+		}
+		if _, err := output.Write([]byte("\n")); err != nil {
+			return fmt.Errorf("Failed to write decrypted record: %w", err)
+		}
+	}
+}
+
+// readLengthPrefixedRecord reads one record written by writeLengthPrefixedRecord: a 4-byte
+// big-endian length followed by that many bytes of content. It returns io.EOF only when the file
+// ends cleanly between records.
+func readLengthPrefixedRecord(reader io.Reader) ([]byte, error) {
+	lengthPrefix := make([]byte, 4)
+	if _, err := io.ReadFull(reader, lengthPrefix); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+
+	content := make([]byte, binary.BigEndian.Uint32(lengthPrefix))
+	if _, err := io.ReadFull(reader, content); err != nil {
+		return nil, err
+	}
+
+	return content, nil
+}
+
+// decompressingReader wraps file in a gzip or zstd reader if DetectCompression recognizes its
+// leading bytes, or returns a plain buffered reader over file otherwise.
+func decompressingReader(file *os.File) (io.Reader, error) {
+	buffered := bufio.NewReader(file)
+
+	magic, err := buffered.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("Failed to inspect file for compression: %w", err)
+	}
+
+	switch DetectCompression(magic) {
+	case compressionGzip:
+		return gzip.NewReader(buffered)
+	case compressionZstd:
+		decoder, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create zstd reader: %w", err)
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return buffered, nil
+	}
+}
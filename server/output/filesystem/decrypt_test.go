@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package filesystem
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecompressingReaderPlain(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "plain")
+	content := []byte("uncompressed content")
+	if err := ioutil.WriteFile(path, content, 0640); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open test file: %v", err)
+	}
+	defer file.Close()
+
+	reader, err := decompressingReader(file)
+	if err != nil {
+		t.Fatalf("decompressingReader failed: %v", err)
+	}
+
+	read, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if string(read) != string(content) {
+		t.Fatalf("Expected %q, got %q", content, read)
+	}
+}
+
+func TestDecompressingReaderGzip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "plain")
+	content := []byte("uncompressed content")
+	if err := ioutil.WriteFile(path, content, 0640); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	compressedPath, err := compressFile(path, compressionGzip)
+	if err != nil {
+		t.Fatalf("compressFile failed: %v", err)
+	}
+
+	file, err := os.Open(compressedPath)
+	if err != nil {
+		t.Fatalf("Failed to open compressed file: %v", err)
+	}
+	defer file.Close()
+
+	reader, err := decompressingReader(file)
+	if err != nil {
+		t.Fatalf("decompressingReader failed: %v", err)
+	}
+
+	read, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if string(read) != string(content) {
+		t.Fatalf("Expected %q, got %q", content, read)
+	}
+}
+
+func TestDecompressingReaderZstd(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "plain")
+	content := []byte("uncompressed content")
+	if err := ioutil.WriteFile(path, content, 0640); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	compressedPath, err := compressFile(path, compressionZstd)
+	if err != nil {
+		t.Fatalf("compressFile failed: %v", err)
+	}
+
+	file, err := os.Open(compressedPath)
+	if err != nil {
+		t.Fatalf("Failed to open compressed file: %v", err)
+	}
+	defer file.Close()
+
+	reader, err := decompressingReader(file)
+	if err != nil {
+		t.Fatalf("decompressingReader failed: %v", err)
+	}
+
+	read, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if string(read) != string(content) {
+		t.Fatalf("Expected %q, got %q", content, read)
+	}
+}
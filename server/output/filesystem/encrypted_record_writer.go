@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package filesystem
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/weaklayer/gateway/common/envelope"
+)
+
+// encryptedFileVersion identifies the on-disk layout written by encryptedRecordWriter, so a
+// future incompatible layout change can be distinguished by the decrypt command.
+const encryptedFileVersion = 1
+
+// encryptedFileContentEncryption is the algorithm used to encrypt each record with the file's
+// content-encryption key.
+const encryptedFileContentEncryption = "A256GCM"
+
+// encryptedFileHeader is written as a length-prefixed JSON document at the start of an encrypted
+// output file. It carries everything needed to recover the content-encryption key, but none of
+// the key material itself: WrappedKeys must be unwrapped by whoever holds the matching private
+// material before the file's records can be decrypted.
+type encryptedFileHeader struct {
+	Version           int                   `json:"version"`
+	ContentEncryption string                `json:"contentEncryption"`
+	WrappedKeys       []envelope.WrappedKey `json:"wrappedKeys"`
+}
+
+// encryptedRecordWriter encrypts each event with AES-256-GCM under a fresh per-file
+// content-encryption key and a fresh per-record nonce, and writes length-prefixed records so a
+// reader doesn't need the original event boundaries to split the file back apart.
+type encryptedRecordWriter struct {
+	file *os.File
+	gcm  cipher.AEAD
+}
+
+func newEncryptedRecordWriter(file *os.File, recipients []envelope.Recipient) (*encryptedRecordWriter, error) {
+	cek := make([]byte, 32)
+	if _, err := rand.Read(cek); err != nil {
+		return nil, fmt.Errorf("Failed to generate content encryption key: %w", err)
+	}
+
+	wrappedKeys, err := envelope.Wrap(cek, recipients)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to wrap content encryption key: %w", err)
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to initialize content cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to initialize content cipher: %w", err)
+	}
+
+	headerBytes, err := json.Marshal(encryptedFileHeader{
+		Version:           encryptedFileVersion,
+		ContentEncryption: encryptedFileContentEncryption,
+		WrappedKeys:       wrappedKeys,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to serialize encrypted file header: %w", err)
+	}
+
+	if _, err := writeLengthPrefixedRecord(file, headerBytes); err != nil {
+		return nil, fmt.Errorf("Failed to write encrypted file header to %s: %w", file.Name(), err)
+	}
+
+	return &encryptedRecordWriter{file: file, gcm: gcm}, nil
+}
+
+func (writer *encryptedRecordWriter) WriteRecord(content []byte) (int, error) {
+	nonce := make([]byte, writer.gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return 0, fmt.Errorf("Failed to generate record nonce: %w", err)
+	}
+
+	sealed := writer.gcm.Seal(nonce, nonce, content, nil)
+	return writeLengthPrefixedRecord(writer.file, sealed)
+}
+
+func (writer *encryptedRecordWriter) Close() (int, error) {
+	return 0, nil
+}
+
+// writeLengthPrefixedRecord writes content to file preceded by its length as a 4-byte big-endian
+// unsigned integer, so a reader can split the file back into records without needing delimiters
+// that might appear in ciphertext.
+func writeLengthPrefixedRecord(file *os.File, content []byte) (int, error) {
+	lengthPrefix := make([]byte, 4)
+	binary.BigEndian.PutUint32(lengthPrefix, uint32(len(content)))
+
+	n, err := writeToFile(file, lengthPrefix)
+	if err != nil {
+		return n, err
+	}
+
+	m, err := writeToFile(file, content)
+	return n + m, err
+}
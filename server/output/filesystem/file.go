@@ -20,30 +20,51 @@
 package filesystem
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
-	"strconv"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
+	"github.com/weaklayer/gateway/common/envelope"
+	"github.com/weaklayer/gateway/server/output/blockstore"
+	"github.com/weaklayer/gateway/server/telemetry"
 )
 
-func newFile(groupDirectory string, maxFileSize int) (file, error) {
+// partitionLayout is the time.Format layout used to lay rotated files out under
+// <groupDirectory>/YYYY/MM/DD/HH, so downstream batch consumers (Spark, Athena, and the like) see
+// a bounded, partitioned set of files rather than one ever-growing file per group.
+const partitionLayout = "2006/01/02/15"
 
-	filename := strconv.FormatInt(time.Now().UnixNano()/1000, 10) + ".json"
+func newFile(groupDirectory string, maxFileSize int, recipients []envelope.Recipient, format string, fsync bool, rotation RotationConfig, shardLabel string, dirMode os.FileMode, archiveStore blockstore.BlockStore, group uuid.UUID) (file, error) {
+
+	now := time.Now()
+	partitionDirectory := filepath.Join(groupDirectory, now.Format(partitionLayout))
+	if err := os.MkdirAll(partitionDirectory, dirMode); err != nil {
+		return file{}, fmt.Errorf("Failed to create partition directory %s: %w", partitionDirectory, err)
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return file{}, fmt.Errorf("Failed to generate file identifier: %w", err)
+	}
+
+	filename := fmt.Sprintf("events-%d-%s%s", now.UnixNano()/1000, id.String(), fileExtension(format))
 
 	// files being written to are 'dot' files
-	inProgressPath := filepath.Join(groupDirectory, "."+filename)
-	finalPath := filepath.Join(groupDirectory, filename)
+	inProgressPath := filepath.Join(partitionDirectory, "."+filename)
+	finalPath := filepath.Join(partitionDirectory, filename)
 	fileInstance, err := os.OpenFile(inProgressPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
 	if err != nil {
 		return file{}, fmt.Errorf("Failed to open file %s: %w", inProgressPath, err)
 	}
-	_, err = writeToFile(fileInstance, []byte("[\n"))
+
+	writer, err := newRecordWriter(fileInstance, recipients, format)
 	if err != nil {
-		return file{}, fmt.Errorf("Failed to initialize %s with JSON array opening: %w", fileInstance.Name(), err)
+		return file{}, fmt.Errorf("Failed to initialize %s for writing: %w", fileInstance.Name(), err)
 	}
 
 	content := make(chan []byte)
@@ -55,11 +76,20 @@ func newFile(groupDirectory string, maxFileSize int) (file, error) {
 		closeGuard:  &sync.Once{},
 	}
 
-	go process(fileInstance, finalPath, maxFileSize, content, done)
+	go process(fileInstance, finalPath, groupDirectory, maxFileSize, writer, content, done, fsync, rotation, shardLabel, archiveStore, group)
 
 	return fileOutput, nil
 }
 
+// fileExtension returns the filename extension matching an output format
+func fileExtension(format string) string {
+	if format == formatParquet {
+		return ".parquet"
+	}
+
+	return ".json"
+}
+
 type file struct {
 	content     chan<- []byte
 	doneChannel <-chan struct{}
@@ -120,7 +150,7 @@ func (file file) Write(data []byte) bool {
 	}
 }
 
-func process(file *os.File, finalPath string, maxFileSize int, content <-chan []byte, doneChannel chan<- struct{}) {
+func process(file *os.File, finalPath string, groupDirectory string, maxFileSize int, writer recordWriter, content <-chan []byte, doneChannel chan<- struct{}, fsync bool, rotation RotationConfig, shardLabel string, archiveStore blockstore.BlockStore, group uuid.UUID) {
 	// Closure to indicate we are done
 	done := false
 	sayDone := func() {
@@ -131,18 +161,17 @@ func process(file *os.File, finalPath string, maxFileSize int, content <-chan []
 	}
 
 	var totalBytesWritten int = 0
-	write := func(content []byte) error {
-		n, err := writeToFile(file, content)
-		totalBytesWritten = totalBytesWritten + n
-		return err
-	}
 
 	closeFile := func() {
 		sayDone()
 
-		err := write([]byte("\n]"))
+		_, err := writer.Close()
 		if err != nil {
-			log.Warn().Err(err).Msgf("Failed to write JSON array closure to %s", file.Name())
+			log.Warn().Err(err).Msgf("Failed to write closing bytes to %s", file.Name())
+		}
+
+		if err := file.Sync(); err != nil {
+			log.Warn().Err(err).Msgf("Failed to fsync %s before finalizing", file.Name())
 		}
 
 		err = file.Close()
@@ -150,33 +179,39 @@ func process(file *os.File, finalPath string, maxFileSize int, content <-chan []
 			log.Warn().Err(err).Msgf("Failed to properly close file %v", file.Name())
 		}
 
+		// The file is written under a "." prefix and only renamed into finalPath, without that
+		// prefix, once the flush+fsync above has completed. A downstream consumer watching
+		// groupDirectory therefore never observes a partially-written file: it either isn't there
+		// yet, or it is complete.
 		err = os.Rename(file.Name(), finalPath)
 		if err != nil {
 			log.Warn().Err(err).Msgf("Failed to rename %s to %s", file.Name(), finalPath)
+			return
+		}
+
+		if rotation.Compression != "" || rotation.ContentAddressedNaming || rotation.Archive.Type != "" || rotation.Retention.MaxBytes > 0 || rotation.Retention.MaxAge > 0 {
+			go finalizeRotatedFile(finalPath, groupDirectory, rotation, archiveStore, group)
 		}
 	}
 
 	defer closeFile()
 
-	isFirstEvent := true
 	for eventContent := range content {
-		// Do one event per line. Append a comma and newline to previous event.
-		if !isFirstEvent {
-			err := write([]byte(",\n"))
-			if err != nil {
-				log.Warn().Err(err).Msgf("Error in filesystem output writing to %s", file.Name())
-				return
-			}
-		}
-		isFirstEvent = false
-
-		// Write the event
-		err := write(eventContent)
+		writeStart := time.Now()
+		n, err := writer.WriteRecord(eventContent)
+		telemetry.RecordShardWriteLatency(context.Background(), "filesystem", shardLabel, time.Since(writeStart).Microseconds())
+		totalBytesWritten += n
 		if err != nil {
 			log.Warn().Err(err).Msgf("Error in filesystem output writing to %s", file.Name())
 			return
 		}
 
+		if fsync {
+			if err := file.Sync(); err != nil {
+				log.Warn().Err(err).Msgf("Failed to fsync %s", file.Name())
+			}
+		}
+
 		if totalBytesWritten >= maxFileSize {
 			sayDone()
 		}
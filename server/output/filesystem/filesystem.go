@@ -20,105 +20,267 @@
 package filesystem
 
 import (
+	"context"
 	"fmt"
+	"hash/fnv"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/weaklayer/gateway/common/envelope"
 	"github.com/weaklayer/gateway/server/events"
+	"github.com/weaklayer/gateway/server/output/blockstore"
+	"github.com/weaklayer/gateway/server/signing"
 )
 
-// NewFilesystemOutput creates a FilesystemOutput instance
-func NewFilesystemOutput(directory string) (FilesystemOutput, error) {
+// shardKey identifies one metaFile's writer shard: events are sharded by (group, sensor) rather
+// than by group alone, so one sensor's writes never queue behind another sensor's in the same
+// group.
+type shardKey struct {
+	group  uuid.UUID
+	sensor uuid.UUID
+}
+
+// shardLabelBuckets bounds the cardinality of the "shard" attribute label() produces. Gateways can
+// see an unbounded number of distinct (group, sensor) pairs over their uptime, and Prometheus (and
+// most other metrics backends) assumes label cardinality stays bounded, so the raw identifiers
+// cannot be used directly as a label value.
+const shardLabelBuckets = 32
+
+// label formats key for use as a metrics attribute value. It hashes (group, sensor) into one of a
+// fixed number of buckets rather than using the raw identifiers, so the label's cardinality stays
+// bounded regardless of how many distinct sensors a gateway has seen.
+func (key shardKey) label() string {
+	hash := fnv.New32a()
+	hash.Write(key.group[:])
+	hash.Write(key.sensor[:])
+	return fmt.Sprintf("shard-%d", hash.Sum32()%shardLabelBuckets)
+}
+
+// shard lazily creates its metaFile the first time it is needed. Creation runs inside once, not
+// under any lock shared with other shards, so one shard's directory/file creation never blocks
+// another's. lastActive is updated, in unix nanoseconds, on every access so the idle-shard reaper
+// can evict shards that have gone quiet. reapGuard is held for read by every Consume call and for
+// write by the reaper before it closes metaFile, so a shard is never closed while a Consume call
+// is still writing to it.
+type shard struct {
+	once       sync.Once
+	metaFile   metaFile
+	err        error
+	lastActive int64
+	reapGuard  sync.RWMutex
+}
+
+const (
+	// formatJSON writes events as a JSON array, one event per line. This is the default and matches
+	// the format the filesystem output has always used.
+	formatJSON = "json"
+	// formatParquet writes events as Snappy-compressed Parquet rows, for archives meant to be
+	// queried directly by analytics tools instead of parsed as JSON. It cannot be combined with
+	// encryption.
+	formatParquet = "parquet"
+)
+
+// NewFilesystemOutput creates a FilesystemOutput instance. If recipients is non-empty, event
+// files are encrypted at rest: see encryptedRecordWriter. format selects the on-disk file format
+// and is one of formatJSON (the default, if empty) or formatParquet. maxFileAge and maxFileSize
+// bound how long, and how large, a single output file grows before it is rotated. highWaterMark
+// is the per-group queue depth at which Consume starts returning output.ErrBackpressure; a value
+// <= 0 falls back to output.DefaultHighWaterMark. signer is optional; when non-nil, every event is
+// archived as a signing.Envelope carrying a detached signature rather than as raw event JSON.
+// fsync, when true, fsyncs after every write for durability at the cost of throughput. rotation
+// configures what happens to a file once it is rotated out of: compression, archival to a
+// blockstore.BlockStore (see rotation.Archive), and retention pruning. dirMode is the permission
+// mode group and shard subdirectories are created with; a value of 0 falls back to 0755.
+func NewFilesystemOutput(directory string, recipients []envelope.Recipient, format string, maxFileAge time.Duration, maxFileSize int, highWaterMark int, signer *signing.Signer, fsync bool, rotation RotationConfig, dirMode os.FileMode) (FilesystemOutput, error) {
+	if dirMode == 0 {
+		dirMode = defaultDirMode
+	}
 
 	// Attempt to create the directory if it does not exist.
-	err := createDirectory(directory)
+	err := createDirectory(directory, dirMode)
 	if err != nil {
 		return FilesystemOutput{}, fmt.Errorf("Failed to create directory %s for filesystem output: %w", directory, err)
 	}
 
+	archiveStore, err := newArchiveStore(rotation.Archive)
+	if err != nil {
+		return FilesystemOutput{}, fmt.Errorf("Failed to create archive store for filesystem output: %w", err)
+	}
+
 	filesystemOutput := FilesystemOutput{
-		directory:             directory,
-		metaFiles:             make(map[uuid.UUID]metaFile),
-		metaFileCreationMutex: &sync.Mutex{},
+		directory:     directory,
+		recipients:    recipients,
+		format:        format,
+		maxFileAge:    maxFileAge,
+		maxFileSize:   maxFileSize,
+		highWaterMark: highWaterMark,
+		signer:        signer,
+		fsync:         fsync,
+		rotation:      rotation,
+		dirMode:       dirMode,
+		archiveStore:  archiveStore,
+		shards:        &sync.Map{},
+		stopReaper:    make(chan struct{}),
 	}
 
+	go filesystemOutput.reapIdleShards()
+
 	return filesystemOutput, nil
 }
 
 // FilesystemOutput is an event output that writes events to the filesystem
 type FilesystemOutput struct {
-	directory             string
-	metaFiles             map[uuid.UUID]metaFile
-	metaFileCreationMutex *sync.Mutex
+	directory     string
+	recipients    []envelope.Recipient
+	format        string
+	maxFileAge    time.Duration
+	maxFileSize   int
+	highWaterMark int
+	signer        *signing.Signer
+	fsync         bool
+	rotation      RotationConfig
+	dirMode       os.FileMode
+	// archiveStore is the BlockStore rotation.Archive configures rotated files to be uploaded to,
+	// or nil if archiving is disabled.
+	archiveStore blockstore.BlockStore
+	// shards maps shardKey to *shard. Events are sharded by (group, sensor) so that one sensor's
+	// directory/file creation, and its writer goroutine, never blocks another's.
+	shards *sync.Map
+	// stopReaper signals reapIdleShards to stop. Closed by Close.
+	stopReaper chan struct{}
 }
 
 // Close closes are underlying file descriptors for the FilesystemOutput
 // Close should only be called once after Consume is guaranteed not to be called again
 func (filesystemOutput FilesystemOutput) Close() {
+	close(filesystemOutput.stopReaper)
 
-	metaFiles := filesystemOutput.metaFiles
-	filesystemOutput.metaFiles = make(map[uuid.UUID]metaFile)
-
-	for _, metaFile := range metaFiles {
-		metaFile.Close()
-	}
+	filesystemOutput.shards.Range(func(_ interface{}, value interface{}) bool {
+		shardInstance := value.(*shard)
+		if shardInstance.err == nil {
+			shardInstance.metaFile.Close()
+		}
+		return true
+	})
 }
 
 // Consume takes the events and writes them to a channel for processing
-func (filesystemOutput FilesystemOutput) Consume(events []events.Event) error {
+func (filesystemOutput FilesystemOutput) Consume(ctx context.Context, events []events.Event) error {
 
 	// All events in a single call will have the same group and sensor
-	group := events[0].GetGroup()
+	key := shardKey{group: events[0].GetGroup(), sensor: events[0].GetSensor()}
+
+	value, _ := filesystemOutput.shards.LoadOrStore(key, &shard{})
+	shardInstance := value.(*shard)
+
+	// Held for the duration of the Consume call below, so reapIdleShards cannot close this shard's
+	// metaFile out from under an in-flight write. reapIdleShards takes the write lock before
+	// closing, so it blocks until every in-flight Consume for this shard has returned.
+	shardInstance.reapGuard.RLock()
+	defer shardInstance.reapGuard.RUnlock()
 
-	metaFile, err := filesystemOutput.getGroupMetaFile(group)
+	metaFile, err := filesystemOutput.getShardMetaFile(key, shardInstance)
 	if err != nil {
 		return fmt.Errorf("Failed to write event to filesystem: %w", err)
 	}
 
-	return metaFile.Consume(events)
+	return metaFile.Consume(ctx, events)
 }
 
-func (filesystemOutput FilesystemOutput) getGroupMetaFile(group uuid.UUID) (metaFile, error) {
-	var metaFileInstance metaFile
-	var ok bool
-	if metaFileInstance, ok = filesystemOutput.metaFiles[group]; !ok {
-		return filesystemOutput.createAndStoreGroupMetaFile(group)
-	}
+// getShardMetaFile returns shardInstance's metaFile, creating it on the first call for key.
+// Creation runs inside the shard's own sync.Once rather than under a lock shared with other
+// shards, so a slow directory/file creation for one shard never serializes with another's. The
+// caller must hold shardInstance.reapGuard for read.
+func (filesystemOutput FilesystemOutput) getShardMetaFile(key shardKey, shardInstance *shard) (metaFile, error) {
+	atomic.StoreInt64(&shardInstance.lastActive, time.Now().UnixNano())
+
+	shardInstance.once.Do(func() {
+		shardDirectoryPath := filepath.Join(filesystemOutput.directory, key.group.String(), key.sensor.String())
+		if err := createDirectory(shardDirectoryPath, filesystemOutput.dirMode); err != nil {
+			shardInstance.err = fmt.Errorf("Failed to create directory %s for filesystem output: %w", shardDirectoryPath, err)
+			return
+		}
 
-	return metaFileInstance, nil
+		metaFileInstance, err := newMetaFile(shardDirectoryPath, filesystemOutput.maxFileAge, filesystemOutput.maxFileSize, filesystemOutput.recipients, filesystemOutput.format, filesystemOutput.highWaterMark, filesystemOutput.signer, filesystemOutput.fsync, filesystemOutput.rotation, key.label(), filesystemOutput.dirMode, filesystemOutput.archiveStore, key.group)
+		if err != nil {
+			shardInstance.err = fmt.Errorf("Failed to create file for writing: %w", err)
+			return
+		}
+
+		shardInstance.metaFile = metaFileInstance
+	})
+
+	return shardInstance.metaFile, shardInstance.err
 }
 
-func (filesystemOutput FilesystemOutput) createAndStoreGroupMetaFile(group uuid.UUID) (metaFile, error) {
-	filesystemOutput.metaFileCreationMutex.Lock()
-	defer filesystemOutput.metaFileCreationMutex.Unlock()
+// shardIdleTimeout is how long a shard can go without a Consume call before reapIdleShards closes
+// it and evicts it from shards. Without this, a shard's writer goroutine, open file handle, and
+// WAL stay alive for as long as the gateway runs, even for a sensor that stopped sending events
+// long ago, so the number of live shards would otherwise grow without bound over a gateway's
+// uptime as it sees new sensors.
+const shardIdleTimeout = 10 * time.Minute
 
-	var metaFileInstance metaFile
-	var ok bool
-	if metaFileInstance, ok = filesystemOutput.metaFiles[group]; !ok {
+// shardReapInterval is how often reapIdleShards scans shards for idle ones to evict.
+const shardReapInterval = 1 * time.Minute
 
-		metaFileDirectoryPath := filepath.Join(filesystemOutput.directory, group.String())
-		err := createDirectory(metaFileDirectoryPath)
-		if err != nil {
-			return metaFileInstance, fmt.Errorf("Failed to create directory %s for filesystem output: %w", metaFileDirectoryPath, err)
+// reapIdleShards periodically evicts shards that have gone longer than shardIdleTimeout without a
+// Consume call, closing their metaFile first. A shard evicted this way is transparently recreated
+// by getShardMetaFile the next time its key is seen again. Runs until stopReaper is closed.
+func (filesystemOutput FilesystemOutput) reapIdleShards() {
+	ticker := time.NewTicker(shardReapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			filesystemOutput.reapShardsIdleSince(time.Now().Add(-shardIdleTimeout))
+		case <-filesystemOutput.stopReaper:
+			return
 		}
+	}
+}
 
-		metaFileInstance, err = newMetaFile(metaFileDirectoryPath)
-		if err != nil {
-			return metaFileInstance, fmt.Errorf("Failed to create file for writing: %w", err)
+// reapShardsIdleSince evicts every shard whose lastActive is older than cutoff, closing its
+// metaFile first. Split out from reapIdleShards so tests can trigger a reap pass without waiting
+// on shardIdleTimeout/shardReapInterval.
+func (filesystemOutput FilesystemOutput) reapShardsIdleSince(cutoff time.Time) {
+	cutoffNanos := cutoff.UnixNano()
+
+	filesystemOutput.shards.Range(func(key interface{}, value interface{}) bool {
+		shardInstance := value.(*shard)
+		if shardInstance.err != nil || atomic.LoadInt64(&shardInstance.lastActive) >= cutoffNanos {
+			return true
 		}
 
-		filesystemOutput.metaFiles[group] = metaFileInstance
-	}
+		// Blocks until any Consume call already in flight for this shard has returned, so the
+		// metaFile below is never closed out from under a write.
+		shardInstance.reapGuard.Lock()
+		defer shardInstance.reapGuard.Unlock()
 
-	return metaFileInstance, nil
+		// Re-check under the write lock: a Consume call may have refreshed lastActive while this
+		// goroutine waited for the lock.
+		if atomic.LoadInt64(&shardInstance.lastActive) >= cutoffNanos {
+			return true
+		}
+
+		filesystemOutput.shards.Delete(key)
+		shardInstance.metaFile.Close()
+		return true
+	})
 }
 
-func createDirectory(path string) error {
-	_, err := os.Stat(path)
-	if os.IsNotExist(err) {
-		err = os.Mkdir(path, 0755)
-	}
-	return err
+// defaultDirMode is used when NewFilesystemOutput is not given an explicit directory permission
+// mode.
+const defaultDirMode = os.FileMode(0755)
+
+// createDirectory creates path, and any missing parents, with the given permission mode if it
+// does not already exist. MkdirAll is used instead of a Stat-then-Mkdir check since multiple
+// shards can race to create the same group directory concurrently, and MkdirAll is safe to call
+// when the directory already exists.
+func createDirectory(path string, mode os.FileMode) error {
+	return os.MkdirAll(path, mode)
 }
@@ -20,15 +20,19 @@
 package filesystem
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/weaklayer/gateway/server/events"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
 )
 
 func TestWritingEvents(t *testing.T) {
@@ -41,7 +45,8 @@ func TestWritingEvents(t *testing.T) {
 		t.Fatalf("Failed to generate UUID: %v", err)
 	}
 
-	filesystemOutput, err := NewFilesystemOutput(".", 60*time.Second, 100000000)
+	baseDir := t.TempDir()
+	filesystemOutput, err := NewFilesystemOutput(baseDir, nil, "", 60*time.Second, 100000000, 0, nil, false, RotationConfig{}, 0)
 
 	event1 := events.SensorEvent{
 		Type:   "Unknown",
@@ -59,7 +64,7 @@ func TestWritingEvents(t *testing.T) {
 		Data:   make(map[string]interface{}),
 	}
 
-	err = filesystemOutput.Consume([]events.SensorEvent{event1, event2})
+	err = filesystemOutput.Consume(context.Background(), []events.Event{event1, event2})
 	if err != nil {
 		t.Fatalf("Failed to write events to filesystem: %v", err)
 	}
@@ -68,19 +73,10 @@ func TestWritingEvents(t *testing.T) {
 	// wait for the file to close
 	time.Sleep(1 * time.Second)
 
-	dirPath := "./" + group.String()
-	dir, err := os.Open(dirPath)
-	if err != nil {
-		t.Fatalf("Failed to open directory for reading: %v", err)
-	}
-	files, err := dir.Readdir(0)
-	if err != nil {
-		t.Fatalf("Failed to open directory for reading: %v", err)
-	}
+	dirPath := filepath.Join(baseDir, group.String())
+	paths := eventFilePaths(t, dirPath)
 
-	for _, f := range files {
-		fileName := f.Name()
-		path := filepath.Join(dirPath, fileName)
+	for _, path := range paths {
 		fileBytes, err := ioutil.ReadFile(path)
 		if err != nil {
 			t.Fatalf("Failed to read file: %v", err)
@@ -101,7 +97,317 @@ func TestWritingEvents(t *testing.T) {
 				t.Fatalf("Event identifiers do not match")
 			}
 		}
-		os.Remove(path)
 	}
-	os.Remove(dirPath)
+}
+
+func TestWritingEventsPartitionsByHour(t *testing.T) {
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+	sensor, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+
+	baseDir := t.TempDir()
+	filesystemOutput, err := NewFilesystemOutput(baseDir, nil, "", 60*time.Second, 100000000, 0, nil, false, RotationConfig{}, 0)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem output: %v", err)
+	}
+
+	event := events.SensorEvent{
+		Type:   "Unknown",
+		Time:   1,
+		Sensor: sensor,
+		Group:  group,
+		Data:   make(map[string]interface{}),
+	}
+
+	if err := filesystemOutput.Consume(context.Background(), []events.Event{event}); err != nil {
+		t.Fatalf("Failed to write events to filesystem: %v", err)
+	}
+
+	filesystemOutput.Close()
+	time.Sleep(1 * time.Second)
+
+	dirPath := filepath.Join(baseDir, group.String())
+	paths := eventFilePaths(t, dirPath)
+
+	if len(paths) != 1 {
+		t.Fatalf("Expected exactly 1 file, found %d", len(paths))
+	}
+
+	expectedPartition := filepath.Join(dirPath, sensor.String(), time.Now().Format(partitionLayout))
+	if filepath.Dir(paths[0]) != expectedPartition {
+		t.Fatalf("Expected file under partition %s, found %s", expectedPartition, paths[0])
+	}
+	if !strings.HasPrefix(filepath.Base(paths[0]), "events-") {
+		t.Fatalf("Expected filename to start with events-, found %s", filepath.Base(paths[0]))
+	}
+}
+
+func TestWritingEventsArchivesRotatedFiles(t *testing.T) {
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+	sensor, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+
+	baseDir := t.TempDir()
+	rotation := RotationConfig{Archive: ArchiveConfig{Type: "memory"}}
+	filesystemOutput, err := NewFilesystemOutput(baseDir, nil, "", 60*time.Second, 100000000, 0, nil, false, rotation, 0)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem output: %v", err)
+	}
+
+	event := events.SensorEvent{
+		Type:   "Unknown",
+		Time:   1,
+		Sensor: sensor,
+		Group:  group,
+		Data:   make(map[string]interface{}),
+	}
+
+	if err := filesystemOutput.Consume(context.Background(), []events.Event{event}); err != nil {
+		t.Fatalf("Failed to write events to filesystem: %v", err)
+	}
+
+	filesystemOutput.Close()
+	time.Sleep(1 * time.Second)
+
+	names, err := filesystemOutput.archiveStore.ListBlocks(context.Background(), group)
+	if err != nil {
+		t.Fatalf("ListBlocks failed: %v", err)
+	}
+	if len(names) != 1 {
+		t.Fatalf("Expected exactly 1 archived block, found %d", len(names))
+	}
+
+	data, err := filesystemOutput.archiveStore.ReadBlock(context.Background(), group, names[0])
+	if err != nil {
+		t.Fatalf("ReadBlock failed: %v", err)
+	}
+
+	var archivedEvents []events.SensorEvent
+	if err := json.Unmarshal(data, &archivedEvents); err != nil {
+		t.Fatalf("Failed to deserialize archived block: %v", err)
+	}
+	if len(archivedEvents) != 1 {
+		t.Fatalf("Wrong number of events found in archived block")
+	}
+}
+
+func TestReapIdleShardsEvictsAndRecreates(t *testing.T) {
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+	sensor, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+
+	baseDir := t.TempDir()
+	filesystemOutput, err := NewFilesystemOutput(baseDir, nil, "", 60*time.Second, 100000000, 0, nil, false, RotationConfig{}, 0)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem output: %v", err)
+	}
+
+	event := events.SensorEvent{
+		Type:   "Unknown",
+		Time:   1,
+		Sensor: sensor,
+		Group:  group,
+		Data:   make(map[string]interface{}),
+	}
+
+	if err := filesystemOutput.Consume(context.Background(), []events.Event{event}); err != nil {
+		t.Fatalf("Failed to write events to filesystem: %v", err)
+	}
+
+	key := shardKey{group: group, sensor: sensor}
+	if _, ok := filesystemOutput.shards.Load(key); !ok {
+		t.Fatal("Expected a shard to exist after Consume")
+	}
+
+	// A cutoff in the future makes every shard look idle, regardless of shardIdleTimeout.
+	filesystemOutput.reapShardsIdleSince(time.Now().Add(time.Second))
+
+	if _, ok := filesystemOutput.shards.Load(key); ok {
+		t.Fatal("Expected the idle shard to be evicted")
+	}
+
+	if err := filesystemOutput.Consume(context.Background(), []events.Event{event}); err != nil {
+		t.Fatalf("Failed to write events to filesystem after reap: %v", err)
+	}
+
+	if _, ok := filesystemOutput.shards.Load(key); !ok {
+		t.Fatal("Expected a fresh shard to be recreated after reap")
+	}
+
+	filesystemOutput.Close()
+	// wait for both the reaped and the recreated shard's files to close
+	time.Sleep(1 * time.Second)
+}
+
+func TestWritingEventsShardsBySensor(t *testing.T) {
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+	sensor1, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+	sensor2, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+
+	baseDir := t.TempDir()
+	filesystemOutput, err := NewFilesystemOutput(baseDir, nil, "", 60*time.Second, 100000000, 0, nil, false, RotationConfig{}, 0)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem output: %v", err)
+	}
+
+	for _, sensor := range []uuid.UUID{sensor1, sensor2} {
+		event := events.SensorEvent{
+			Type:   "Unknown",
+			Time:   1,
+			Sensor: sensor,
+			Group:  group,
+			Data:   make(map[string]interface{}),
+		}
+		if err := filesystemOutput.Consume(context.Background(), []events.Event{event}); err != nil {
+			t.Fatalf("Failed to write events to filesystem: %v", err)
+		}
+	}
+
+	filesystemOutput.Close()
+	time.Sleep(1 * time.Second)
+
+	dirPath := filepath.Join(baseDir, group.String())
+	paths := eventFilePaths(t, dirPath)
+
+	if len(paths) != 2 {
+		t.Fatalf("Expected exactly 2 files, one per sensor shard, found %d", len(paths))
+	}
+
+	for _, sensor := range []uuid.UUID{sensor1, sensor2} {
+		shardDirectory := filepath.Join(dirPath, sensor.String())
+		found := false
+		for _, path := range paths {
+			if strings.HasPrefix(path, shardDirectory+string(filepath.Separator)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("Expected a file under sensor shard directory %s", shardDirectory)
+		}
+	}
+}
+
+// eventFilePaths walks dirPath, which is laid out as <group>/YYYY/MM/DD/HH/<file> since file
+// rotation partitions by time, and returns the paths of every rotated event file found.
+func eventFilePaths(t *testing.T, dirPath string) []string {
+	var paths []string
+	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to walk directory %s: %v", dirPath, err)
+	}
+	return paths
+}
+
+func TestWritingParquetEvents(t *testing.T) {
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+	sensor, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+
+	baseDir := t.TempDir()
+	filesystemOutput, err := NewFilesystemOutput(baseDir, nil, formatParquet, 60*time.Second, 100000000, 0, nil, false, RotationConfig{}, 0)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem output: %v", err)
+	}
+
+	event1 := events.SensorEvent{
+		Type:   "Unknown",
+		Time:   1,
+		Sensor: sensor,
+		Group:  group,
+		Data:   make(map[string]interface{}),
+	}
+
+	event2 := events.SensorEvent{
+		Type:   "Unknown",
+		Time:   1,
+		Sensor: sensor,
+		Group:  group,
+		Data:   make(map[string]interface{}),
+	}
+
+	err = filesystemOutput.Consume(context.Background(), []events.Event{event1, event2})
+	if err != nil {
+		t.Fatalf("Failed to write events to filesystem: %v", err)
+	}
+
+	filesystemOutput.Close()
+	// wait for the file to close
+	time.Sleep(1 * time.Second)
+
+	dirPath := filepath.Join(baseDir, group.String())
+	paths := eventFilePaths(t, dirPath)
+
+	for _, path := range paths {
+		if filepath.Ext(path) != ".parquet" {
+			t.Fatalf("Expected a .parquet file, found %s", path)
+		}
+
+		fileReader, err := local.NewLocalFileReader(path)
+		if err != nil {
+			t.Fatalf("Failed to open Parquet file for reading: %v", err)
+		}
+
+		parquetReader, err := reader.NewParquetReader(fileReader, new(parquetRow), 1)
+		if err != nil {
+			t.Fatalf("Failed to create Parquet reader: %v", err)
+		}
+
+		numRows := int(parquetReader.GetNumRows())
+		if numRows != 2 {
+			t.Fatalf("Wrong number of rows found in file")
+		}
+
+		rows := make([]parquetRow, numRows)
+		if err := parquetReader.Read(&rows); err != nil {
+			t.Fatalf("Failed to read Parquet rows: %v", err)
+		}
+
+		for _, row := range rows {
+			if group.String() != row.Group || sensor.String() != row.Sensor {
+				t.Fatalf("Event identifiers do not match")
+			}
+		}
+
+		parquetReader.ReadStop()
+		fileReader.Close()
+	}
 }
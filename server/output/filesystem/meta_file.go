@@ -20,27 +20,48 @@
 package filesystem
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
+	"github.com/weaklayer/gateway/common/envelope"
 	"github.com/weaklayer/gateway/server/events"
+	"github.com/weaklayer/gateway/server/output"
+	"github.com/weaklayer/gateway/server/output/blockstore"
+	"github.com/weaklayer/gateway/server/signing"
+	"github.com/weaklayer/gateway/server/telemetry"
 )
 
-func newMetaFile(groupDirectory string, maxFileAge time.Duration, maxFileSize int) (metaFile, error) {
-	newFile, err := newFile(groupDirectory, maxFileSize)
+func newMetaFile(groupDirectory string, maxFileAge time.Duration, maxFileSize int, recipients []envelope.Recipient, format string, highWaterMark int, signer *signing.Signer, fsync bool, rotation RotationConfig, shardLabel string, dirMode os.FileMode, archiveStore blockstore.BlockStore, group uuid.UUID) (metaFile, error) {
+	if highWaterMark <= 0 {
+		highWaterMark = output.DefaultHighWaterMark
+	}
+
+	newFile, err := newFile(groupDirectory, maxFileSize, recipients, format, fsync, rotation, shardLabel, dirMode, archiveStore, group)
 	if err != nil {
 		return metaFile{}, fmt.Errorf("Failed to create first file in directory %s: %w", groupDirectory, err)
 	}
 
+	groupWAL, err := newWAL(groupDirectory)
+	if err != nil {
+		return metaFile{}, fmt.Errorf("Failed to open overflow WAL in directory %s: %w", groupDirectory, err)
+	}
+
 	eventData := make(chan []byte, 10000)
 
-	go metaProcess(groupDirectory, maxFileAge, maxFileSize, newFile, eventData)
+	go metaProcess(groupDirectory, maxFileAge, maxFileSize, recipients, format, newFile, eventData, fsync, rotation, groupWAL, shardLabel, dirMode, archiveStore, group)
 
 	return metaFile{
 		groupDirectory: groupDirectory,
 		eventData:      eventData,
+		highWaterMark:  highWaterMark,
+		signer:         signer,
+		wal:            groupWAL,
+		shardLabel:     shardLabel,
 	}, nil
 }
 
@@ -48,6 +69,15 @@ func newMetaFile(groupDirectory string, maxFileAge time.Duration, maxFileSize in
 type metaFile struct {
 	groupDirectory string
 	eventData      chan<- []byte
+	highWaterMark  int
+	// signer is the gateway signing key events are archived under, or nil if gateway signing is
+	// disabled, in which case events are written unsigned as before.
+	signer *signing.Signer
+	// wal is the on-disk overflow segment Consume spills events to once eventData is completely
+	// full, rather than dropping them.
+	wal *wal
+	// shardLabel identifies this metaFile's (group, sensor) shard for per-shard telemetry.
+	shardLabel string
 }
 
 // Close should only be called once after Consume is guaranteed not to be called again
@@ -55,7 +85,7 @@ func (metaFile metaFile) Close() {
 	close(metaFile.eventData)
 }
 
-func (metaFile metaFile) Consume(events []events.Event) error {
+func (metaFile metaFile) Consume(ctx context.Context, events []events.Event) error {
 
 	var encounteredError = false
 
@@ -67,12 +97,37 @@ func (metaFile metaFile) Consume(events []events.Event) error {
 			continue
 		}
 
+		if metaFile.signer != nil {
+			serializedBytes, err = metaFile.signer.SignEnvelope(serializedBytes)
+			if err != nil {
+				encounteredError = true
+				log.Info().Err(err).Msg("Failed to sign event. Discarding Event")
+				continue
+			}
+		}
+
+		if len(metaFile.eventData) >= metaFile.highWaterMark {
+			return output.ErrBackpressure
+		}
+
 		select {
 		case metaFile.eventData <- serializedBytes:
+			telemetry.RecordQueueDepth(ctx, "filesystem.eventData", len(metaFile.eventData))
+			telemetry.RecordShardQueueDepth(ctx, "filesystem", metaFile.shardLabel, len(metaFile.eventData))
+		case <-ctx.Done():
+			return output.ErrBackpressure
 		default:
-			encounteredError = true
-			log.Info().Msgf("Event queue for directory %s full. Discarding Event", metaFile.groupDirectory)
-			continue
+			spilled, err := metaFile.wal.Append(serializedBytes)
+			if err != nil {
+				log.Warn().Err(err).Msg("Failed to spill overflow event to WAL")
+			}
+			if spilled {
+				telemetry.RecordEventsSpilled(ctx, 1, "filesystem")
+				continue
+			}
+			telemetry.RecordEventsDropped(ctx, 1, "queue_full")
+			telemetry.RecordShardEventsDropped(ctx, "filesystem", metaFile.shardLabel, 1)
+			return output.ErrQueueFull
 		}
 	}
 
@@ -83,14 +138,18 @@ func (metaFile metaFile) Consume(events []events.Event) error {
 	return nil
 }
 
-func metaProcess(groupDirectory string, maxFileAge time.Duration, maxFileSize int, initialFile file, contentChannel <-chan []byte) {
+// walDrainInterval is how often metaProcess checks the group's overflow WAL for spilled events to
+// write out, on top of draining it opportunistically whenever a file rotation happens.
+const walDrainInterval = 1 * time.Second
+
+func metaProcess(groupDirectory string, maxFileAge time.Duration, maxFileSize int, recipients []envelope.Recipient, format string, initialFile file, contentChannel <-chan []byte, fsync bool, rotation RotationConfig, groupWAL *wal, shardLabel string, dirMode os.FileMode, archiveStore blockstore.BlockStore, group uuid.UUID) {
 	writingFile := initialFile
 	fileTimer := time.NewTimer(maxFileAge)
 
 	rotateFile := func() error {
 		fileTimer = time.NewTimer(maxFileAge)
 
-		newFile, err := newFile(groupDirectory, maxFileSize)
+		newFile, err := newFile(groupDirectory, maxFileSize, recipients, format, fsync, rotation, shardLabel, dirMode, archiveStore, group)
 		if err != nil {
 			return err
 		}
@@ -100,9 +159,44 @@ func metaProcess(groupDirectory string, maxFileAge time.Duration, maxFileSize in
 		writingFile = newFile
 		oldFile.Close()
 
+		telemetry.RecordOutputFileRotation(context.Background(), "filesystem")
+
 		return nil
 	}
 
+	writeContent := func(content []byte) {
+		if writingFile.Write(content) {
+			telemetry.RecordOutputBytesWritten(context.Background(), int64(len(content)), "filesystem")
+			return
+		}
+
+		if err := rotateFile(); err != nil {
+			log.Info().Err(err).Msg("File rotation failed. Discarding event")
+			return
+		}
+
+		if writingFile.Write(content) {
+			telemetry.RecordOutputBytesWritten(context.Background(), int64(len(content)), "filesystem")
+		} else {
+			log.Info().Msg("Writing to file failed after file rotation. Discarding event")
+		}
+	}
+
+	drainWAL := func() {
+		records, err := groupWAL.Drain()
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to drain overflow WAL")
+			return
+		}
+
+		for _, record := range records {
+			writeContent(record)
+		}
+	}
+
+	walTicker := time.NewTicker(walDrainInterval)
+	defer walTicker.Stop()
+
 readLoop:
 	for {
 		select {
@@ -112,26 +206,15 @@ readLoop:
 				break readLoop
 			}
 
-			contentWritten := writingFile.Write(eventContent)
-			if contentWritten {
-				continue
-			}
-
-			err := rotateFile()
-			if err != nil {
-				log.Info().Err(err).Msg("File rotation failed. Discarding event")
-				continue
-			}
-
-			contentWritten = writingFile.Write(eventContent)
-			if !contentWritten {
-				log.Info().Msg("Writing to file failed after file rotation. Discarding event")
-			}
+			writeContent(eventContent)
 		case <-fileTimer.C:
 			err := rotateFile()
 			if err != nil {
 				log.Info().Err(err).Msg("File rotation on timer failed.")
 			}
+			drainWAL()
+		case <-walTicker.C:
+			drainWAL()
 		}
 	}
 
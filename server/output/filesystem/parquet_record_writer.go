@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package filesystem
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetRow is the on-disk row shape for Parquet filesystem output. Kind-specific fields stay
+// JSON-encoded in Data rather than being flattened into columns, since events carry an open-ended
+// set of fields per type.
+type parquetRow struct {
+	Type   string `parquet:"name=type, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Time   int64  `parquet:"name=time, type=INT64"`
+	Sensor string `parquet:"name=sensor, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Group  string `parquet:"name=group, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Data   string `parquet:"name=data, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+// parquetRecordWriter writes events as Snappy-compressed Parquet rows instead of the plain JSON
+// array format, for archives meant to be queried directly by analytics tools. Rows are buffered
+// in memory by the underlying parquet-go writer and flushed as row groups on Close.
+type parquetRecordWriter struct {
+	parquetWriter *writer.ParquetWriter
+}
+
+func newParquetRecordWriter(file *os.File) (*parquetRecordWriter, error) {
+	parquetWriter, err := writer.NewParquetWriterFromWriter(file, new(parquetRow), 1)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to initialize Parquet writer for %s: %w", file.Name(), err)
+	}
+
+	return &parquetRecordWriter{parquetWriter: parquetWriter}, nil
+}
+
+func (parquetRecordWriter *parquetRecordWriter) WriteRecord(content []byte) (int, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return 0, fmt.Errorf("Failed to parse event for Parquet row: %w", err)
+	}
+
+	row := parquetRow{
+		Type:   fmt.Sprintf("%v", fields["type"]),
+		Sensor: fmt.Sprintf("%v", fields["sensor"]),
+		Group:  fmt.Sprintf("%v", fields["group"]),
+	}
+	if eventTime, ok := fields["time"].(float64); ok {
+		row.Time = int64(eventTime)
+	}
+
+	delete(fields, "type")
+	delete(fields, "time")
+	delete(fields, "sensor")
+	delete(fields, "group")
+
+	dataBytes, err := json.Marshal(fields)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to serialize remaining event fields for Parquet row: %w", err)
+	}
+	row.Data = string(dataBytes)
+
+	if err := parquetRecordWriter.parquetWriter.Write(row); err != nil {
+		return 0, fmt.Errorf("Failed to write Parquet row: %w", err)
+	}
+
+	return len(content), nil
+}
+
+func (parquetRecordWriter *parquetRecordWriter) Close() (int, error) {
+	if err := parquetRecordWriter.parquetWriter.WriteStop(); err != nil {
+		return 0, fmt.Errorf("Failed to finalize Parquet file: %w", err)
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package filesystem
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/weaklayer/gateway/common/envelope"
+)
+
+// recordWriter writes a stream of serialized events to an open file, handling whatever on-disk
+// framing the output format requires.
+type recordWriter interface {
+	// WriteRecord writes a single serialized event and returns the number of bytes written to disk.
+	WriteRecord(content []byte) (int, error)
+	// Close writes any trailing bytes the framing requires and returns the number of bytes written.
+	Close() (int, error)
+}
+
+// newRecordWriter picks the on-disk framing for a file: encrypted length-prefixed records when
+// recipients are configured, Parquet rows when format is formatParquet, or a plain JSON array
+// otherwise.
+func newRecordWriter(file *os.File, recipients []envelope.Recipient, format string) (recordWriter, error) {
+	if len(recipients) > 0 {
+		if format == formatParquet {
+			return nil, fmt.Errorf("The %s filesystem output format does not support encryption", formatParquet)
+		}
+
+		return newEncryptedRecordWriter(file, recipients)
+	}
+
+	switch format {
+	case "", formatJSON:
+		return newPlainRecordWriter(file)
+	case formatParquet:
+		return newParquetRecordWriter(file)
+	default:
+		return nil, fmt.Errorf("Unknown filesystem output format %s", format)
+	}
+}
+
+// plainRecordWriter writes events as a JSON array, one event per line, matching the format the
+// filesystem output has always used.
+type plainRecordWriter struct {
+	file         *os.File
+	isFirstEvent bool
+}
+
+func newPlainRecordWriter(file *os.File) (*plainRecordWriter, error) {
+	_, err := writeToFile(file, []byte("[\n"))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to initialize %s with JSON array opening: %w", file.Name(), err)
+	}
+
+	return &plainRecordWriter{file: file, isFirstEvent: true}, nil
+}
+
+func (writer *plainRecordWriter) WriteRecord(content []byte) (int, error) {
+	total := 0
+
+	if !writer.isFirstEvent {
+		n, err := writeToFile(writer.file, []byte(",\n"))
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	writer.isFirstEvent = false
+
+	n, err := writeToFile(writer.file, content)
+	return total + n, err
+}
+
+func (writer *plainRecordWriter) Close() (int, error) {
+	return writeToFile(writer.file, []byte("\n]"))
+}
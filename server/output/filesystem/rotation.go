@@ -0,0 +1,321 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package filesystem
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+	"github.com/rs/zerolog/log"
+	"github.com/weaklayer/gateway/server/output/blockstore"
+)
+
+const (
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+)
+
+// RetentionConfig bounds how much of a group's rotated output is kept on disk. Pruning runs
+// after every file rotation and only ever considers files already rotated out of, i.e. not the
+// file currently being written to.
+type RetentionConfig struct {
+	// MaxBytes is the maximum total size, in bytes, of rotated files kept in a group directory.
+	// The oldest files are deleted first. A value <= 0 disables size-based pruning.
+	MaxBytes int64
+	// MaxAge is the maximum age, in microseconds, a rotated file is kept on disk before being
+	// deleted. A value <= 0 disables age-based pruning.
+	MaxAge int64
+}
+
+// ArchiveConfig configures uploading a rotated file's finished content to a blockstore.BlockStore,
+// in addition to leaving it on disk. This is the integration point for shipping finalized output
+// off-box (e.g. to an object store) without changing how FilesystemOutput itself writes files.
+type ArchiveConfig struct {
+	// Type selects the BlockStore backend: "local", "memory", or "" (the default) to disable
+	// archiving.
+	Type string
+	// Directory is the root directory blocks are stored under, for Type "local".
+	Directory string
+}
+
+// RotationConfig configures what happens to a file once it is rotated out of: optional
+// compression, optional archival to a BlockStore, and optional retention pruning of the group
+// directory it lived in.
+type RotationConfig struct {
+	// Compression is the codec rotated files are compressed with: "gzip", "zstd", or "" (the
+	// default) for no compression.
+	Compression string
+	Retention   RetentionConfig
+	// ContentAddressedNaming renames a file to events-<sha256[:16]><extension> once it has been
+	// finalized (and compressed, if configured), deriving the name from the file's own content.
+	// This gives downstream consumers, e.g. an object store uploader, a stable, idempotent name to
+	// dedup against.
+	ContentAddressedNaming bool
+	// Archive configures uploading the finalized file to a BlockStore, scoped by the group it
+	// belongs to, in addition to leaving it on disk.
+	Archive ArchiveConfig
+}
+
+// newArchiveStore constructs the BlockStore archive.Type selects, or returns nil if archiving is
+// disabled (archive.Type is empty).
+func newArchiveStore(archive ArchiveConfig) (blockstore.BlockStore, error) {
+	switch archive.Type {
+	case "":
+		return nil, nil
+	case "local":
+		store, err := blockstore.NewLocalBlockStore(archive.Directory)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create local block store archive: %w", err)
+		}
+		return store, nil
+	case "memory":
+		return blockstore.NewMemoryBlockStore(), nil
+	default:
+		return nil, fmt.Errorf(`Unknown archive type "%s"`, archive.Type)
+	}
+}
+
+// finalizeRotatedFile compresses path according to rotation.Compression, if configured, archives
+// it to archiveStore under group, if configured, and then prunes groupDirectory down to
+// rotation.Retention's limits. It is meant to run in its own goroutine since none of these steps
+// need to block the writer that rotated the file.
+func finalizeRotatedFile(path string, groupDirectory string, rotation RotationConfig, archiveStore blockstore.BlockStore, group uuid.UUID) {
+	if rotation.Compression != "" {
+		compressedPath, err := compressFile(path, rotation.Compression)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed to compress rotated file %s", path)
+		} else {
+			path = compressedPath
+		}
+	}
+
+	if rotation.ContentAddressedNaming {
+		addressedPath, err := renameContentAddressed(path)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed to apply content-addressed naming to %s", path)
+		} else {
+			path = addressedPath
+		}
+	}
+
+	if archiveStore != nil {
+		if err := archiveRotatedFile(archiveStore, group, path); err != nil {
+			log.Warn().Err(err).Msgf("Failed to archive rotated file %s", path)
+		}
+	}
+
+	if rotation.Retention.MaxBytes > 0 || rotation.Retention.MaxAge > 0 {
+		if err := pruneRetention(groupDirectory, rotation.Retention); err != nil {
+			log.Warn().Err(err).Msgf("Failed to prune retention for %s", groupDirectory)
+		}
+	}
+}
+
+// archiveRotatedFile reads the finalized file at path and writes it to archiveStore under group,
+// named by its own filename so the stored name matches whatever compression or content-addressed
+// renaming finalizeRotatedFile already applied.
+func archiveRotatedFile(archiveStore blockstore.BlockStore, group uuid.UUID, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Failed to read %s for archiving: %w", path, err)
+	}
+
+	if err := archiveStore.WriteBlock(context.Background(), group, filepath.Base(path), data); err != nil {
+		return fmt.Errorf("Failed to archive %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// compressFile compresses the file at path with the given codec, writing path+extension and
+// removing path on success. It returns the path of the compressed file.
+func compressFile(path string, compression string) (string, error) {
+	var extension string
+	switch compression {
+	case compressionGzip:
+		extension = ".gz"
+	case compressionZstd:
+		extension = ".zst"
+	default:
+		return "", fmt.Errorf(`Unsupported compression codec "%s"`, compression)
+	}
+
+	source, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to open %s for compression: %w", path, err)
+	}
+	defer source.Close()
+
+	compressedPath := path + extension
+	destination, err := os.OpenFile(compressedPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return "", fmt.Errorf("Failed to create %s: %w", compressedPath, err)
+	}
+	defer destination.Close()
+
+	var writer io.WriteCloser
+	switch compression {
+	case compressionGzip:
+		writer = gzip.NewWriter(destination)
+	case compressionZstd:
+		writer, err = zstd.NewWriter(destination)
+		if err != nil {
+			return "", fmt.Errorf("Failed to create zstd writer for %s: %w", compressedPath, err)
+		}
+	}
+
+	if _, err := io.Copy(writer, source); err != nil {
+		return "", fmt.Errorf("Failed to compress %s: %w", path, err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("Failed to finalize compressed file %s: %w", compressedPath, err)
+	}
+
+	source.Close()
+	if err := os.Remove(path); err != nil {
+		return "", fmt.Errorf("Failed to remove uncompressed file %s: %w", path, err)
+	}
+
+	return compressedPath, nil
+}
+
+// renameContentAddressed renames the file at path to events-<sha256[:16]><extension>, where
+// extension is path's own extension (so "events-12345.json.gz" becomes, e.g.,
+// "events-a1b2c3d4e5f6a7b8.json.gz"), and returns the new path. The hash is computed over the
+// file's full content, so two finalized files with identical content always get the same name,
+// which lets an uploader dedup against an object store by name alone.
+func renameContentAddressed(path string) (string, error) {
+	source, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to open %s for hashing: %w", path, err)
+	}
+	defer source.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, source); err != nil {
+		return "", fmt.Errorf("Failed to hash %s: %w", path, err)
+	}
+
+	digest := hex.EncodeToString(hash.Sum(nil))[:16]
+	addressedPath := filepath.Join(filepath.Dir(path), "events-"+digest+extensionOf(path))
+
+	if err := os.Rename(path, addressedPath); err != nil {
+		return "", fmt.Errorf("Failed to rename %s to %s: %w", path, addressedPath, err)
+	}
+
+	return addressedPath, nil
+}
+
+// extensionOf returns every suffix of path's filename starting from the first '.', so a
+// doubly-extended name like "events-123-abc.json.gz" yields ".json.gz" rather than just ".gz".
+func extensionOf(path string) string {
+	name := filepath.Base(path)
+	if index := strings.Index(name, "."); index != -1 {
+		return name[index:]
+	}
+	return ""
+}
+
+// gzipMagic and zstdMagic are the leading bytes DetectCompression looks for. zstd's magic number
+// is 0xFD2FB528, stored little-endian.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// DetectCompression inspects the leading bytes of data and returns compressionGzip or
+// compressionZstd if it recognizes the codec's magic number, or "" if data looks uncompressed (or
+// is too short to tell). It lets a reader transparently handle whichever codec, if any, a rotated
+// file was compressed with, without needing to know it ahead of time from the filename alone.
+func DetectCompression(data []byte) string {
+	if bytes.HasPrefix(data, gzipMagic) {
+		return compressionGzip
+	}
+	if bytes.HasPrefix(data, zstdMagic) {
+		return compressionZstd
+	}
+	return ""
+}
+
+// retainedFile is a candidate for retention pruning, found anywhere under a group's partitioned
+// directory tree.
+type retainedFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// pruneRetention deletes files anywhere under groupDirectory's YYYY/MM/DD/HH partitions, oldest
+// first, until the tree satisfies retention's limits. In-progress files, i.e. ones with a "."
+// prefix (including the group's ".wal" overflow segment), are never considered.
+func pruneRetention(groupDirectory string, retention RetentionConfig) error {
+	var files []retainedFile
+	var totalBytes int64
+
+	err := filepath.Walk(groupDirectory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || len(info.Name()) == 0 || info.Name()[0] == '.' {
+			return nil
+		}
+
+		files = append(files, retainedFile{path: path, modTime: info.ModTime(), size: info.Size()})
+		totalBytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to walk directory %s: %w", groupDirectory, err)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	now := time.Now()
+	for _, file := range files {
+		overAge := retention.MaxAge > 0 && now.Sub(file.modTime) > time.Duration(retention.MaxAge)*time.Microsecond
+		overBytes := retention.MaxBytes > 0 && totalBytes > retention.MaxBytes
+
+		if !overAge && !overBytes {
+			break
+		}
+
+		if err := os.Remove(file.path); err != nil {
+			return fmt.Errorf("Failed to remove %s for retention: %w", file.path, err)
+		}
+		totalBytes -= file.size
+	}
+
+	return nil
+}
@@ -0,0 +1,289 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package filesystem
+
+import (
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+	"github.com/weaklayer/gateway/server/output/blockstore"
+)
+
+func TestCompressFileGzip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "events.json")
+	if err := ioutil.WriteFile(path, []byte(`[{"type":"PageLoad"}]`), 0640); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	compressedPath, err := compressFile(path, compressionGzip)
+	if err != nil {
+		t.Fatalf("compressFile failed: %v", err)
+	}
+	if compressedPath != path+".gz" {
+		t.Fatalf("Expected compressed path %s.gz, got %s", path, compressedPath)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Expected original file %s to be removed", path)
+	}
+
+	compressedFile, err := os.Open(compressedPath)
+	if err != nil {
+		t.Fatalf("Failed to open compressed file: %v", err)
+	}
+	defer compressedFile.Close()
+
+	reader, err := gzip.NewReader(compressedFile)
+	if err != nil {
+		t.Fatalf("Failed to create gzip reader: %v", err)
+	}
+	defer reader.Close()
+
+	decompressed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to decompress: %v", err)
+	}
+	if string(decompressed) != `[{"type":"PageLoad"}]` {
+		t.Fatalf("Decompressed content did not match: %s", decompressed)
+	}
+}
+
+func TestCompressFileZstd(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "events.json")
+	if err := ioutil.WriteFile(path, []byte(`[{"type":"PageLoad"}]`), 0640); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	compressedPath, err := compressFile(path, compressionZstd)
+	if err != nil {
+		t.Fatalf("compressFile failed: %v", err)
+	}
+
+	compressedBytes, err := ioutil.ReadFile(compressedPath)
+	if err != nil {
+		t.Fatalf("Failed to read compressed file: %v", err)
+	}
+
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		t.Fatalf("Failed to create zstd reader: %v", err)
+	}
+	defer decoder.Close()
+
+	decompressed, err := decoder.DecodeAll(compressedBytes, nil)
+	if err != nil {
+		t.Fatalf("Failed to decompress: %v", err)
+	}
+	if string(decompressed) != `[{"type":"PageLoad"}]` {
+		t.Fatalf("Decompressed content did not match: %s", decompressed)
+	}
+}
+
+func TestDetectCompression(t *testing.T) {
+	dir := t.TempDir()
+
+	gzipPath := filepath.Join(dir, "events.json")
+	if err := ioutil.WriteFile(gzipPath, []byte(`[{"type":"PageLoad"}]`), 0640); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	compressedGzipPath, err := compressFile(gzipPath, compressionGzip)
+	if err != nil {
+		t.Fatalf("compressFile failed: %v", err)
+	}
+	gzipBytes, err := ioutil.ReadFile(compressedGzipPath)
+	if err != nil {
+		t.Fatalf("Failed to read compressed file: %v", err)
+	}
+	if detected := DetectCompression(gzipBytes); detected != compressionGzip {
+		t.Fatalf("Expected %q, got %q", compressionGzip, detected)
+	}
+
+	zstdPath := filepath.Join(dir, "events2.json")
+	if err := ioutil.WriteFile(zstdPath, []byte(`[{"type":"PageLoad"}]`), 0640); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	compressedZstdPath, err := compressFile(zstdPath, compressionZstd)
+	if err != nil {
+		t.Fatalf("compressFile failed: %v", err)
+	}
+	zstdBytes, err := ioutil.ReadFile(compressedZstdPath)
+	if err != nil {
+		t.Fatalf("Failed to read compressed file: %v", err)
+	}
+	if detected := DetectCompression(zstdBytes); detected != compressionZstd {
+		t.Fatalf("Expected %q, got %q", compressionZstd, detected)
+	}
+
+	if detected := DetectCompression([]byte(`[{"type":"PageLoad"}]`)); detected != "" {
+		t.Fatalf("Expected no compression detected for plain JSON, got %q", detected)
+	}
+}
+
+func TestRenameContentAddressed(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "events-123-abc.json")
+	content := []byte(`[{"type":"PageLoad"}]`)
+	if err := ioutil.WriteFile(path, content, 0640); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	addressedPath, err := renameContentAddressed(path)
+	if err != nil {
+		t.Fatalf("renameContentAddressed failed: %v", err)
+	}
+	if filepath.Ext(addressedPath) != ".json" {
+		t.Fatalf("Expected addressed path to keep .json extension, got %s", addressedPath)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Expected original file %s to be renamed away", path)
+	}
+
+	addressedBytes, err := ioutil.ReadFile(addressedPath)
+	if err != nil {
+		t.Fatalf("Failed to read addressed file: %v", err)
+	}
+	if string(addressedBytes) != string(content) {
+		t.Fatalf("Content changed across rename: %s", addressedBytes)
+	}
+
+	// Renaming identical content again, from a fresh file, must produce the same name.
+	otherPath := filepath.Join(dir, "events-456-def.json")
+	if err := ioutil.WriteFile(otherPath, content, 0640); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	otherAddressedPath, err := renameContentAddressed(otherPath)
+	if err != nil {
+		t.Fatalf("renameContentAddressed failed: %v", err)
+	}
+	if otherAddressedPath != addressedPath {
+		t.Fatalf("Expected identical content to produce the same addressed name, got %s and %s", addressedPath, otherAddressedPath)
+	}
+}
+
+func TestArchiveRotatedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "events-123-abc.json")
+	content := []byte(`[{"type":"PageLoad"}]`)
+	if err := ioutil.WriteFile(path, content, 0640); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+
+	store := blockstore.NewMemoryBlockStore()
+	if err := archiveRotatedFile(store, group, path); err != nil {
+		t.Fatalf("archiveRotatedFile failed: %v", err)
+	}
+
+	data, err := store.ReadBlock(context.Background(), group, filepath.Base(path))
+	if err != nil {
+		t.Fatalf("Failed to read archived block: %v", err)
+	}
+	if string(data) != string(content) {
+		t.Fatalf("Archived content did not match: %s", data)
+	}
+}
+
+func TestNewArchiveStore(t *testing.T) {
+	if store, err := newArchiveStore(ArchiveConfig{}); err != nil || store != nil {
+		t.Fatalf("Expected nil store and no error for an empty ArchiveConfig, got %v, %v", store, err)
+	}
+
+	if store, err := newArchiveStore(ArchiveConfig{Type: "memory"}); err != nil || store == nil {
+		t.Fatalf("Expected a memory store, got %v, %v", store, err)
+	}
+
+	if store, err := newArchiveStore(ArchiveConfig{Type: "local", Directory: t.TempDir()}); err != nil || store == nil {
+		t.Fatalf("Expected a local store, got %v, %v", store, err)
+	}
+
+	if _, err := newArchiveStore(ArchiveConfig{Type: "bogus"}); err == nil {
+		t.Fatal("Expected an error for an unknown archive type")
+	}
+}
+
+func TestPruneRetentionMaxBytes(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"1", "2", "3"} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte("0123456789"), 0640); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+		// Ensure distinct, increasing mod times so pruning order is deterministic.
+		modTime := time.Now().Add(time.Duration(name[0]) * time.Second)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set mod time: %v", err)
+		}
+	}
+
+	if err := pruneRetention(dir, RetentionConfig{MaxBytes: 15}); err != nil {
+		t.Fatalf("pruneRetention failed: %v", err)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read directory: %v", err)
+	}
+
+	if len(entries) != 1 || entries[0].Name() != "3" {
+		t.Fatalf("Expected only file \"3\" to remain, got %v", entries)
+	}
+}
+
+func TestPruneRetentionMaxAge(t *testing.T) {
+	dir := t.TempDir()
+
+	oldPath := filepath.Join(dir, "old")
+	if err := ioutil.WriteFile(oldPath, []byte("data"), 0640); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	oldTime := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(oldPath, oldTime, oldTime); err != nil {
+		t.Fatalf("Failed to set mod time: %v", err)
+	}
+
+	newPath := filepath.Join(dir, "new")
+	if err := ioutil.WriteFile(newPath, []byte("data"), 0640); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	if err := pruneRetention(dir, RetentionConfig{MaxAge: int64(time.Minute / time.Microsecond)}); err != nil {
+		t.Fatalf("pruneRetention failed: %v", err)
+	}
+
+	if _, err := os.Stat(oldPath); !os.IsNotExist(err) {
+		t.Fatal("Expected old file to be pruned")
+	}
+	if _, err := os.Stat(newPath); err != nil {
+		t.Fatal("Expected new file to remain")
+	}
+}
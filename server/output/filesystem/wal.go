@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package filesystem
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// walFilename is the name of the on-disk overflow segment inside a group directory. It is a dot
+// file so it is invisible to directory listings elsewhere in this package, like pruneRetention's.
+const walFilename = ".wal"
+
+// maxWALBytes bounds how much overflow a single group's WAL segment can hold before Append starts
+// refusing records. This keeps a sensor that is backpressured for a long time from filling the
+// disk; once the bound is hit the overflow is truly dropped rather than spilled.
+const maxWALBytes = 64 * 1024 * 1024
+
+// wal is a simple length-prefixed append-only overflow segment for a single group directory. It
+// exists so metaFile.Consume has somewhere to put events when the in-memory eventData channel is
+// completely full, instead of dropping them outright. metaProcess drains it back out once the
+// writing file has capacity.
+type wal struct {
+	path     string
+	maxBytes int64
+
+	mutex sync.Mutex
+	size  int64
+}
+
+// newWAL creates a wal backed by walFilename inside groupDirectory, picking up the size of any
+// segment left over from a previous run.
+func newWAL(groupDirectory string) (*wal, error) {
+	path := filepath.Join(groupDirectory, walFilename)
+
+	size := int64(0)
+	if info, err := os.Stat(path); err == nil {
+		size = info.Size()
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Failed to stat WAL segment %s: %w", path, err)
+	}
+
+	return &wal{path: path, maxBytes: maxWALBytes, size: size}, nil
+}
+
+// Append appends data to the WAL segment, returning false without error if doing so would exceed
+// maxBytes; the caller is expected to treat that as "could not spill" and drop the event.
+func (wal *wal) Append(data []byte) (bool, error) {
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	recordSize := int64(4 + len(data))
+	if wal.size+recordSize > wal.maxBytes {
+		return false, nil
+	}
+
+	file, err := os.OpenFile(wal.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return false, fmt.Errorf("Failed to open WAL segment %s: %w", wal.path, err)
+	}
+	defer file.Close()
+
+	lengthPrefix := make([]byte, 4)
+	binary.BigEndian.PutUint32(lengthPrefix, uint32(len(data)))
+
+	if _, err := file.Write(lengthPrefix); err != nil {
+		return false, fmt.Errorf("Failed to write to WAL segment %s: %w", wal.path, err)
+	}
+	if _, err := file.Write(data); err != nil {
+		return false, fmt.Errorf("Failed to write to WAL segment %s: %w", wal.path, err)
+	}
+
+	wal.size += recordSize
+	return true, nil
+}
+
+// Drain reads every record out of the WAL segment and deletes it, leaving the WAL empty. It
+// returns nil, nil if the segment is empty or does not exist.
+func (wal *wal) Drain() ([][]byte, error) {
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	if wal.size == 0 {
+		return nil, nil
+	}
+
+	segmentBytes, err := ioutil.ReadFile(wal.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			wal.size = 0
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Failed to read WAL segment %s: %w", wal.path, err)
+	}
+
+	var records [][]byte
+	for offset := 0; offset+4 <= len(segmentBytes); {
+		recordLength := int(binary.BigEndian.Uint32(segmentBytes[offset : offset+4]))
+		offset += 4
+
+		if offset+recordLength > len(segmentBytes) {
+			// A partial trailing record means the process died mid-write. Stop here rather than
+			// fail the whole drain; everything read so far is still valid.
+			break
+		}
+
+		records = append(records, segmentBytes[offset:offset+recordLength])
+		offset += recordLength
+	}
+
+	if err := os.Remove(wal.path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Failed to remove drained WAL segment %s: %w", wal.path, err)
+	}
+	wal.size = 0
+
+	return records, nil
+}
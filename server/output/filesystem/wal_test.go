@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package filesystem
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWALAppendAndDrain(t *testing.T) {
+	dir := t.TempDir()
+
+	groupWAL, err := newWAL(dir)
+	if err != nil {
+		t.Fatalf("newWAL failed: %v", err)
+	}
+
+	for _, record := range [][]byte{[]byte("one"), []byte("two"), []byte("three")} {
+		spilled, err := groupWAL.Append(record)
+		if err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+		if !spilled {
+			t.Fatalf("Expected Append to succeed for %q", record)
+		}
+	}
+
+	records, err := groupWAL.Drain()
+	if err != nil {
+		t.Fatalf("Drain failed: %v", err)
+	}
+
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(records))
+	}
+	for i, expected := range [][]byte{[]byte("one"), []byte("two"), []byte("three")} {
+		if !bytes.Equal(records[i], expected) {
+			t.Fatalf("Record %d: expected %q, got %q", i, expected, records[i])
+		}
+	}
+
+	// Draining again should yield nothing, since the segment was removed.
+	records, err = groupWAL.Drain()
+	if err != nil {
+		t.Fatalf("Second drain failed: %v", err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("Expected empty drain after segment removal, got %d records", len(records))
+	}
+}
+
+func TestWALAppendRejectsOverMaxBytes(t *testing.T) {
+	dir := t.TempDir()
+
+	groupWAL, err := newWAL(dir)
+	if err != nil {
+		t.Fatalf("newWAL failed: %v", err)
+	}
+	groupWAL.maxBytes = 10
+
+	spilled, err := groupWAL.Append([]byte("short"))
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if !spilled {
+		t.Fatal("Expected first Append to succeed")
+	}
+
+	spilled, err = groupWAL.Append([]byte("this record is too big"))
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if spilled {
+		t.Fatal("Expected Append to refuse a record that would exceed maxBytes")
+	}
+}
@@ -0,0 +1,238 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+
+	"github.com/rs/zerolog/log"
+	"github.com/weaklayer/gateway/server/events"
+	"github.com/weaklayer/gateway/server/output"
+)
+
+// groupPlaceholder is the substring TopicTemplate is expanded against, replaced with an event's
+// group UUID so events for different groups route to different topics.
+const groupPlaceholder = "{group}"
+
+// Config configures the Kafka output sink
+type Config struct {
+	Enabled bool
+	Brokers []string
+	Topic   string
+	// TopicTemplate, if non-empty, takes precedence over Topic and is expanded per event by
+	// replacing every occurrence of "{group}" with the event's group UUID, so events route to
+	// group-specific topics instead of one shared topic.
+	TopicTemplate string
+	// Compression is the codec produced messages are compressed with: "gzip", "snappy", "lz4",
+	// "zstd", or "" (the default) for no compression.
+	Compression string
+	TLS         struct {
+		Enabled bool
+	}
+	SASL struct {
+		// Mechanism selects the SASL mechanism: "plain", "scram-sha-256", or "scram-sha-512". Empty
+		// disables SASL.
+		Mechanism string
+		Username  string
+		Password  string
+	}
+	// HighWaterMark is the event batch queue depth at which Consume starts returning
+	// output.ErrBackpressure instead of accepting more batches. A value <= 0 falls back to
+	// output.DefaultHighWaterMark.
+	HighWaterMark int
+}
+
+// compressionCodec parses Config.Compression into the kafka-go Compression it names. An empty or
+// unrecognized name yields no compression.
+func compressionCodec(name string) kafkago.Compression {
+	switch strings.ToLower(name) {
+	case "gzip":
+		return kafkago.Gzip
+	case "snappy":
+		return kafkago.Snappy
+	case "lz4":
+		return kafkago.Lz4
+	case "zstd":
+		return kafkago.Zstd
+	default:
+		return 0
+	}
+}
+
+// saslMechanism builds the SASL mechanism Config.SASL names. It returns a nil mechanism, and no
+// error, when Config.SASL.Mechanism is empty.
+func saslMechanism(config Config) (sasl.Mechanism, error) {
+	switch strings.ToLower(config.SASL.Mechanism) {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: config.SASL.Username, Password: config.SASL.Password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, config.SASL.Username, config.SASL.Password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, config.SASL.Username, config.SASL.Password)
+	default:
+		return nil, fmt.Errorf("Unknown Kafka SASL mechanism %s", config.SASL.Mechanism)
+	}
+}
+
+// writer is the subset of *kafkago.Writer that KafkaOutput depends on, so tests can substitute a
+// fake producer without a live broker.
+type writer interface {
+	WriteMessages(ctx context.Context, msgs ...kafkago.Message) error
+	Close() error
+}
+
+// NewKafkaOutput creates a KafkaOutput instance. Messages are produced with RequiredAcks set to
+// acks=all, so a produce only succeeds once every in-sync replica has the record. If
+// config.TopicTemplate is set, it takes precedence over config.Topic and is expanded per event;
+// otherwise every event is produced to the fixed config.Topic.
+func NewKafkaOutput(config Config) (KafkaOutput, error) {
+	if len(config.Brokers) == 0 {
+		return KafkaOutput{}, fmt.Errorf("Must specify at least one Kafka broker")
+	}
+	if config.Topic == "" && config.TopicTemplate == "" {
+		return KafkaOutput{}, fmt.Errorf("Must specify a Kafka topic or topic template")
+	}
+
+	mechanism, err := saslMechanism(config)
+	if err != nil {
+		return KafkaOutput{}, fmt.Errorf("Failed to configure Kafka SASL: %w", err)
+	}
+
+	var transport kafkago.RoundTripper
+	if config.TLS.Enabled || mechanism != nil {
+		kafkaTransport := &kafkago.Transport{SASL: mechanism}
+		if config.TLS.Enabled {
+			kafkaTransport.TLS = &tls.Config{}
+		}
+		transport = kafkaTransport
+	}
+
+	kafkaWriter := &kafkago.Writer{
+		Addr:         kafkago.TCP(config.Brokers...),
+		Topic:        config.Topic,
+		Balancer:     &kafkago.LeastBytes{},
+		RequiredAcks: kafkago.RequireAll,
+		BatchTimeout: time.Second,
+		Compression:  compressionCodec(config.Compression),
+		Transport:    transport,
+	}
+
+	return newKafkaOutput(kafkaWriter, config.TopicTemplate, config.HighWaterMark), nil
+}
+
+func newKafkaOutput(kafkaWriter writer, topicTemplate string, highWaterMark int) KafkaOutput {
+	if highWaterMark <= 0 {
+		highWaterMark = output.DefaultHighWaterMark
+	}
+
+	eventBatches := make(chan []events.Event, 10000)
+
+	kafkaOutput := KafkaOutput{
+		eventBatches:  eventBatches,
+		highWaterMark: highWaterMark,
+	}
+
+	go process(kafkaWriter, topicTemplate, eventBatches)
+
+	return kafkaOutput
+}
+
+// KafkaOutput is an event output that produces events to a Kafka topic
+type KafkaOutput struct {
+	eventBatches  chan<- []events.Event
+	highWaterMark int
+}
+
+// Close should only be called once after Consume is guaranteed not to be called again
+func (kafkaOutput KafkaOutput) Close() {
+	close(kafkaOutput.eventBatches)
+}
+
+// Consume takes the events and writes them to a channel for processing
+func (kafkaOutput KafkaOutput) Consume(ctx context.Context, events []events.Event) error {
+	if len(kafkaOutput.eventBatches) >= kafkaOutput.highWaterMark {
+		return output.ErrBackpressure
+	}
+
+	select {
+	case kafkaOutput.eventBatches <- events:
+		return nil
+	case <-ctx.Done():
+		return output.ErrBackpressure
+	default:
+		return output.ErrBackpressure
+	}
+}
+
+// toMessages serializes a batch of events into Kafka messages keyed by the event's group, so a
+// single-partition consumer can still process events for a given group in order. If
+// topicTemplate is non-empty, each message's Topic is set by expanding it against the event's
+// group, overriding the Writer's own fixed Topic.
+func toMessages(batch []events.Event, topicTemplate string) []kafkago.Message {
+	messages := make([]kafkago.Message, 0, len(batch))
+
+	for _, event := range batch {
+		serializedBytes, err := json.Marshal(event)
+		if err != nil {
+			log.Info().Err(err).Msg("Failed to serialize event for Kafka output. Discarding event")
+			continue
+		}
+
+		message := kafkago.Message{
+			Key:   []byte(event.GetGroup().String()),
+			Value: serializedBytes,
+		}
+		if topicTemplate != "" {
+			message.Topic = strings.ReplaceAll(topicTemplate, groupPlaceholder, event.GetGroup().String())
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages
+}
+
+func process(kafkaWriter writer, topicTemplate string, eventBatches <-chan []events.Event) {
+	defer kafkaWriter.Close()
+
+	for batch := range eventBatches {
+		messages := toMessages(batch, topicTemplate)
+		if len(messages) == 0 {
+			continue
+		}
+
+		err := kafkaWriter.WriteMessages(context.Background(), messages...)
+		if err != nil {
+			log.Info().Err(err).Msg("Failed to produce event batch to Kafka. Discarding batch")
+		}
+	}
+}
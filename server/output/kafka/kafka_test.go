@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kafka
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+
+	"github.com/google/uuid"
+	"github.com/weaklayer/gateway/server/events"
+)
+
+type fakeWriter struct {
+	mutex    sync.Mutex
+	messages []kafkago.Message
+	closed   bool
+}
+
+func (fakeWriter *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafkago.Message) error {
+	fakeWriter.mutex.Lock()
+	defer fakeWriter.mutex.Unlock()
+	fakeWriter.messages = append(fakeWriter.messages, msgs...)
+	return nil
+}
+
+func (fakeWriter *fakeWriter) Close() error {
+	fakeWriter.mutex.Lock()
+	defer fakeWriter.mutex.Unlock()
+	fakeWriter.closed = true
+	return nil
+}
+
+func (fakeWriter *fakeWriter) count() int {
+	fakeWriter.mutex.Lock()
+	defer fakeWriter.mutex.Unlock()
+	return len(fakeWriter.messages)
+}
+
+func TestNewKafkaOutputRequiresBrokersAndTopic(t *testing.T) {
+	if _, err := NewKafkaOutput(Config{Topic: "events"}); err == nil {
+		t.Fatal("Expected an error when no brokers are configured")
+	}
+
+	if _, err := NewKafkaOutput(Config{Brokers: []string{"localhost:9092"}}); err == nil {
+		t.Fatal("Expected an error when no topic or topic template is configured")
+	}
+
+	if _, err := NewKafkaOutput(Config{Brokers: []string{"localhost:9092"}, TopicTemplate: "events-{group}"}); err != nil {
+		t.Fatalf("Expected a topic template to satisfy the topic requirement: %v", err)
+	}
+}
+
+func TestNewKafkaOutputRejectsUnknownSASLMechanism(t *testing.T) {
+	config := Config{Brokers: []string{"localhost:9092"}, Topic: "events"}
+	config.SASL.Mechanism = "md5"
+
+	if _, err := NewKafkaOutput(config); err == nil {
+		t.Fatal("Expected an error for an unknown SASL mechanism")
+	}
+}
+
+func TestCompressionCodec(t *testing.T) {
+	cases := map[string]kafkago.Compression{
+		"":       0,
+		"gzip":   kafkago.Gzip,
+		"Snappy": kafkago.Snappy,
+		"lz4":    kafkago.Lz4,
+		"zstd":   kafkago.Zstd,
+		"bogus":  0,
+	}
+
+	for name, want := range cases {
+		if got := compressionCodec(name); got != want {
+			t.Fatalf("compressionCodec(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestToMessagesAppliesTopicTemplate(t *testing.T) {
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate test group UUID: %v", err)
+	}
+	sensor, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate test sensor UUID: %v", err)
+	}
+
+	event := events.SensorEvent{
+		Type:   "Unknown",
+		Time:   1,
+		Sensor: sensor,
+		Group:  group,
+		Data:   make(map[string]interface{}),
+	}
+
+	messages := toMessages([]events.Event{event}, "events-{group}")
+	if len(messages) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(messages))
+	}
+
+	wantTopic := "events-" + group.String()
+	if messages[0].Topic != wantTopic {
+		t.Fatalf("Expected topic %s, got %s", wantTopic, messages[0].Topic)
+	}
+}
+
+func TestKafkaOutputProducesMessagesKeyedByGroup(t *testing.T) {
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate test group UUID: %v", err)
+	}
+	sensor, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate test sensor UUID: %v", err)
+	}
+
+	event := events.SensorEvent{
+		Type:   "Unknown",
+		Time:   1,
+		Sensor: sensor,
+		Group:  group,
+		Data:   make(map[string]interface{}),
+	}
+
+	fake := &fakeWriter{}
+	kafkaOutput := newKafkaOutput(fake, "", 0)
+
+	if err := kafkaOutput.Consume(context.Background(), []events.Event{event}); err != nil {
+		t.Fatalf("Consume failed: %v", err)
+	}
+
+	kafkaOutput.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for fake.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if fake.count() != 1 {
+		t.Fatalf("Expected 1 message produced, got %d", fake.count())
+	}
+
+	if string(fake.messages[0].Key) != group.String() {
+		t.Fatalf("Expected message key %s, got %s", group.String(), string(fake.messages[0].Key))
+	}
+}
@@ -20,16 +20,38 @@
 package output
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
 	"github.com/weaklayer/gateway/server/events"
 )
 
+// ErrBackpressure is returned by an Output's Consume when it cannot accept events because its
+// internal queue has reached its high-water mark or is full. It is a sentinel so callers can
+// distinguish backpressure, which a sensor should retry, from any other Consume failure.
+var ErrBackpressure = errors.New("output queue is under backpressure")
+
+// ErrQueueFull is returned by an Output's Consume when it cannot accept events in any form: its
+// in-memory queue is full and any on-disk overflow capacity it has is also exhausted. Unlike
+// ErrBackpressure, which just means "slow down and retry", ErrQueueFull means the batch was not
+// accepted at all, so callers like InstallAPI.Handle can surface that to the sensor instead of
+// silently succeeding.
+var ErrQueueFull = errors.New("output queue and overflow capacity are full")
+
+// DefaultHighWaterMark is the queue depth, out of the 10000-deep channel every channel-backed
+// output uses, at which Consume starts returning ErrBackpressure instead of accepting more
+// events. It leaves headroom between "start rejecting new batches" and "queue is actually full",
+// so a burst already in flight has room to drain.
+const DefaultHighWaterMark = 8000
+
 // Output is the interface that all outputs implement
 // It allows the top-level output handler to keep a list of
 // many outputs to send events to without knowing their implenetation
 type Output interface {
-	Consume(events []events.Event) error
+	// Consume accepts events for output. It returns ErrBackpressure if ctx is cancelled or the
+	// output's queue is at or above its high-water mark; callers should treat this as retriable.
+	Consume(ctx context.Context, events []events.Event) error
 	// Close performs any nessecary cleanup in an output (e.g. close file descriptor)
 	Close()
 }
@@ -54,18 +76,36 @@ func (topOutput TopOutput) Close() {
 	}
 }
 
-// Consume is the main destination for sensor events.
-func (topOutput TopOutput) Consume(events []events.Event) error {
-	var errors []error = nil
+// Consume is the main destination for sensor events. If any output returns ErrQueueFull or
+// ErrBackpressure, that takes priority over other errors in the return value, so callers can
+// reliably detect it with errors.Is; ErrQueueFull takes priority over ErrBackpressure since it
+// means a batch was actually rejected rather than merely asked to retry.
+func (topOutput TopOutput) Consume(ctx context.Context, events []events.Event) error {
+	var errs []error = nil
+	var backpressured bool
+	var queueFull bool
 	for _, output := range topOutput.outputs {
-		err := output.Consume(events)
+		err := output.Consume(ctx, events)
 		if err != nil {
-			errors = append(errors, err)
+			if errors.Is(err, ErrQueueFull) {
+				queueFull = true
+			} else if errors.Is(err, ErrBackpressure) {
+				backpressured = true
+			}
+			errs = append(errs, err)
 		}
 	}
 
-	if errors != nil {
-		return fmt.Errorf("Error(s) encountered consuming events: %v", errors)
+	if queueFull {
+		return ErrQueueFull
+	}
+
+	if backpressured {
+		return ErrBackpressure
+	}
+
+	if errs != nil {
+		return fmt.Errorf("Error(s) encountered consuming events: %v", errs)
 	}
 
 	return nil
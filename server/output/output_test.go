@@ -20,6 +20,8 @@
 package output
 
 import (
+	"context"
+	"errors"
 	"testing"
 
 	"github.com/google/uuid"
@@ -30,7 +32,7 @@ type dummyConsumer struct {
 	eventsReceived *int
 }
 
-func (dummyConsumer dummyConsumer) Consume(events []events.SensorEvent) error {
+func (dummyConsumer dummyConsumer) Consume(ctx context.Context, events []events.Event) error {
 	*dummyConsumer.eventsReceived = *dummyConsumer.eventsReceived + len(events)
 	return nil
 }
@@ -63,7 +65,7 @@ func TestOutputConsume(t *testing.T) {
 		Group:  group,
 	}
 
-	events := []events.SensorEvent{event1, event2}
+	events := []events.Event{event1, event2}
 
 	er1 := 0
 	er2 := 0
@@ -72,7 +74,7 @@ func TestOutputConsume(t *testing.T) {
 
 	topOutput := TopOutput{outputs: []Output{output1, output2}}
 
-	err = topOutput.Consume(events)
+	err = topOutput.Consume(context.Background(), events)
 	if err != nil {
 		t.Fatalf("Error consuming event: %v", err)
 	}
@@ -84,3 +86,26 @@ func TestOutputConsume(t *testing.T) {
 		t.Fatalf("Output 2 received %d events instead of the expected 2", *output2.eventsReceived)
 	}
 }
+
+type erroringConsumer struct {
+	err error
+}
+
+func (erroringConsumer erroringConsumer) Consume(ctx context.Context, events []events.Event) error {
+	return erroringConsumer.err
+}
+
+func (erroringConsumer erroringConsumer) Close() {
+}
+
+func TestOutputConsumeErrQueueFullTakesPriority(t *testing.T) {
+	topOutput := TopOutput{outputs: []Output{
+		erroringConsumer{err: ErrBackpressure},
+		erroringConsumer{err: ErrQueueFull},
+	}}
+
+	err := topOutput.Consume(context.Background(), []events.Event{})
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Expected ErrQueueFull, got %v", err)
+	}
+}
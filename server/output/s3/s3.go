@@ -0,0 +1,244 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/weaklayer/gateway/server/events"
+	"github.com/weaklayer/gateway/server/output"
+)
+
+// Config configures the S3 output sink
+type Config struct {
+	Enabled bool
+	Bucket  string
+	Prefix  string
+	Region  string
+	// HighWaterMark is the per-group queue depth at which Consume starts returning
+	// output.ErrBackpressure instead of accepting more events. A value <= 0 falls back to
+	// output.DefaultHighWaterMark.
+	HighWaterMark int
+}
+
+// putObjectAPI is the subset of the S3 client that S3Output depends on, so tests can substitute a
+// fake without a real bucket.
+type putObjectAPI interface {
+	PutObject(*awss3.PutObjectInput) (*awss3.PutObjectOutput, error)
+}
+
+// NewS3Output creates an S3Output instance. Events are buffered per sensor group as
+// newline-delimited JSON and rolled into a new object, named by group and upload time, whenever
+// maxObjectAge or maxObjectSize is reached - mirroring the filesystem output's rotation logic.
+func NewS3Output(config Config, maxObjectAge time.Duration, maxObjectSize int) (S3Output, error) {
+	if config.Bucket == "" {
+		return S3Output{}, fmt.Errorf("Must specify an S3 bucket")
+	}
+
+	awsSession, err := session.NewSession(&aws.Config{Region: aws.String(config.Region)})
+	if err != nil {
+		return S3Output{}, fmt.Errorf("Failed to create AWS session: %w", err)
+	}
+
+	return newS3Output(config.Bucket, config.Prefix, awss3.New(awsSession), maxObjectAge, maxObjectSize, config.HighWaterMark), nil
+}
+
+func newS3Output(bucket string, prefix string, client putObjectAPI, maxObjectAge time.Duration, maxObjectSize int, highWaterMark int) S3Output {
+	if highWaterMark <= 0 {
+		highWaterMark = output.DefaultHighWaterMark
+	}
+
+	return S3Output{
+		bucket:           bucket,
+		prefix:           prefix,
+		client:           client,
+		maxObjectAge:     maxObjectAge,
+		maxObjectSize:    maxObjectSize,
+		highWaterMark:    highWaterMark,
+		groupWriters:     make(map[uuid.UUID]*groupWriter),
+		groupWriterMutex: &sync.Mutex{},
+	}
+}
+
+// S3Output is an event output that writes rolled newline-delimited JSON objects to S3, one
+// object stream per sensor group
+type S3Output struct {
+	bucket           string
+	prefix           string
+	client           putObjectAPI
+	maxObjectAge     time.Duration
+	maxObjectSize    int
+	highWaterMark    int
+	groupWriters     map[uuid.UUID]*groupWriter
+	groupWriterMutex *sync.Mutex
+}
+
+// Close should only be called once after Consume is guaranteed not to be called again
+func (s3Output S3Output) Close() {
+	groupWriters := s3Output.groupWriters
+	s3Output.groupWriters = make(map[uuid.UUID]*groupWriter)
+
+	for _, writer := range groupWriters {
+		writer.Close()
+	}
+}
+
+// Consume takes the events and writes them to the buffer for the event's sensor group
+func (s3Output S3Output) Consume(ctx context.Context, consumedEvents []events.Event) error {
+	group := consumedEvents[0].GetGroup()
+
+	writer, err := s3Output.getGroupWriter(group)
+	if err != nil {
+		return fmt.Errorf("Failed to write events to S3: %w", err)
+	}
+
+	return writer.Consume(ctx, consumedEvents)
+}
+
+func (s3Output S3Output) getGroupWriter(group uuid.UUID) (*groupWriter, error) {
+	s3Output.groupWriterMutex.Lock()
+	defer s3Output.groupWriterMutex.Unlock()
+
+	if writer, ok := s3Output.groupWriters[group]; ok {
+		return writer, nil
+	}
+
+	writer := newGroupWriter(s3Output.bucket, s3Output.prefix, group, s3Output.client, s3Output.maxObjectAge, s3Output.maxObjectSize, s3Output.highWaterMark)
+	s3Output.groupWriters[group] = writer
+
+	return writer, nil
+}
+
+// groupWriter buffers one sensor group's events as newline-delimited JSON and uploads the buffer
+// as a new S3 object whenever it is rotated
+type groupWriter struct {
+	eventData     chan<- []byte
+	highWaterMark int
+}
+
+func newGroupWriter(bucket string, prefix string, group uuid.UUID, client putObjectAPI, maxObjectAge time.Duration, maxObjectSize int, highWaterMark int) *groupWriter {
+	eventData := make(chan []byte, 10000)
+
+	go groupProcess(bucket, prefix, group, client, maxObjectAge, maxObjectSize, eventData)
+
+	return &groupWriter{eventData: eventData, highWaterMark: highWaterMark}
+}
+
+func (groupWriter *groupWriter) Close() {
+	close(groupWriter.eventData)
+}
+
+func (groupWriter *groupWriter) Consume(ctx context.Context, consumedEvents []events.Event) error {
+	var encounteredError = false
+
+	for _, event := range consumedEvents {
+		serializedBytes, err := json.Marshal(event)
+		if err != nil {
+			encounteredError = true
+			log.Info().Err(err).Msg("Failed to serialize event for S3 output. Discarding event")
+			continue
+		}
+
+		if len(groupWriter.eventData) >= groupWriter.highWaterMark {
+			return output.ErrBackpressure
+		}
+
+		select {
+		case groupWriter.eventData <- serializedBytes:
+		case <-ctx.Done():
+			return output.ErrBackpressure
+		default:
+			return output.ErrBackpressure
+		}
+	}
+
+	if encounteredError {
+		return fmt.Errorf("Encountered errors serializing events for S3")
+	}
+
+	return nil
+}
+
+// objectKey names a rolled object by group and the time it was uploaded, so objects for the same
+// group never collide and sort lexicographically by upload time.
+func objectKey(prefix string, group uuid.UUID, uploadTime time.Time) string {
+	filename := strconv.FormatInt(uploadTime.UnixNano()/1000, 10) + ".json"
+	return path.Join(prefix, group.String(), filename)
+}
+
+func groupProcess(bucket string, prefix string, group uuid.UUID, client putObjectAPI, maxObjectAge time.Duration, maxObjectSize int, eventData <-chan []byte) {
+	var buffer bytes.Buffer
+	ageTimer := time.NewTimer(maxObjectAge)
+
+	upload := func() {
+		if buffer.Len() == 0 {
+			ageTimer.Reset(maxObjectAge)
+			return
+		}
+
+		key := objectKey(prefix, group, time.Now())
+		_, err := client.PutObject(&awss3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(buffer.Bytes()),
+		})
+		if err != nil {
+			log.Info().Err(err).Msgf("Failed to upload object %s to S3 bucket %s. Discarding buffered events", key, bucket)
+		}
+
+		buffer.Reset()
+		ageTimer.Reset(maxObjectAge)
+	}
+
+readLoop:
+	for {
+		select {
+		case serializedBytes, ok := <-eventData:
+			if !ok {
+				// eventData closed. Time to shut down.
+				break readLoop
+			}
+
+			buffer.Write(serializedBytes)
+			buffer.WriteByte('\n')
+
+			if buffer.Len() >= maxObjectSize {
+				upload()
+			}
+		case <-ageTimer.C:
+			upload()
+		}
+	}
+
+	upload()
+}
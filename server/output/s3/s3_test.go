@@ -0,0 +1,159 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package s3
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/google/uuid"
+	"github.com/weaklayer/gateway/server/events"
+)
+
+type fakePutObjectAPI struct {
+	mutex   sync.Mutex
+	objects map[string]string
+}
+
+func newFakePutObjectAPI() *fakePutObjectAPI {
+	return &fakePutObjectAPI{objects: make(map[string]string)}
+}
+
+func (fake *fakePutObjectAPI) PutObject(input *awss3.PutObjectInput) (*awss3.PutObjectOutput, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	bodyBytes, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	fake.objects[*input.Key] = string(bodyBytes)
+
+	return &awss3.PutObjectOutput{}, nil
+}
+
+func (fake *fakePutObjectAPI) count() int {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	return len(fake.objects)
+}
+
+func (fake *fakePutObjectAPI) anyBody() string {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	for _, body := range fake.objects {
+		return body
+	}
+	return ""
+}
+
+func waitForCount(t *testing.T, fake *fakePutObjectAPI, want int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for fake.count() < want && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if fake.count() < want {
+		t.Fatalf("Expected at least %d uploaded objects, got %d", want, fake.count())
+	}
+}
+
+func TestS3OutputRotatesOnSize(t *testing.T) {
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate test group UUID: %v", err)
+	}
+	sensor, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate test sensor UUID: %v", err)
+	}
+
+	event := events.SensorEvent{
+		Type:   "Unknown",
+		Time:   1,
+		Sensor: sensor,
+		Group:  group,
+		Data:   make(map[string]interface{}),
+	}
+
+	fake := newFakePutObjectAPI()
+	s3Output := newS3Output("test-bucket", "events", fake, time.Hour, 10, 0)
+
+	if err := s3Output.Consume(context.Background(), []events.Event{event}); err != nil {
+		t.Fatalf("Consume failed: %v", err)
+	}
+
+	waitForCount(t, fake, 1)
+
+	if !strings.Contains(fake.anyBody(), group.String()) {
+		t.Fatalf("Expected uploaded object to contain the event, got %s", fake.anyBody())
+	}
+
+	s3Output.Close()
+}
+
+func TestS3OutputRotatesOnClose(t *testing.T) {
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate test group UUID: %v", err)
+	}
+	sensor, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate test sensor UUID: %v", err)
+	}
+
+	event := events.SensorEvent{
+		Type:   "Unknown",
+		Time:   1,
+		Sensor: sensor,
+		Group:  group,
+		Data:   make(map[string]interface{}),
+	}
+
+	fake := newFakePutObjectAPI()
+	s3Output := newS3Output("test-bucket", "events", fake, time.Hour, 100000000, 0)
+
+	if err := s3Output.Consume(context.Background(), []events.Event{event}); err != nil {
+		t.Fatalf("Consume failed: %v", err)
+	}
+
+	s3Output.Close()
+
+	waitForCount(t, fake, 1)
+}
+
+func TestObjectKey(t *testing.T) {
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate test group UUID: %v", err)
+	}
+
+	uploadTime := time.Date(2026, time.March, 5, 12, 0, 0, 0, time.UTC)
+	key := objectKey("events", group, uploadTime)
+
+	if !strings.HasPrefix(key, "events/"+group.String()+"/") {
+		t.Fatalf("Expected object key to be namespaced by prefix and group, got %s", key)
+	}
+}
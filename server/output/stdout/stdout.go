@@ -20,19 +20,29 @@
 package stdout
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/weaklayer/gateway/server/events"
+	"github.com/weaklayer/gateway/server/output"
+	"github.com/weaklayer/gateway/server/telemetry"
 )
 
-// NewStdoutOutput creates an StdoutOutput instance
-func NewStdoutOutput() StdoutOutput {
+// NewStdoutOutput creates an StdoutOutput instance. highWaterMark is the queue depth at which
+// Consume starts returning output.ErrBackpressure instead of accepting more events; a value <= 0
+// falls back to output.DefaultHighWaterMark.
+func NewStdoutOutput(highWaterMark int) StdoutOutput {
+	if highWaterMark <= 0 {
+		highWaterMark = output.DefaultHighWaterMark
+	}
+
 	eventStrings := make(chan string, 10000)
 	stdoutput := StdoutOutput{
-		eventStrings: eventStrings,
+		eventStrings:  eventStrings,
+		highWaterMark: highWaterMark,
 	}
 
 	go process(eventStrings)
@@ -42,7 +52,8 @@ func NewStdoutOutput() StdoutOutput {
 
 // StdoutOutput is an event output that writes events to stdout
 type StdoutOutput struct {
-	eventStrings chan<- string
+	eventStrings  chan<- string
+	highWaterMark int
 }
 
 // Close does nothing for StdoutOutput
@@ -51,7 +62,7 @@ func (stdoutOutput StdoutOutput) Close() {
 }
 
 // Consume takes the events and writes them to a channel for processing
-func (stdoutOutput StdoutOutput) Consume(events []events.Event) error {
+func (stdoutOutput StdoutOutput) Consume(ctx context.Context, events []events.Event) error {
 	var encounteredError = false
 
 	for _, event := range events {
@@ -62,12 +73,18 @@ func (stdoutOutput StdoutOutput) Consume(events []events.Event) error {
 			continue
 		}
 
+		if len(stdoutOutput.eventStrings) >= stdoutOutput.highWaterMark {
+			return output.ErrBackpressure
+		}
+
 		select {
 		case stdoutOutput.eventStrings <- string(serializedBytes):
+			telemetry.RecordQueueDepth(ctx, "stdout.eventStrings", len(stdoutOutput.eventStrings))
+		case <-ctx.Done():
+			return output.ErrBackpressure
 		default:
-			encounteredError = true
-			log.Info().Msgf("Event queue for stdout output full. Discarding Event.")
-			continue
+			telemetry.RecordEventsDropped(ctx, 1, "queue_full")
+			return output.ErrBackpressure
 		}
 	}
 
@@ -88,5 +105,7 @@ func process(eventStrings <-chan string) {
 		if n < len(eventString) {
 			log.Info().Msg("Failed to print all event bytes to stdout.")
 		}
+
+		telemetry.RecordOutputBytesWritten(context.Background(), int64(n), "stdout")
 	}
 }
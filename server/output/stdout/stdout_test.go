@@ -20,6 +20,7 @@
 package stdout
 
 import (
+	"context"
 	"testing"
 
 	"github.com/google/uuid"
@@ -44,9 +45,9 @@ func TestStdoutOutputConsume(t *testing.T) {
 		Group:  group,
 	}
 
-	stdoutput := NewStdoutOutput()
+	stdoutput := NewStdoutOutput(0)
 
-	err = stdoutput.Consume([]events.Event{event})
+	err = stdoutput.Consume(context.Background(), []events.Event{event})
 	if err != nil {
 		t.Fatalf("Error consuming event: %v", err)
 	}
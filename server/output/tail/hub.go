@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package tail
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/weaklayer/gateway/server/events"
+)
+
+// subscriptionBacklog is how many unconsumed events a Subscription holds before new events are
+// dropped for it, so one slow tail session can't block event ingestion for everyone else
+const subscriptionBacklog = 256
+
+// Filter restricts a Subscription to events matching all of its set fields.
+// The zero value of a field means "don't filter on this dimension".
+type Filter struct {
+	Group  uuid.UUID
+	Sensor uuid.UUID
+	Type   events.EventType
+}
+
+func (filter Filter) matches(event events.Event) bool {
+	if filter.Group != (uuid.UUID{}) && filter.Group != event.GetGroup() {
+		return false
+	}
+	if filter.Sensor != (uuid.UUID{}) && filter.Sensor != event.GetSensor() {
+		return false
+	}
+	if filter.Type != "" && filter.Type != event.GetType() {
+		return false
+	}
+	return true
+}
+
+// overlaps reports whether two filters could both match the same event, i.e. neither one rules
+// out a value the other requires
+func (filter Filter) overlaps(other Filter) bool {
+	if filter.Group != (uuid.UUID{}) && other.Group != (uuid.UUID{}) && filter.Group != other.Group {
+		return false
+	}
+	if filter.Sensor != (uuid.UUID{}) && other.Sensor != (uuid.UUID{}) && filter.Sensor != other.Sensor {
+		return false
+	}
+	if filter.Type != "" && other.Type != "" && filter.Type != other.Type {
+		return false
+	}
+	return true
+}
+
+// Subscription is a single tail session's view into the event stream
+type Subscription struct {
+	operator string
+	filter   Filter
+	Events   chan events.Event
+	// Cancelled is closed when the Hub cancels this subscription in favor of a newer one from
+	// the same operator. The WebSocket handler watches it to know when to close the connection.
+	Cancelled chan struct{}
+}
+
+// Hub fans out events to subscribers, filtering per-subscription and keeping at most one active
+// subscription per operator with an overlapping filter
+type Hub struct {
+	mutex         sync.Mutex
+	subscriptions map[*Subscription]struct{}
+}
+
+// NewHub creates an empty Hub
+func NewHub() *Hub {
+	return &Hub{
+		subscriptions: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe registers a new Subscription for the given operator and filter. If the operator
+// already has a subscription with an overlapping filter, it is cancelled first.
+func (hub *Hub) Subscribe(operator string, filter Filter) *Subscription {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	for existing := range hub.subscriptions {
+		if existing.operator == operator && existing.filter.overlaps(filter) {
+			delete(hub.subscriptions, existing)
+			close(existing.Cancelled)
+		}
+	}
+
+	subscription := &Subscription{
+		operator:  operator,
+		filter:    filter,
+		Events:    make(chan events.Event, subscriptionBacklog),
+		Cancelled: make(chan struct{}),
+	}
+
+	hub.subscriptions[subscription] = struct{}{}
+
+	return subscription
+}
+
+// Unsubscribe removes a Subscription, for example once its WebSocket connection closes
+func (hub *Hub) Unsubscribe(subscription *Subscription) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	delete(hub.subscriptions, subscription)
+}
+
+// Publish sends an event to every subscription whose filter matches it. Subscriptions whose
+// backlog is full have the event dropped for them rather than blocking the publisher.
+func (hub *Hub) Publish(event events.Event) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	for subscription := range hub.subscriptions {
+		if !subscription.filter.matches(event) {
+			continue
+		}
+
+		select {
+		case subscription.Events <- event:
+		default:
+			log.Info().Msg("Tail subscription backlog full. Dropping event for this subscriber.")
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package tail
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/weaklayer/gateway/server/events"
+)
+
+func TestPublishMatchesFilter(t *testing.T) {
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+	sensor, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+
+	hub := NewHub()
+	subscription := hub.Subscribe("operator1", Filter{Group: group})
+
+	event := events.SensorEvent{
+		Type:   "Unknown",
+		Time:   1,
+		Sensor: sensor,
+		Group:  group,
+		Data:   make(map[string]interface{}),
+	}
+
+	hub.Publish(event)
+
+	select {
+	case received := <-subscription.Events:
+		if received.GetGroup() != group {
+			t.Fatalf("Received event for wrong group")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for published event")
+	}
+}
+
+func TestPublishSkipsNonMatchingFilter(t *testing.T) {
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+	otherGroup, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+	sensor, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+
+	hub := NewHub()
+	subscription := hub.Subscribe("operator1", Filter{Group: otherGroup})
+
+	event := events.SensorEvent{
+		Type:   "Unknown",
+		Time:   1,
+		Sensor: sensor,
+		Group:  group,
+		Data:   make(map[string]interface{}),
+	}
+
+	hub.Publish(event)
+
+	select {
+	case <-subscription.Events:
+		t.Fatalf("Received event that should have been filtered out")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSubscribeCancelsOverlappingSubscription(t *testing.T) {
+	hub := NewHub()
+	first := hub.Subscribe("operator1", Filter{})
+	second := hub.Subscribe("operator1", Filter{})
+
+	select {
+	case <-first.Cancelled:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected prior overlapping subscription to be cancelled")
+	}
+
+	select {
+	case <-second.Cancelled:
+		t.Fatalf("New subscription should not be cancelled")
+	default:
+	}
+}
+
+func TestUnsubscribeStopsDelivery(t *testing.T) {
+	sensor, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+	group, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+
+	hub := NewHub()
+	subscription := hub.Subscribe("operator1", Filter{})
+	hub.Unsubscribe(subscription)
+
+	event := events.SensorEvent{
+		Type:   "Unknown",
+		Time:   1,
+		Sensor: sensor,
+		Group:  group,
+		Data:   make(map[string]interface{}),
+	}
+
+	hub.Publish(event)
+
+	select {
+	case <-subscription.Events:
+		t.Fatalf("Received event after unsubscribing")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,53 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package tail
+
+import (
+	"context"
+
+	"github.com/weaklayer/gateway/server/events"
+)
+
+// NewTailOutput creates a TailOutput that publishes every consumed event to hub
+func NewTailOutput(hub *Hub) TailOutput {
+	return TailOutput{
+		hub: hub,
+	}
+}
+
+// TailOutput is an event output that publishes events to a Hub for live WebSocket tailing
+// It does not itself persist anything. It is meant to be used alongside other outputs.
+type TailOutput struct {
+	hub *Hub
+}
+
+// Close does nothing for TailOutput
+// It is implemented to conform with the Output interface
+func (tailOutput TailOutput) Close() {
+}
+
+// Consume publishes each event to the Hub's subscribers
+func (tailOutput TailOutput) Consume(ctx context.Context, events []events.Event) error {
+	for _, event := range events {
+		tailOutput.hub.Publish(event)
+	}
+
+	return nil
+}
@@ -20,33 +20,34 @@
 package processing
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
 
 	"github.com/weaklayer/gateway/server/events"
+	"github.com/weaklayer/gateway/server/output"
 )
 
-// EventProcessor takes events and sends them to their next destinations
-type EventProcessor struct{}
-
-// Consume is the entry point for processing events.
-// This process is asynchronous as there are multiple events and multiple destinations
-func (eventProcessor EventProcessor) Consume(events []events.Event) error {
-	for _, event := range events {
-		serializedBytes, err := json.Marshal(event)
-		if err != nil {
-			return err
-		}
-		_, err = fmt.Println(string(serializedBytes))
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+// NewEventProcessor creates an EventProcessor that fans incoming event batches out to every
+// given output sink. The zero value EventProcessor{} is also valid and simply discards events,
+// which callers that haven't wired up output sinks (such as install event logging) rely on.
+func NewEventProcessor(outputs []output.Output) EventProcessor {
+	return EventProcessor{topOutput: output.NewTopOutput(outputs)}
 }
 
-func (eventProcessor EventProcessor) consume(events []events.Event, errorChan chan error) {
+// EventProcessor is the fan-out dispatcher that forwards each batch of events it receives to
+// every configured output sink
+type EventProcessor struct {
+	topOutput output.TopOutput
+}
+
+// Consume sends events to every configured output sink, continuing to the rest even if one
+// returns an error. ctx is forwarded to every sink, which may return output.ErrBackpressure if
+// ctx is cancelled or the sink's queue is under backpressure.
+func (eventProcessor EventProcessor) Consume(ctx context.Context, events []events.Event) error {
+	return eventProcessor.topOutput.Consume(ctx, events)
+}
 
-	close(errorChan)
+// Close closes every configured output sink
+// Close should only be called once after Consume is guaranteed not to be called again
+func (eventProcessor EventProcessor) Close() {
+	eventProcessor.topOutput.Close()
 }
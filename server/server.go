@@ -26,16 +26,25 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/weaklayer/gateway/common/auth"
+	"github.com/weaklayer/gateway/common/envelope"
 	"github.com/weaklayer/gateway/server/api"
 	"github.com/weaklayer/gateway/server/output"
+	"github.com/weaklayer/gateway/server/output/elasticsearch"
 	"github.com/weaklayer/gateway/server/output/filesystem"
+	"github.com/weaklayer/gateway/server/output/kafka"
+	"github.com/weaklayer/gateway/server/output/s3"
 	"github.com/weaklayer/gateway/server/output/stdout"
+	"github.com/weaklayer/gateway/server/output/tail"
+	"github.com/weaklayer/gateway/server/processing"
+	"github.com/weaklayer/gateway/server/signing"
+	"github.com/weaklayer/gateway/server/telemetry"
 	"github.com/weaklayer/gateway/server/token"
 )
 
@@ -49,6 +58,16 @@ type Config struct {
 				Certificate string
 				Key         string
 				Password    string
+				ACME        ACMEConfig
+				ClientAuth  ClientAuthConfig
+			}
+			// MaxRequestBodySize is the maximum number of bytes accepted in a sensor API request body
+			MaxRequestBodySize int64
+			Events             struct {
+				// ChunkSize is the maximum number of NDJSON /events entries buffered before being processed
+				ChunkSize int
+				// ChunkInterval is the maximum number of microseconds NDJSON /events entries are buffered before being processed
+				ChunkInterval int64
 			}
 		}
 		Token struct {
@@ -57,20 +76,137 @@ type Config struct {
 				Current []byte
 				Past    [][]byte
 			}
+			PKCS11     auth.PKCS11Config
+			Asymmetric auth.AsymmetricConfig
+			Revocation struct {
+				Enabled bool
+				// Path is the revocation store file. It is created if it does not yet exist, and is
+				// reloaded on SIGHUP.
+				Path string
+			}
 		}
 		Install struct {
-			Verifiers []auth.Verifier
+			// Verifiers seeds the in-memory verifier store. Ignored if VerifierStore.Type is set to
+			// anything other than "memory".
+			Verifiers     []auth.Verifier
+			VerifierStore struct {
+				// Type selects which VerifierStore backend to use: "memory" (the default), "file", or
+				// "http". "memory" seeds from Verifiers and also backs approved device-code enrollments,
+				// so it is the only option EnrollAPI can be used with.
+				Type string
+				File auth.FileVerifierStoreConfig
+				HTTP auth.HTTPVerifierStoreConfig
+			}
+			OIDC struct {
+				Issuers []auth.OIDCIssuer
+			}
+		}
+		Enroll struct {
+			// VerificationURI is the operator-facing URL returned to sensors enrolling via the device-code flow
+			VerificationURI string
+			// Interval is the number of seconds a sensor should wait between polls of /v1/enroll/token
+			Interval int64
+			// TTL is the number of microseconds a device-code enrollment remains valid for before it expires
+			TTL int64
 		}
 	}
+	Operator struct {
+		Tail struct {
+			// Tokens are the bearer tokens operators use to authenticate to the /v1/tail WebSocket endpoint
+			Tokens []string
+		}
+	}
+	// Signing configures the gateway's own signing key, used to produce detached signatures over
+	// install responses and archived events so downstream consumers can detect tampering.
+	Signing   signing.Config
+	Outputs   []OutputConfig
+	Telemetry telemetry.Config
+}
+
+// OutputConfig configures a single entry in Config.Outputs. Only the fields relevant to Type are read.
+type OutputConfig struct {
+	Type      string
+	Directory string
+	Age       int64 // this is the file age, in microseconds, that the filesystem output will close files at
+	Size      int   // this is the file size, in bytes, that the filesystem output will close files at
+	// HighWaterMark is the queue depth at which the stdout and filesystem outputs start returning
+	// output.ErrBackpressure instead of accepting more events. A value <= 0 falls back to
+	// output.DefaultHighWaterMark.
+	HighWaterMark int
+	// Format is the filesystem output file format: "json" (the default) or "parquet"
+	Format     string
+	Encryption struct {
+		Enabled    bool
+		Recipients []envelope.Recipient
+	}
+	// Sync fsyncs the filesystem output after every write, trading throughput for durability.
+	Sync     bool
+	Rotation filesystem.RotationConfig
+	// DirMode is the octal permission mode, e.g. "0750", that the filesystem output's group and
+	// shard subdirectories are created with. Defaults to "0755" if empty.
+	DirMode       string
+	Kafka         kafka.Config
+	Elasticsearch elasticsearch.Config
+	S3            s3.Config
 }
 
-func createEventOutput(config Config) (output.Output, error) {
-	filesystemOutput, err := filesystem.NewFilesystemOutput(".")
+// parseDirMode parses an octal permission mode string, e.g. "0750", as used by OutputConfig.DirMode.
+// An empty string returns 0, leaving the filesystem output to fall back to its own default.
+func parseDirMode(dirMode string) (os.FileMode, error) {
+	if dirMode == "" {
+		return 0, nil
+	}
+
+	parsed, err := strconv.ParseUint(dirMode, 8, 32)
 	if err != nil {
-		return output.NewTopOutput([]output.Output{}), err
+		return 0, fmt.Errorf(`Invalid octal dirMode "%s": %w`, dirMode, err)
 	}
-	outputs := []output.Output{stdout.NewStdoutOutput(), filesystemOutput}
-	return output.NewTopOutput(outputs), nil
+
+	return os.FileMode(parsed), nil
+}
+
+func createEventProcessor(config Config, tailHub *tail.Hub, gatewaySigner *signing.Signer) (processing.EventProcessor, error) {
+	outputs := []output.Output{tail.NewTailOutput(tailHub)}
+
+	for _, configOutput := range config.Outputs {
+		switch configOutput.Type {
+		case "stdout":
+			outputs = append(outputs, stdout.NewStdoutOutput(configOutput.HighWaterMark))
+		case "filesystem":
+			dirMode, err := parseDirMode(configOutput.DirMode)
+			if err != nil {
+				return processing.EventProcessor{}, fmt.Errorf("Failed to parse dirMode for filesystem output: %w", err)
+			}
+
+			filesystemOutput, err := filesystem.NewFilesystemOutput(configOutput.Directory, configOutput.Encryption.Recipients, configOutput.Format, time.Duration(configOutput.Age)*time.Microsecond, configOutput.Size, configOutput.HighWaterMark, gatewaySigner, configOutput.Sync, configOutput.Rotation, dirMode)
+			if err != nil {
+				return processing.EventProcessor{}, fmt.Errorf("Failed to create filesystem output: %w", err)
+			}
+			outputs = append(outputs, filesystemOutput)
+		case "kafka":
+			kafkaOutput, err := kafka.NewKafkaOutput(configOutput.Kafka)
+			if err != nil {
+				return processing.EventProcessor{}, fmt.Errorf("Failed to create Kafka output: %w", err)
+			}
+			outputs = append(outputs, kafkaOutput)
+		case "elasticsearch":
+			elasticsearchOutput, err := elasticsearch.NewElasticsearchOutput(configOutput.Elasticsearch)
+			if err != nil {
+				return processing.EventProcessor{}, fmt.Errorf("Failed to create Elasticsearch output: %w", err)
+			}
+			outputs = append(outputs, elasticsearchOutput)
+		case "s3":
+			s3Output, err := s3.NewS3Output(configOutput.S3, time.Duration(configOutput.Age)*time.Microsecond, configOutput.Size)
+			if err != nil {
+				return processing.EventProcessor{}, fmt.Errorf("Failed to create S3 output: %w", err)
+			}
+			outputs = append(outputs, s3Output)
+		default:
+			return processing.EventProcessor{}, fmt.Errorf("Unknown output type %s", configOutput.Type)
+		}
+	}
+
+	return processing.NewEventProcessor(outputs), nil
 }
 
 // Run runs the Weaklayer Gateway Server
@@ -78,30 +214,107 @@ func Run(config Config) error {
 
 	log.Info().Msg("Starting Weaklayer Gateway Server")
 
-	topLevelEventOutput, err := createEventOutput(config)
+	telemetryHandle, err := telemetry.Start(config.Telemetry)
+	if err != nil {
+		return fmt.Errorf("Failed to start telemetry: %w", err)
+	}
+
+	tailHub := tail.NewHub()
+
+	var gatewaySigner *signing.Signer
+	if config.Signing.Enabled {
+		signer, err := signing.NewSigner(config.Signing)
+		if err != nil {
+			return fmt.Errorf("Failed to create gateway signing key: %w", err)
+		}
+		gatewaySigner = &signer
+	}
+
+	eventProcessor, err := createEventProcessor(config, tailHub, gatewaySigner)
 	if err != nil {
 		return fmt.Errorf("Failed to create desired outputs: %w", err)
 	}
 
-	tokenProcessor := token.NewProcessor(config.Sensor.Token.Secrets.Current, config.Sensor.Token.Secrets.Past, config.Sensor.Token.Duration/1000000)
-	installAPI, err := api.NewInstallAPI(tokenProcessor, topLevelEventOutput, config.Sensor.Install.Verifiers)
+	var tokenSigner auth.TokenSigner
+	if config.Sensor.Token.PKCS11.Enabled {
+		tokenSigner, err = auth.NewPKCS11Signer(config.Sensor.Token.PKCS11)
+		if err != nil {
+			return fmt.Errorf("Failed to create PKCS#11 token signer: %w", err)
+		}
+	} else if config.Sensor.Token.Asymmetric.Enabled {
+		tokenSigner, err = auth.NewAsymmetricSigner(config.Sensor.Token.Asymmetric)
+		if err != nil {
+			return fmt.Errorf("Failed to create asymmetric token signer: %w", err)
+		}
+	} else {
+		tokenSigner = auth.NewHMACSecretsSigner(config.Sensor.Token.Secrets.Current, config.Sensor.Token.Secrets.Past)
+	}
+
+	tokenProcessor := token.NewProcessor(tokenSigner, config.Sensor.Token.Duration/1000000)
+
+	var revocationStore *token.RevocationStore
+	if config.Sensor.Token.Revocation.Enabled {
+		revocationStore, err = token.NewRevocationStore(config.Sensor.Token.Revocation.Path)
+		if err != nil {
+			return fmt.Errorf("Failed to create sensor token revocation store: %w", err)
+		}
+	}
+
+	verifierRegistry := auth.NewVerifierRegistry(config.Sensor.Install.Verifiers)
+
+	var verifierStore auth.VerifierStore = verifierRegistry
+	var fileVerifierStore *auth.FileVerifierStore
+	switch config.Sensor.Install.VerifierStore.Type {
+	case "", "memory":
+		// verifierStore already defaults to verifierRegistry
+	case "file":
+		fileVerifierStore, err = auth.NewFileVerifierStore(config.Sensor.Install.VerifierStore.File.Path)
+		if err != nil {
+			return fmt.Errorf("Failed to create sensor install verifier file store: %w", err)
+		}
+		verifierStore = fileVerifierStore
+	case "http":
+		verifierStore = auth.NewHTTPVerifierStore(config.Sensor.Install.VerifierStore.HTTP)
+	default:
+		return fmt.Errorf("Unknown sensor.install.verifierStore.type %q", config.Sensor.Install.VerifierStore.Type)
+	}
+
+	signingKeys := auth.NewSigningKeyRegistry()
+
+	installAPI, err := api.NewInstallAPI(tokenProcessor, verifierStore, config.Sensor.Install.OIDC.Issuers, signingKeys, gatewaySigner)
 	if err != nil {
 		return fmt.Errorf("Failed to create sensor install API endpoint: %w", err)
 	}
 
-	eventsAPI, err := api.NewEventsAPI(tokenProcessor, topLevelEventOutput)
+	chunkInterval := time.Duration(config.Sensor.API.Events.ChunkInterval) * time.Microsecond
+	eventsAPI, err := api.NewEventsAPI(tokenProcessor, eventProcessor, signingKeys, revocationStore, config.Sensor.API.Events.ChunkSize, chunkInterval, config.Sensor.API.MaxRequestBodySize)
 	if err != nil {
 		return fmt.Errorf("Failed to create sensor events API endpoint: %w", err)
 	}
 
+	tailAPI := api.NewTailAPI(tailHub, config.Operator.Tail.Tokens)
+
+	jwksAPI := api.NewJWKSAPI(tokenSigner, gatewaySigner)
+
+	enrollmentStore := auth.NewEnrollmentStore(time.Duration(config.Sensor.Enroll.TTL) * time.Microsecond)
+	enrollAPI, err := api.NewEnrollAPI(enrollmentStore, verifierRegistry, config.Sensor.Install.OIDC.Issuers, config.Sensor.Enroll.VerificationURI, config.Sensor.Enroll.Interval)
+	if err != nil {
+		return fmt.Errorf("Failed to create sensor enroll API endpoint: %w", err)
+	}
+
 	sensorAPI := api.SensorAPI{
-		EventsHandler:  eventsAPI,
-		InstallHandler: installAPI,
+		EventsHandler:      eventsAPI,
+		InstallHandler:     installAPI,
+		TailHandler:        tailAPI,
+		EnrollHandler:      enrollAPI,
+		JWKSHandler:        jwksAPI,
+		MaxRequestBodySize: config.Sensor.API.MaxRequestBodySize,
 	}
 
 	var server *http.Server
-	if useTLS(config.Sensor.API.HTTPS.Certificate, config.Sensor.API.HTTPS.Key) {
-		tlsConfig, err := getTLSConfig(config.Sensor.API.HTTPS.Certificate, config.Sensor.API.HTTPS.Key, config.Sensor.API.HTTPS.Password)
+	var acmeChallengeServer *http.Server
+	if useTLS(config.Sensor.API.HTTPS.Certificate, config.Sensor.API.HTTPS.Key, config.Sensor.API.HTTPS.ACME) {
+		tlsConfig, acmeManager, err := getTLSConfig(config.Sensor.API.HTTPS.Certificate, config.Sensor.API.HTTPS.Key, config.Sensor.API.HTTPS.Password, config.Sensor.API.HTTPS.ACME, config.Sensor.API.HTTPS.ClientAuth)
 		if err != nil {
 			return fmt.Errorf("Failed to produce TLS config: %w", err)
 		}
@@ -119,6 +332,23 @@ func Run(config Config) error {
 				log.Error().Err(err).Msg("HTTP server error")
 			}
 		}()
+
+		// ACME's HTTP-01 challenge must be served over plain HTTP on the challenge port,
+		// using the same manager that issued the in-flight challenge token.
+		if acmeManager != nil {
+			acmeChallengeServer = &http.Server{
+				ErrorLog: stdlog.New(log.Logger, "", 0),
+				Addr:     fmt.Sprintf("%s:%d", config.Sensor.API.Host, httpChallengePort(config.Sensor.API.HTTPS.ACME)),
+				Handler:  acmeManager.HTTPHandler(nil),
+			}
+
+			go func() {
+				err := acmeChallengeServer.ListenAndServe()
+				if err != nil && err != http.ErrServerClosed {
+					log.Error().Err(err).Msg("ACME HTTP-01 challenge server error")
+				}
+			}()
+		}
 	} else {
 		server = &http.Server{
 			ErrorLog: stdlog.New(log.Logger, "", 0),
@@ -133,9 +363,24 @@ func Run(config Config) error {
 		}()
 	}
 
-	shutdown := make(chan os.Signal, 1)
-	signal.Notify(shutdown, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
-	<-shutdown
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			if revocationStore != nil {
+				if err := revocationStore.Reload(); err != nil {
+					log.Error().Err(err).Msg("Failed to reload sensor token revocation store")
+				}
+			}
+			if fileVerifierStore != nil {
+				if err := fileVerifierStore.Reload(); err != nil {
+					log.Error().Err(err).Msg("Failed to reload sensor install verifier file store")
+				}
+			}
+			continue
+		}
+		break
+	}
 
 	// Stop the HTTP server. Give 5 seconds max for this.
 	context, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -145,10 +390,19 @@ func Run(config Config) error {
 		log.Error().Err(err).Msg("Error shutting down HTTP server")
 	}
 
+	if acmeChallengeServer != nil {
+		err = acmeChallengeServer.Shutdown(context)
+		if err != nil {
+			log.Error().Err(err).Msg("Error shutting down ACME HTTP-01 challenge server")
+		}
+	}
+
 	// Requests are stopped now.
 	// Close outputs. Wait 1 seconds for it to happen.
-	topLevelEventOutput.Close()
+	eventProcessor.Close()
 	time.Sleep(1 * time.Second)
 
+	telemetryHandle.Shutdown(context)
+
 	return nil
 }
@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package signing produces detached JWS signatures over sensor API responses and archived event
+// data with the gateway's own signing key, a separate trust boundary from auth.TokenSigner, which
+// signs sensor auth tokens. This gives downstream consumers a way to detect tampering with
+// archived telemetry or API responses independent of the channel they received them over.
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	jose "gopkg.in/square/go-jose.v2"
+
+	"github.com/weaklayer/gateway/common/configsig"
+)
+
+// KeyConfig names a single PEM-encoded key on disk: PrivateKeyPath for the active signing key, or
+// PublicKeyPath for a retired key kept only so it can still be published for verification.
+type KeyConfig struct {
+	PrivateKeyPath string
+	PublicKeyPath  string
+}
+
+// Config configures the gateway signing key. Past entries are retired keys: the gateway never
+// signs with them again, but keeps publishing their public halves in the JWKS so consumers can
+// still verify archived data signed before a rotation.
+type Config struct {
+	Enabled bool
+	Current KeyConfig
+	Past    []KeyConfig
+}
+
+// Envelope is the signed, tamper-evident representation this package produces for archived
+// events: the raw event JSON alongside a detached JWS signature over it.
+type Envelope struct {
+	Payload   json.RawMessage `json:"payload"`
+	Signature string          `json:"signature"`
+}
+
+// Signer produces detached JWS signatures with the gateway's own signing key, and publishes the
+// active and retired public keys as a JWKS for offline verification.
+type Signer struct {
+	privateKey interface{}
+	alg        jose.SignatureAlgorithm
+	keyID      string
+	publicKeys []jose.JSONWebKey
+}
+
+// NewSigner loads the gateway signing key described by config, along with the public halves of
+// any retired keys listed under Past.
+func NewSigner(config Config) (Signer, error) {
+	privateKey, alg, err := configsig.LoadSigningKey(config.Current.PrivateKeyPath)
+	if err != nil {
+		return Signer{}, fmt.Errorf("Failed to load gateway signing key: %w", err)
+	}
+
+	publicKey, err := publicKeyFor(privateKey)
+	if err != nil {
+		return Signer{}, fmt.Errorf("Failed to derive public key for gateway signing key: %w", err)
+	}
+
+	keyID, err := fingerprintPublicKey(publicKey)
+	if err != nil {
+		return Signer{}, fmt.Errorf("Failed to derive key ID for gateway signing key: %w", err)
+	}
+
+	publicKeys := []jose.JSONWebKey{{Key: publicKey, KeyID: keyID, Algorithm: string(alg), Use: "sig"}}
+
+	for _, pastKey := range config.Past {
+		pastPublicKeyRaw, err := configsig.LoadVerificationKey(pastKey.PublicKeyPath, "")
+		if err != nil {
+			return Signer{}, fmt.Errorf("Failed to load retired gateway signing key %s: %w", pastKey.PublicKeyPath, err)
+		}
+
+		pastAlg, err := algorithmFor(pastPublicKeyRaw)
+		if err != nil {
+			return Signer{}, fmt.Errorf("Failed to determine algorithm for retired gateway signing key %s: %w", pastKey.PublicKeyPath, err)
+		}
+
+		pastKeyID, err := fingerprintPublicKey(pastPublicKeyRaw)
+		if err != nil {
+			return Signer{}, fmt.Errorf("Failed to derive key ID for retired gateway signing key %s: %w", pastKey.PublicKeyPath, err)
+		}
+
+		publicKeys = append(publicKeys, jose.JSONWebKey{Key: pastPublicKeyRaw, KeyID: pastKeyID, Algorithm: string(pastAlg), Use: "sig"})
+	}
+
+	return Signer{privateKey: privateKey, alg: alg, keyID: keyID, publicKeys: publicKeys}, nil
+}
+
+// Sign returns a detached JWS signature over payload, using the active gateway signing key.
+func (signer Signer) Sign(payload []byte) ([]byte, error) {
+	return configsig.SignConfig(payload, signer.privateKey, signer.alg, signer.keyID)
+}
+
+// SignEnvelope wraps payload and a detached signature over it in an Envelope, serialized as JSON
+// and ready to write to an archived output file.
+func (signer Signer) SignEnvelope(payload []byte) ([]byte, error) {
+	signature, err := signer.Sign(payload)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to sign payload: %w", err)
+	}
+
+	return json.Marshal(Envelope{Payload: payload, Signature: string(signature)})
+}
+
+// PublicJWKS publishes every key this signer knows about, active and retired, so downstream
+// consumers can verify archived events or API responses signed before a rotation.
+func (signer Signer) PublicJWKS() jose.JSONWebKeySet {
+	return jose.JSONWebKeySet{Keys: signer.publicKeys}
+}
+
+func publicKeyFor(privateKey interface{}) (crypto.PublicKey, error) {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return &key.PublicKey, nil
+	case *ecdsa.PrivateKey:
+		return &key.PublicKey, nil
+	case ed25519.PrivateKey:
+		return key.Public(), nil
+	default:
+		return nil, fmt.Errorf("Unsupported private key type %T", privateKey)
+	}
+}
+
+func algorithmFor(publicKey interface{}) (jose.SignatureAlgorithm, error) {
+	switch publicKey.(type) {
+	case *rsa.PublicKey:
+		return jose.RS256, nil
+	case *ecdsa.PublicKey:
+		return jose.ES256, nil
+	case ed25519.PublicKey:
+		return jose.EdDSA, nil
+	default:
+		return "", fmt.Errorf("Unsupported public key type %T", publicKey)
+	}
+}
+
+func fingerprintPublicKey(publicKey interface{}) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("Failed to marshal public key: %w", err)
+	}
+
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8]), nil
+}
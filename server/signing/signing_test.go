@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+func writeEd25519KeyPairPEM(t *testing.T, dir string, name string) (privatePath string, publicPath string) {
+	t.Helper()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 test key: %v", err)
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal private key: %v", err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+
+	privatePath = filepath.Join(dir, name+"-private.pem")
+	publicPath = filepath.Join(dir, name+"-public.pem")
+
+	if err := ioutil.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600); err != nil {
+		t.Fatalf("Failed to write %s: %v", privatePath, err)
+	}
+	if err := ioutil.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0600); err != nil {
+		t.Fatalf("Failed to write %s: %v", publicPath, err)
+	}
+
+	return privatePath, publicPath
+}
+
+func TestSignEnvelopeRoundTrips(t *testing.T) {
+	dir := t.TempDir()
+	privateKeyPath, _ := writeEd25519KeyPairPEM(t, dir, "active")
+
+	signer, err := NewSigner(Config{Enabled: true, Current: KeyConfig{PrivateKeyPath: privateKeyPath}})
+	if err != nil {
+		t.Fatalf("NewSigner failed: %v", err)
+	}
+
+	payload := []byte(`{"type":"PageLoad"}`)
+	envelopeBytes, err := signer.SignEnvelope(payload)
+	if err != nil {
+		t.Fatalf("SignEnvelope failed: %v", err)
+	}
+
+	var envelope Envelope
+	if err := json.Unmarshal(envelopeBytes, &envelope); err != nil {
+		t.Fatalf("Failed to unmarshal envelope: %v", err)
+	}
+
+	if string(envelope.Payload) != string(payload) {
+		t.Fatalf("Expected envelope payload %s, got %s", payload, envelope.Payload)
+	}
+
+	keySet := signer.PublicJWKS()
+	if len(keySet.Keys) != 1 {
+		t.Fatalf("Expected 1 published key, got %d", len(keySet.Keys))
+	}
+
+	object, err := jose.ParseDetached(envelope.Signature, []byte(envelope.Payload))
+	if err != nil {
+		t.Fatalf("Failed to parse detached signature: %v", err)
+	}
+
+	if err := object.DetachedVerify([]byte(envelope.Payload), keySet.Keys[0].Key); err != nil {
+		t.Fatalf("Failed to verify signature against published key: %v", err)
+	}
+}
+
+func TestNewSignerPublishesPastKeys(t *testing.T) {
+	dir := t.TempDir()
+	retiredPrivate, retiredPublic := writeEd25519KeyPairPEM(t, dir, "retired")
+	activePrivate, _ := writeEd25519KeyPairPEM(t, dir, "active")
+	_ = retiredPrivate
+
+	signer, err := NewSigner(Config{
+		Enabled: true,
+		Current: KeyConfig{PrivateKeyPath: activePrivate},
+		Past:    []KeyConfig{{PublicKeyPath: retiredPublic}},
+	})
+	if err != nil {
+		t.Fatalf("NewSigner failed: %v", err)
+	}
+
+	keySet := signer.PublicJWKS()
+	if len(keySet.Keys) != 2 {
+		t.Fatalf("Expected 2 published keys, got %d", len(keySet.Keys))
+	}
+}
@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package telemetry
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const instrumentationName = "github.com/weaklayer/gateway"
+
+var meter = otel.Meter(instrumentationName)
+
+// The instruments below are resolved from the global meter provider at package init, which
+// defaults to OpenTelemetry's no-op implementation until Start installs a real one. MustInt64*
+// panics on instrument creation failure, which only happens for a malformed name/description, so
+// this is safe to do at init.
+
+var eventsReceived = mustInt64Counter("weaklayer.events.received", "Number of sensor events received, by sensor and group")
+var eventsDropped = mustInt64Counter("weaklayer.events.dropped", "Number of sensor events dropped before reaching an output, by reason")
+var eventsSpilled = mustInt64Counter("weaklayer.events.spilled", "Number of sensor events spilled to an output's on-disk overflow because its in-memory queue was full")
+var outputBytesWritten = mustInt64Counter("weaklayer.output.bytes_written", "Number of event bytes written to an output")
+var outputFileRotations = mustInt64Counter("weaklayer.output.file_rotations", "Number of output file rotations")
+var responses = mustInt64Counter("weaklayer.api.responses", "Number of sensor API responses, by path and status class")
+var queueDepth = mustInt64Histogram("weaklayer.output.queue_depth", "Depth of an output's internal event queue at the time an event was enqueued or dequeued")
+var shardEventsDropped = mustInt64Counter("weaklayer.output.shard.events_dropped", "Number of events dropped from a sharded output's per-shard queue")
+var shardQueueDepth = mustInt64Histogram("weaklayer.output.shard.queue_depth", "Depth of a sharded output's per-shard queue at the time an event was enqueued")
+var shardWriteLatency = mustInt64Histogram("weaklayer.output.shard.write_latency", "Time, in microseconds, a sharded output took to write a single record to its backing store")
+
+func mustInt64Counter(name string, description string) metric.Int64Counter {
+	counter, err := meter.Int64Counter(name, metric.WithDescription(description))
+	if err != nil {
+		panic(err)
+	}
+	return counter
+}
+
+func mustInt64Histogram(name string, description string) metric.Int64Histogram {
+	histogram, err := meter.Int64Histogram(name, metric.WithDescription(description))
+	if err != nil {
+		panic(err)
+	}
+	return histogram
+}
+
+// RecordEventsReceived records count events received for the given sensor/group
+func RecordEventsReceived(ctx context.Context, count int64, sensor string, group string) {
+	eventsReceived.Add(ctx, count, metric.WithAttributes(attribute.String("sensor", sensor), attribute.String("group", group)))
+}
+
+// RecordEventsDropped records count events dropped for the given reason, e.g. "parse_failure" or
+// "queue_full"
+func RecordEventsDropped(ctx context.Context, count int64, reason string) {
+	eventsDropped.Add(ctx, count, metric.WithAttributes(attribute.String("reason", reason)))
+}
+
+// RecordEventsSpilled records count events spilled to the named output's on-disk overflow, e.g.
+// "filesystem"
+func RecordEventsSpilled(ctx context.Context, count int64, output string) {
+	eventsSpilled.Add(ctx, count, metric.WithAttributes(attribute.String("output", output)))
+}
+
+// RecordOutputBytesWritten records bytes written to the named output, e.g. "filesystem" or "stdout"
+func RecordOutputBytesWritten(ctx context.Context, bytes int64, output string) {
+	outputBytesWritten.Add(ctx, bytes, metric.WithAttributes(attribute.String("output", output)))
+}
+
+// RecordOutputFileRotation records a single file rotation for the named output
+func RecordOutputFileRotation(ctx context.Context, output string) {
+	outputFileRotations.Add(ctx, 1, metric.WithAttributes(attribute.String("output", output)))
+}
+
+// RecordResponse records a single sensor API response for path at the given HTTP status code
+func RecordResponse(ctx context.Context, path string, statusCode int) {
+	responses.Add(ctx, 1, metric.WithAttributes(attribute.String("path", path), attribute.String("statusClass", statusClass(statusCode))))
+}
+
+// RecordQueueDepth records the current depth of the named channel, e.g. "stdout.eventStrings" or
+// "filesystem.content"
+func RecordQueueDepth(ctx context.Context, channel string, depth int) {
+	queueDepth.Record(ctx, int64(depth), metric.WithAttributes(attribute.String("channel", channel)))
+}
+
+// RecordShardQueueDepth records the current depth of a single shard's queue in a sharded output,
+// e.g. one sharded by (group, sensor)
+func RecordShardQueueDepth(ctx context.Context, output string, shard string, depth int) {
+	shardQueueDepth.Record(ctx, int64(depth), metric.WithAttributes(attribute.String("output", output), attribute.String("shard", shard)))
+}
+
+// RecordShardEventsDropped records count events dropped from the named shard of a sharded output
+func RecordShardEventsDropped(ctx context.Context, output string, shard string, count int64) {
+	shardEventsDropped.Add(ctx, count, metric.WithAttributes(attribute.String("output", output), attribute.String("shard", shard)))
+}
+
+// RecordShardWriteLatency records how long, in microseconds, the named shard of a sharded output
+// took to write a single record
+func RecordShardWriteLatency(ctx context.Context, output string, shard string, micros int64) {
+	shardWriteLatency.Record(ctx, micros, metric.WithAttributes(attribute.String("output", output), attribute.String("shard", shard)))
+}
+
+func statusClass(statusCode int) string {
+	switch statusCode / 100 {
+	case 2:
+		return "2xx"
+	case 3:
+		return "3xx"
+	case 4:
+		return "4xx"
+	case 5:
+		return "5xx"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package telemetry wires up the gateway's OpenTelemetry metrics and tracing. Instrumentation
+// elsewhere in the gateway (see Counters, Histograms and StartSpan) always goes through the
+// global otel meter/tracer providers, so it is safe to call regardless of whether Start has been
+// invoked: with telemetry disabled, those providers are the OpenTelemetry no-op defaults.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+)
+
+// Config controls the gateway's OpenTelemetry metrics and tracing
+type Config struct {
+	// Enabled turns on the /metrics admin endpoint and OTLP span export. When false, Start does
+	// nothing and instrumentation calls remain no-ops.
+	Enabled bool
+	// MetricsAddress is the host:port the /metrics admin HTTP listener binds to, e.g. "localhost:9090"
+	MetricsAddress string
+	// OTLPEndpoint is the host:port of the OTLP/HTTP trace collector, e.g. "localhost:4318"
+	OTLPEndpoint string
+	// OTLPInsecure disables TLS when dialing OTLPEndpoint
+	OTLPInsecure bool
+}
+
+// Handle holds the resources Start provisioned and must be closed with Shutdown before the
+// gateway process exits
+type Handle struct {
+	metricsServer  *http.Server
+	tracerProvider *sdktrace.TracerProvider
+}
+
+// Start provisions the Prometheus metrics exporter and admin HTTP listener, and the OTLP trace
+// exporter, installing both as the global otel providers. It returns a no-op Handle if
+// config.Enabled is false.
+func Start(config Config) (Handle, error) {
+	if !config.Enabled {
+		return Handle{}, nil
+	}
+
+	res := resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("weaklayer-gateway"))
+
+	promExporter, err := prometheus.New()
+	if err != nil {
+		return Handle{}, fmt.Errorf("Failed to create Prometheus metrics exporter: %w", err)
+	}
+	meterProvider := metric.NewMeterProvider(metric.WithReader(promExporter), metric.WithResource(res))
+	otel.SetMeterProvider(meterProvider)
+
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", promhttp.Handler())
+	metricsServer := &http.Server{
+		Addr:    config.MetricsAddress,
+		Handler: metricsMux,
+	}
+	go func() {
+		err := metricsServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Error().Err(err).Msg("Metrics admin HTTP server error")
+		}
+	}()
+
+	traceExporterOptions := []otlptracehttp.Option{otlptracehttp.WithEndpoint(config.OTLPEndpoint)}
+	if config.OTLPInsecure {
+		traceExporterOptions = append(traceExporterOptions, otlptracehttp.WithInsecure())
+	}
+	traceExporter, err := otlptracehttp.New(context.Background(), traceExporterOptions...)
+	if err != nil {
+		return Handle{}, fmt.Errorf("Failed to create OTLP trace exporter: %w", err)
+	}
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tracerProvider)
+
+	return Handle{metricsServer: metricsServer, tracerProvider: tracerProvider}, nil
+}
+
+// Shutdown stops the metrics admin listener and flushes any spans still buffered for export. It
+// is a no-op on a Handle returned while telemetry was disabled.
+func (handle Handle) Shutdown(ctx context.Context) {
+	if handle.metricsServer != nil {
+		if err := handle.metricsServer.Shutdown(ctx); err != nil {
+			log.Error().Err(err).Msg("Error shutting down metrics admin HTTP server")
+		}
+	}
+
+	if handle.tracerProvider != nil {
+		shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := handle.tracerProvider.Shutdown(shutdownCtx); err != nil {
+			log.Error().Err(err).Msg("Error shutting down trace provider")
+		}
+	}
+}
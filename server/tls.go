@@ -25,13 +25,98 @@ import (
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
 )
 
-func useTLS(certificatePath string, keyPath string) bool {
-	return certificatePath != "" && keyPath != ""
+// defaultACMEHTTPChallengePort is used for the HTTP-01 challenge listener when the operator
+// doesn't configure one explicitly
+const defaultACMEHTTPChallengePort = 80
+
+// ACMEConfig holds the settings needed to provision certificates automatically
+// via an ACME directory such as Let's Encrypt instead of loading a static PEM file
+type ACMEConfig struct {
+	Enabled              bool
+	Hosts                []string
+	CacheDir             string
+	Email                string
+	DirectoryURL         string
+	TermsOfServiceAgreed bool
+	HTTPChallengePort    int32
+}
+
+func useTLS(certificatePath string, keyPath string, acmeConfig ACMEConfig) bool {
+	return (certificatePath != "" && keyPath != "") || acmeConfig.Enabled
+}
+
+// httpChallengePort returns the configured ACME HTTP-01 challenge port, or defaultACMEHTTPChallengePort if unset
+func httpChallengePort(acmeConfig ACMEConfig) int32 {
+	if acmeConfig.HTTPChallengePort != 0 {
+		return acmeConfig.HTTPChallengePort
+	}
+
+	return defaultACMEHTTPChallengePort
+}
+
+// getTLSConfig builds the *tls.Config that the sensor API's HTTPS listener should use. When ACME
+// is enabled, the returned autocert.Manager is non-nil; callers must mount its HTTPHandler on the
+// HTTP-01 challenge port for certificate issuance and renewal to succeed. When clientAuthConfig is
+// enabled, the returned config also requires and verifies sensor client certificates.
+func getTLSConfig(certificatePath string, keyPath string, keyPassword string, acmeConfig ACMEConfig, clientAuthConfig ClientAuthConfig) (*tls.Config, *autocert.Manager, error) {
+
+	var tlsConfig *tls.Config
+	var manager *autocert.Manager
+	var err error
+
+	if acmeConfig.Enabled {
+		tlsConfig, manager, err = getACMETLSConfig(acmeConfig)
+	} else {
+		tlsConfig, err = getFileTLSConfig(certificatePath, keyPath, keyPassword)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if clientAuthConfig.Enabled {
+		err = applyClientAuth(tlsConfig, clientAuthConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return tlsConfig, manager, nil
+}
+
+// getACMETLSConfig builds a *tls.Config and its backing autocert.Manager so that certificates
+// are requested and renewed automatically against an ACME directory. The returned config's
+// GetCertificate performs the TLS-ALPN-01 challenge; the manager is also returned so its
+// HTTPHandler can be mounted for the HTTP-01 challenge, which most ACME CAs also require.
+func getACMETLSConfig(acmeConfig ACMEConfig) (*tls.Config, *autocert.Manager, error) {
+	if !acmeConfig.TermsOfServiceAgreed {
+		return nil, nil, fmt.Errorf("Must agree to the ACME CA's terms of service to enable automatic certificate provisioning")
+	}
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(acmeConfig.CacheDir),
+		HostPolicy: autocert.HostWhitelist(acmeConfig.Hosts...),
+		Email:      acmeConfig.Email,
+	}
+
+	if acmeConfig.DirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: acmeConfig.DirectoryURL}
+	}
+
+	tlsConfig := manager.TLSConfig()
+	tlsConfig.MinVersion = tls.VersionTLS12
+
+	return tlsConfig, manager, nil
 }
 
-func getTLSConfig(certificatePath string, keyPath string, keyPassword string) (*tls.Config, error) {
+// getFileTLSConfig loads a static certificate/key pair from disk, as was always supported
+func getFileTLSConfig(certificatePath string, keyPath string, keyPassword string) (*tls.Config, error) {
 
 	certificateFileBytes, err := ioutil.ReadFile(certificatePath)
 	if err != nil {
@@ -25,7 +25,7 @@ import (
 )
 
 func TestUseTLS(t *testing.T) {
-	if useTLS("", "") {
+	if useTLS("", "", ACMEConfig{}) {
 		t.Fatal("Trying to use TLS when no certificate or key specified")
 	}
 }
@@ -35,7 +35,7 @@ func TestCertAndEncryptedKeyParsing(t *testing.T) {
 	keyPath := "test-crypt/example_key_encrypted.pem"
 	keyPassword := "examplekeypassword"
 
-	config, err := getTLSConfig(certPath, keyPath, keyPassword)
+	config, _, err := getTLSConfig(certPath, keyPath, keyPassword, ACMEConfig{}, ClientAuthConfig{})
 	if err != nil {
 		t.Fatal("Failed to import TLS certificate and private key", err)
 	}
@@ -49,7 +49,7 @@ func TestCertAndUnencryptedKeyParsing(t *testing.T) {
 	keyPath := "test-crypt/example_key_unencrypted.pem"
 	keyPassword := ""
 
-	config, err := getTLSConfig(certPath, keyPath, keyPassword)
+	config, _, err := getTLSConfig(certPath, keyPath, keyPassword, ACMEConfig{}, ClientAuthConfig{})
 	if err != nil {
 		t.Fatal("Failed to import TLS certificate and private key", err)
 	}
@@ -64,7 +64,7 @@ func TestMissingCert(t *testing.T) {
 	keyPath := "test-crypt/example_key_unencrypted.pem"
 	keyPassword := ""
 
-	_, err := getTLSConfig(certPath, keyPath, keyPassword)
+	_, _, err := getTLSConfig(certPath, keyPath, keyPassword, ACMEConfig{}, ClientAuthConfig{})
 	if err == nil {
 		t.Fatal("No error when trying to import missing certificate")
 	}
@@ -75,7 +75,7 @@ func TestMissingKey(t *testing.T) {
 	keyPath := "doesntexist.pem"
 	keyPassword := ""
 
-	_, err := getTLSConfig(certPath, keyPath, keyPassword)
+	_, _, err := getTLSConfig(certPath, keyPath, keyPassword, ACMEConfig{}, ClientAuthConfig{})
 	if err == nil {
 		t.Fatal("No error when trying to import missing key")
 	}
@@ -86,8 +86,71 @@ func TestBadPassword(t *testing.T) {
 	keyPath := "test-crypt/example_key_encrypted.pem"
 	keyPassword := "examplekeypassword11111"
 
-	_, err := getTLSConfig(certPath, keyPath, keyPassword)
+	_, _, err := getTLSConfig(certPath, keyPath, keyPassword, ACMEConfig{}, ClientAuthConfig{})
 	if err == nil {
 		t.Fatal("No error when trying to import encrypted key with bad password")
 	}
 }
+
+func TestUseTLSWithACMEEnabled(t *testing.T) {
+	if !useTLS("", "", ACMEConfig{Enabled: true, Hosts: []string{"example.com"}}) {
+		t.Fatal("Expected TLS to be in use when ACME is enabled")
+	}
+}
+
+func TestACMETLSConfig(t *testing.T) {
+	acmeConfig := ACMEConfig{
+		Enabled:              true,
+		Hosts:                []string{"example.com"},
+		CacheDir:             t.TempDir(),
+		Email:                "admin@example.com",
+		TermsOfServiceAgreed: true,
+	}
+
+	config, manager, err := getTLSConfig("", "", "", acmeConfig, ClientAuthConfig{})
+	if err != nil {
+		t.Fatal("Failed to produce ACME-backed TLS config", err)
+	}
+
+	if config.MinVersion != tls.VersionTLS12 {
+		t.Fatal("Minimum TLS version is not TLS1.2")
+	}
+
+	if config.GetCertificate == nil {
+		t.Fatal("ACME TLS config has no GetCertificate callback")
+	}
+
+	if manager == nil {
+		t.Fatal("ACME TLS config did not return its backing autocert.Manager")
+	}
+
+	if manager.HTTPHandler(nil) == nil {
+		t.Fatal("ACME manager produced a nil HTTP-01 challenge handler")
+	}
+}
+
+func TestACMETLSConfigRequiresTermsOfServiceAgreed(t *testing.T) {
+	acmeConfig := ACMEConfig{
+		Enabled:  true,
+		Hosts:    []string{"example.com"},
+		CacheDir: t.TempDir(),
+		Email:    "admin@example.com",
+	}
+
+	_, _, err := getTLSConfig("", "", "", acmeConfig, ClientAuthConfig{})
+	if err == nil {
+		t.Fatal("Expected an error when ACME is enabled without agreeing to the terms of service")
+	}
+}
+
+func TestHTTPChallengePortDefault(t *testing.T) {
+	if port := httpChallengePort(ACMEConfig{}); port != defaultACMEHTTPChallengePort {
+		t.Fatalf("Expected default HTTP-01 challenge port %d, got %d", defaultACMEHTTPChallengePort, port)
+	}
+}
+
+func TestHTTPChallengePortConfigured(t *testing.T) {
+	if port := httpChallengePort(ACMEConfig{HTTPChallengePort: 8080}); port != 8080 {
+		t.Fatalf("Expected configured HTTP-01 challenge port 8080, got %d", port)
+	}
+}
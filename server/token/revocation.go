@@ -0,0 +1,206 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package token
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bits-and-blooms/bloom/v3"
+	"github.com/google/uuid"
+)
+
+// revocationBloomFilterCapacity sizes the revocation bloom filter for the store's intended scale
+// of roughly a million revoked sensor/group ids.
+const revocationBloomFilterCapacity = 1000000
+
+// revocationBloomFilterFalsePositiveRate is the target false-positive rate for the revocation
+// bloom filter at revocationBloomFilterCapacity entries. Only ids that hit the filter fall
+// through to the slower exact check in RevocationStore.sensors/groups.
+const revocationBloomFilterFalsePositiveRate = 0.01
+
+// revocationEntryType distinguishes the two kinds of ids a revocation store entry can revoke
+type revocationEntryType string
+
+const (
+	revocationEntrySensor revocationEntryType = "sensor"
+	revocationEntryGroup  revocationEntryType = "group"
+)
+
+// revocationEntry is a single line of the on-disk revocation store
+type revocationEntry struct {
+	Type      revocationEntryType `json:"type"`
+	ID        uuid.UUID           `json:"id"`
+	RevokedAt int64               `json:"revokedAt"` // unix seconds
+}
+
+// RevocationMetrics is a point-in-time snapshot of a RevocationStore's bloom filter and exact
+// check counters
+type RevocationMetrics struct {
+	BloomHits            int64
+	BloomMisses          int64
+	ConfirmedRevocations int64
+}
+
+// RevocationStore is a file-backed, append-only record of revoked sensor and group ids. It is
+// reloadable at runtime, e.g. on SIGHUP, without restarting the process. A bloom filter fronts the
+// exact sensor/group maps so the common case of an unrevoked id never needs a map lookup.
+type RevocationStore struct {
+	path string
+
+	mutex   sync.RWMutex
+	filter  *bloom.BloomFilter
+	sensors map[uuid.UUID]int64
+	groups  map[uuid.UUID]int64
+
+	bloomHits            int64
+	bloomMisses          int64
+	confirmedRevocations int64
+}
+
+// NewRevocationStore creates a RevocationStore backed by the file at path, loading any entries
+// already present. The file is created if it does not yet exist.
+func NewRevocationStore(path string) (*RevocationStore, error) {
+	store := &RevocationStore{path: path}
+
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Reload re-reads the store's backing file and rebuilds the bloom filter and exact sets from
+// scratch. It is safe to call while IsRevoked runs concurrently, e.g. from a SIGHUP handler.
+func (store *RevocationStore) Reload() error {
+	file, err := os.OpenFile(store.path, os.O_CREATE|os.O_RDONLY, 0640)
+	if err != nil {
+		return fmt.Errorf("Failed to open revocation store %s: %w", store.path, err)
+	}
+	defer file.Close()
+
+	sensors := make(map[uuid.UUID]int64)
+	groups := make(map[uuid.UUID]int64)
+	filter := bloom.NewWithEstimates(revocationBloomFilterCapacity, revocationBloomFilterFalsePositiveRate)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var entry revocationEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("Failed to parse revocation store %s: %w", store.path, err)
+		}
+
+		switch entry.Type {
+		case revocationEntrySensor:
+			sensors[entry.ID] = entry.RevokedAt
+		case revocationEntryGroup:
+			groups[entry.ID] = entry.RevokedAt
+		default:
+			return fmt.Errorf("Unknown revocation entry type %s in %s", entry.Type, store.path)
+		}
+		filter.Add(entry.ID[:])
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Failed to read revocation store %s: %w", store.path, err)
+	}
+
+	store.mutex.Lock()
+	store.filter = filter
+	store.sensors = sensors
+	store.groups = groups
+	store.mutex.Unlock()
+
+	return nil
+}
+
+// RevokeSensor appends a sensor revocation to the revocation store at path, effective once the
+// store is reloaded.
+func RevokeSensor(path string, sensor uuid.UUID, revokedAt int64) error {
+	return appendRevocationEntry(path, revocationEntry{Type: revocationEntrySensor, ID: sensor, RevokedAt: revokedAt})
+}
+
+// RevokeGroup appends a group revocation to the revocation store at path, effective once the
+// store is reloaded.
+func RevokeGroup(path string, group uuid.UUID, revokedAt int64) error {
+	return appendRevocationEntry(path, revocationEntry{Type: revocationEntryGroup, ID: group, RevokedAt: revokedAt})
+}
+
+func appendRevocationEntry(path string, entry revocationEntry) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return fmt.Errorf("Failed to open revocation store %s: %w", path, err)
+	}
+	defer file.Close()
+
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("Failed to serialize revocation entry: %w", err)
+	}
+
+	if _, err := file.Write(append(entryBytes, '\n')); err != nil {
+		return fmt.Errorf("Failed to write revocation entry to %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// IsRevoked reports whether a token issued at issuedAt (unix seconds) for the given sensor/group
+// should be rejected: either the sensor or its group has been revoked, and the token was issued at
+// or before the revocation time.
+func (store *RevocationStore) IsRevoked(sensor uuid.UUID, group uuid.UUID, issuedAt int64) bool {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	if !store.filter.Test(sensor[:]) && !store.filter.Test(group[:]) {
+		atomic.AddInt64(&store.bloomMisses, 1)
+		return false
+	}
+	atomic.AddInt64(&store.bloomHits, 1)
+
+	if revokedAt, ok := store.sensors[sensor]; ok && issuedAt <= revokedAt {
+		atomic.AddInt64(&store.confirmedRevocations, 1)
+		return true
+	}
+
+	if revokedAt, ok := store.groups[group]; ok && issuedAt <= revokedAt {
+		atomic.AddInt64(&store.confirmedRevocations, 1)
+		return true
+	}
+
+	return false
+}
+
+// Metrics returns a snapshot of the store's bloom hit/miss and confirmed revocation counters
+func (store *RevocationStore) Metrics() RevocationMetrics {
+	return RevocationMetrics{
+		BloomHits:            atomic.LoadInt64(&store.bloomHits),
+		BloomMisses:          atomic.LoadInt64(&store.bloomMisses),
+		ConfirmedRevocations: atomic.LoadInt64(&store.confirmedRevocations),
+	}
+}
@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Copyright (C) 2020 Mitchell Wasson
+
+// This file is part of Weaklayer Gateway.
+
+// Weaklayer Gateway is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package token
+
+import (
+	"fmt"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/weaklayer/gateway/common/auth"
+)
+
+// signerAlgNames are the JWS algorithm names a token may be signed with, one per TokenSigner
+// implementation's Alg(): auth.HMACSignerAlg for the HMAC secret and PKCS#11-backed signers, and
+// RS256/ES256/EdDSA for an asymmetric signer. Each is registered with jwt-go so Processor can
+// sign and parse tokens under whichever one the configured signer advertises.
+var signerAlgNames = []string{auth.HMACSignerAlg, "RS256", "ES256", "EdDSA"}
+
+func init() {
+	for _, algName := range signerAlgNames {
+		algName := algName
+		jwt.RegisterSigningMethod(algName, func() jwt.SigningMethod {
+			return signerSigningMethod{algName: algName}
+		})
+	}
+}
+
+// signerSigningKey pairs an auth.TokenSigner with the specific key ID to sign or verify with
+type signerSigningKey struct {
+	signer auth.TokenSigner
+	keyID  string
+}
+
+// signerSigningMethod adapts an auth.TokenSigner to the jwt.SigningMethod interface that jwt-go
+// expects, so tokens can be signed and verified against any TokenSigner implementation regardless
+// of which JWS algorithm it advertises via Alg()
+type signerSigningMethod struct {
+	algName string
+}
+
+func (method signerSigningMethod) Alg() string {
+	return method.algName
+}
+
+func (method signerSigningMethod) Sign(signingString string, key interface{}) (string, error) {
+	signingKey, ok := key.(signerSigningKey)
+	if !ok {
+		return "", fmt.Errorf("Key must be a signerSigningKey to sign with %s", method.algName)
+	}
+
+	sig, err := signingKey.signer.Sign([]byte(signingString))
+	if err != nil {
+		return "", err
+	}
+
+	return jwt.EncodeSegment(sig), nil
+}
+
+func (method signerSigningMethod) Verify(signingString string, signature string, key interface{}) error {
+	signingKey, ok := key.(signerSigningKey)
+	if !ok {
+		return fmt.Errorf("Key must be a signerSigningKey to verify with %s", method.algName)
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !signingKey.signer.Verify([]byte(signingString), sig, signingKey.keyID) {
+		return jwt.ErrSignatureInvalid
+	}
+
+	return nil
+}
@@ -26,13 +26,14 @@ import (
 	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
+
+	"github.com/weaklayer/gateway/common/auth"
 )
 
 // Processor is a class for constructing and verifying JWTs
 type Processor struct {
-	currentSecret []byte
-	pastSecrets   [][]byte
-	duration      int64
+	signer   auth.TokenSigner
+	duration int64
 }
 
 // Claims contains the information that is put into the sensor JWTs
@@ -42,13 +43,12 @@ type Claims struct {
 	jwt.StandardClaims
 }
 
-// NewProcessor creates a new instance capable of creating and verifying tokens
-// duration is the number of seconds new tokens are valid for
-func NewProcessor(currentSecret []byte, pastSecrets [][]byte, duration int64) Processor {
+// NewProcessor creates a new instance capable of creating and verifying tokens.
+// signer provides the key material; duration is the number of seconds new tokens are valid for
+func NewProcessor(signer auth.TokenSigner, duration int64) Processor {
 	return Processor{
-		currentSecret: currentSecret,
-		pastSecrets:   pastSecrets,
-		duration:      duration,
+		signer:   signer,
+		duration: duration,
 	}
 }
 
@@ -69,9 +69,12 @@ func (tokenProcessor Processor) NewToken(group uuid.UUID, sensor uuid.UUID) (str
 		},
 	}
 
-	unsignedToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	activeKeyID := tokenProcessor.signer.ActiveKeyID()
+
+	unsignedToken := jwt.NewWithClaims(signerSigningMethod{algName: tokenProcessor.signer.Alg()}, claims)
+	unsignedToken.Header["kid"] = activeKeyID
 
-	tokenString, err := unsignedToken.SignedString(tokenProcessor.currentSecret)
+	tokenString, err := unsignedToken.SignedString(signerSigningKey{signer: tokenProcessor.signer, keyID: activeKeyID})
 	if err != nil {
 		return retVal, expiresAt, issuedAt, fmt.Errorf("Failed to create signed token: %w", err)
 	}
@@ -84,31 +87,15 @@ func (tokenProcessor Processor) NewToken(group uuid.UUID, sensor uuid.UUID) (str
 // VerifyToken checks the token signature and time validity.
 // Returns a boolean indicating if the token is valid or not.
 func (tokenProcessor Processor) VerifyToken(tokenString string) (bool, Claims) {
-
-	isValid, claims := tryTokenVerification(tokenString, tokenProcessor.currentSecret)
-	if isValid {
-		return true, claims
-	}
-
-	for _, secret := range tokenProcessor.pastSecrets {
-		isValid, claims = tryTokenVerification(tokenString, secret)
-		if isValid {
-			return true, claims
-		}
-	}
-
-	log.Info().Str("token", tokenString).Msg("Invalid token provided")
-	return false, claims
-}
-
-func tryTokenVerification(tokenString string, secret []byte) (bool, Claims) {
 	var claims Claims
 
 	token, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
-		if method, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || method.Name != "HS256" {
-			return nil, fmt.Errorf("Unexpected token signature algorithm '%s'", method.Name)
+		if _, ok := token.Method.(signerSigningMethod); !ok {
+			return nil, fmt.Errorf("Unexpected token signature algorithm '%s'", token.Method.Alg())
 		}
-		return secret, nil
+
+		keyID, _ := token.Header["kid"].(string)
+		return signerSigningKey{signer: tokenProcessor.signer, keyID: keyID}, nil
 	})
 
 	if err != nil {
@@ -32,7 +32,7 @@ func TestNewToken(t *testing.T) {
 	pastSecrets := make([][]byte, 2)
 	pastSecrets[0] = []byte("seeeeeeecret1")
 	pastSecrets[1] = []byte("seeeeeeecret2")
-	tokenProcessor := NewProcessor([]byte("hello"), pastSecrets, 24*60)
+	tokenProcessor := NewProcessor(auth.NewHMACSecretsSigner([]byte("hello"), pastSecrets), 24*60)
 
 	group, err := uuid.NewRandom()
 	if err != nil {
@@ -66,11 +66,11 @@ func TestNewToken(t *testing.T) {
 
 func TestPastTokenSecret(t *testing.T) {
 	pastSecrets1 := make([][]byte, 0)
-	tokenProcessor1 := NewProcessor([]byte("hello"), pastSecrets1, 24*60)
+	tokenProcessor1 := NewProcessor(auth.NewHMACSecretsSigner([]byte("hello"), pastSecrets1), 24*60)
 
 	pastSecrets2 := make([][]byte, 1)
 	pastSecrets2[0] = []byte("hello")
-	tokenProcessor2 := NewProcessor([]byte("hellosdfa"), pastSecrets2, 24*60)
+	tokenProcessor2 := NewProcessor(auth.NewHMACSecretsSigner([]byte("hellosdfa"), pastSecrets2), 24*60)
 
 	group, err := uuid.NewRandom()
 	if err != nil {
@@ -106,7 +106,7 @@ func TestSigningAlgNone(t *testing.T) {
 	pastSecrets := make([][]byte, 2)
 	pastSecrets[0] = []byte("seeeeeeecret1")
 	pastSecrets[1] = []byte("seeeeeeecret2")
-	tokenProcessor := NewProcessor([]byte("hello"), pastSecrets, 24*60)
+	tokenProcessor := NewProcessor(auth.NewHMACSecretsSigner([]byte("hello"), pastSecrets), 24*60)
 
 	group, err := uuid.NewRandom()
 	if err != nil {